@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ActionCable protocol constants, matching ActionCable::INTERNAL in Rails
+const (
+	ActionCableProtocol            = "actioncable-v1-json"
+	ActionCableUnsupportedProtocol = "actioncable-unsupported"
+
+	// actionCablePingInterval matches the Rails server beat. The JS client
+	// treats a connection as stale after two missed pings.
+	actionCablePingInterval = 3 * time.Second
+)
+
+// ActionCable message types sent by the server
+const (
+	MessageTypeWelcome      = "welcome"
+	MessageTypeDisconnect   = "disconnect"
+	MessageTypePing         = "ping"
+	MessageTypeConfirmation = "confirm_subscription"
+	MessageTypeRejection    = "reject_subscription"
+)
+
+// ActionCable disconnect reasons
+const (
+	DisconnectUnauthorized   = "unauthorized"
+	DisconnectInvalidRequest = "invalid_request"
+	DisconnectServerRestart  = "server_restart"
+	DisconnectRemote         = "remote"
+
+	// DisconnectSlowConsumer is not a Rails reason; clients treat it like
+	// any other reason and reconnect when allowed
+	DisconnectSlowConsumer = "slow_consumer"
+)
+
+// actionCableEventType returns the type to tag a broadcast with. The
+// ActionCable client hands frames of any unknown type to the subscription,
+// so only its own protocol types must be avoided.
+func actionCableEventType(eventType string) string {
+	switch eventType {
+	case MessageTypeWelcome, MessageTypeDisconnect, MessageTypePing, MessageTypeConfirmation, MessageTypeRejection:
+		return ""
+	default:
+		return eventType
+	}
+}
+
+// actionCableBroadcast encodes an event as a broadcast to one subscription
+func actionCableBroadcast(identifier string, event *Event) ([]byte, error) {
+	return actionCableFrame(identifier, event.Type, event.JSON)
+}
+
+// actionCableFrame wraps an encoded message for one subscription
+func actionCableFrame(identifier, eventType string, message []byte) ([]byte, error) {
+	return json.Marshal(ActionCableMessage{
+		Identifier: identifier,
+		Message:    json.RawMessage(message),
+		Type:       actionCableEventType(eventType),
+	})
+}
+
+// deltaBroadcast returns an encoder for an event sent to a json-patch
+// subscription, run by the connection's writer
+func deltaBroadcast(sub *ChannelSubscription, event *Event) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		message, err := sub.Delta.Encode(event)
+		if err != nil {
+			return nil, err
+		}
+		return actionCableFrame(sub.Identifier, event.Type, message)
+	}
+}
+
+// ChannelSubscription is one confirmed ActionCable subscription. The
+// identifier is kept verbatim because the JS client matches broadcasts to
+// subscriptions by exact identifier string.
+type ChannelSubscription struct {
+	Identifier   string
+	ChannelClass string
+	Params       map[string]interface{}
+	Streams      []string
+	Delta        *DeltaEncoder // Set when the identifier asks for "encoding": "json-patch"
+	Throttle     *Throttle     // Set when updates are rate limited
+	Filter       *Filter       // Set when the identifier carries a "filter" expression
+
+	// Snapshot keys of live frames queued before the subscription's
+	// snapshot, which must not be overwritten by it; nil once it is sent
+	liveKeys map[string]bool
+	liveMu   sync.Mutex
+}
+
+// streamsTo reports whether the subscription receives the given stream
+func (c *ChannelSubscription) streamsTo(stream string) bool {
+	for _, s := range c.Streams {
+		if s == stream {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIdentifier decodes an ActionCable identifier. Params other than
+// "channel" may be of any JSON type.
+func parseIdentifier(identifier string) (string, map[string]interface{}, error) {
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(identifier), &params); err != nil {
+		return "", nil, err
+	}
+	channelClass, _ := params["channel"].(string)
+	if channelClass == "" {
+		return "", nil, fmt.Errorf("identifier has no channel")
+	}
+	return channelClass, params, nil
+}
+
+// DisconnectMessage is the ActionCable disconnect frame
+type DisconnectMessage struct {
+	Type      string `json:"type"`
+	Reason    string `json:"reason,omitempty"`
+	Reconnect bool   `json:"reconnect"`
+}
+
+// Disconnect asks the connection's handler to send a disconnect frame with
+// the given reason and close. Only the first call has any effect.
+func (c *WebSocketConnection) Disconnect(reason string, reconnect bool) {
+	c.closeOnce.Do(func() {
+		c.closeMessage = DisconnectMessage{Type: MessageTypeDisconnect, Reason: reason, Reconnect: reconnect}
+		close(c.Done)
+	})
+}
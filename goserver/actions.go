@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Channel action defaults
+const (
+	defaultActionReplyChannel = "goserver:action_replies"
+	defaultActionReplyTimeout = 30 * time.Second
+	defaultActionWebhookTime  = 5 * time.Second
+	actionForwardTimeout      = 5 * time.Second
+	actionWebhookAgent        = "goserver-actions/1.0"
+
+	// MessageTypeActionReply tags replies to channel actions
+	MessageTypeActionReply = "action_reply"
+)
+
+// Action reply statuses
+const (
+	ActionStatusOK    = "ok"
+	ActionStatusError = "error"
+)
+
+// ChannelAction is an action clients may perform on a channel, forwarded to
+// the Rails app for processing
+type ChannelAction struct {
+	Params map[string]string `json:"params"` // Param -> regexp its value must fully match; all are required
+	Roles  []string          `json:"roles"`  // Caller needs at least one of these roles
+	Target ActionTarget      `json:"target"`
+	Reply  bool              `json:"reply"` // Route the Rails app's answer back to the client
+
+	patterns map[string]*regexp.Regexp
+}
+
+// ActionTarget is where an action is forwarded; exactly one field is set
+type ActionTarget struct {
+	RedisList   string `json:"redis_list"`   // LPUSHed for a worker to BRPOP
+	RedisStream string `json:"redis_stream"` // XADDed with the request in the "data" field
+	Webhook     string `json:"webhook"`      // POSTed, answering synchronously
+	TimeoutMS   int    `json:"timeout_ms"`   // Webhook timeout
+}
+
+// compile validates an action definition
+func (a *ChannelAction) compile() error {
+	targets := 0
+	for _, target := range []string{a.Target.RedisList, a.Target.RedisStream, a.Target.Webhook} {
+		if target != "" {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return fmt.Errorf("needs exactly one of redis_list, redis_stream or webhook")
+	}
+
+	a.patterns = make(map[string]*regexp.Regexp)
+	for param, pattern := range a.Params {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("param %s: %w", param, err)
+		}
+		a.patterns[param] = re
+	}
+	return nil
+}
+
+// validate checks the performed data against the action's params, returning
+// only the declared params
+func (a *ChannelAction) validate(data map[string]interface{}) (map[string]string, error) {
+	params := make(map[string]string, len(a.patterns))
+	for name, re := range a.patterns {
+		value, ok := paramString(data[name])
+		if !ok {
+			return nil, fmt.Errorf("missing param %s", name)
+		}
+		if !re.MatchString(value) {
+			return nil, fmt.Errorf("param %s value %q not allowed", name, value)
+		}
+		params[name] = value
+	}
+	return params, nil
+}
+
+// ActionRequest is what the Rails app receives for each performed action
+type ActionRequest struct {
+	RequestID    string                 `json:"request_id"`
+	Action       string                 `json:"action"`
+	Channel      string                 `json:"channel"`
+	Identifier   string                 `json:"identifier"`
+	Params       map[string]string      `json:"params"`
+	Subscription map[string]interface{} `json:"subscription"` // Identifier params
+	Subject      string                 `json:"subject"`
+	Method       string                 `json:"method"`
+	Claims       map[string]interface{} `json:"claims"`
+	ConnectionID string                 `json:"connection_id"`
+	ReplyTo      string                 `json:"reply_to,omitempty"` // Broker channel to publish the reply on
+	SentAt       time.Time              `json:"sent_at"`
+}
+
+// ActionReply is sent back to the subscription that performed an action
+type ActionReply struct {
+	Type      string      `json:"type"`
+	Action    string      `json:"action"`
+	RequestID string      `json:"request_id"`
+	Ref       string      `json:"ref,omitempty"` // Echo of the client's own "ref"
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// pendingAction is an action awaiting its reply over the broker
+type pendingAction struct {
+	conn       *WebSocketConnection
+	identifier string
+	reply      ActionReply
+	timer      *time.Timer
+}
+
+// ActionRouter validates performed channel actions, forwards them to their
+// targets and routes replies back to the connection that performed them
+type ActionRouter struct {
+	server       *Server
+	redis        *redis.Client
+	replyChannel string
+	replyTimeout time.Duration
+	pending      map[string]*pendingAction // Request ID -> waiting connection
+	mu           sync.Mutex
+}
+
+// NewActionRouterFromEnv creates the router. Replies to Redis-forwarded
+// actions are published by the Rails app on ACTION_REPLY_CHANNEL as
+// {"request_id": ..., "status": "ok"|"error", "data": ..., "error": ...}.
+func NewActionRouterFromEnv(server *Server) *ActionRouter {
+	router := &ActionRouter{
+		server:       server,
+		replyChannel: os.Getenv("ACTION_REPLY_CHANNEL"),
+		replyTimeout: time.Duration(getEnvInt("ACTION_REPLY_TIMEOUT_SECONDS", int(defaultActionReplyTimeout/time.Second))) * time.Second,
+		pending:      make(map[string]*pendingAction),
+	}
+	if router.replyChannel == "" {
+		router.replyChannel = defaultActionReplyChannel
+	}
+
+	count := 0
+	for _, def := range server.channels.channels {
+		for _, action := range def.Actions {
+			count++
+			if action.Target.Webhook == "" && router.redis == nil {
+				router.redis = newRedisClientFromEnv(server.logger)
+			}
+		}
+	}
+	if count > 0 {
+		server.logger.Info("🎬 %d channel actions configured", count)
+	}
+	return router
+}
+
+// NeedsReplies reports whether any action's reply comes back over the broker
+func (a *ActionRouter) NeedsReplies() bool {
+	for _, def := range a.server.channels.channels {
+		for _, action := range def.Actions {
+			if action.Reply && action.Target.Webhook == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReplyChannel is the broker channel replies are published on
+func (a *ActionRouter) ReplyChannel() string {
+	return a.replyChannel
+}
+
+// Perform validates an action performed on a subscription and forwards it.
+// Refused actions are answered with an error reply when the action replies.
+func (a *ActionRouter) Perform(conn *WebSocketConnection, sub *ChannelSubscription, name string, action *ChannelAction, data map[string]interface{}) {
+	reply := ActionReply{Type: MessageTypeActionReply, Action: name, RequestID: newRequestID()}
+	reply.Ref, _ = data["ref"].(string)
+
+	params, err := action.validate(data)
+	if err == nil && len(action.Roles) > 0 && !a.server.channels.Authorizer().hasRole(conn.Identity, action.Roles) {
+		err = fmt.Errorf("action requires role %v", action.Roles)
+	}
+	if err != nil {
+		a.server.logger.Warn("🚫 %s#%s refused for %s on connection %s: %v", sub.ChannelClass, name, conn.Identity.Subject, conn.ID, err)
+		if action.Reply {
+			a.fail(conn, sub.Identifier, reply, err)
+		}
+		return
+	}
+
+	request := ActionRequest{
+		RequestID:    reply.RequestID,
+		Action:       name,
+		Channel:      sub.ChannelClass,
+		Identifier:   sub.Identifier,
+		Params:       params,
+		Subscription: sub.Params,
+		Subject:      conn.Identity.Subject,
+		Method:       conn.Identity.Method,
+		Claims:       conn.Identity.Claims,
+		ConnectionID: conn.ID,
+		SentAt:       time.Now(),
+	}
+	if action.Reply && action.Target.Webhook == "" {
+		request.ReplyTo = a.replyChannel
+		a.await(conn, sub.Identifier, reply)
+	}
+
+	// Forward off the connection's read loop, so a slow target cannot stall it
+	go func() {
+		result, err := a.forward(conn.Identity, action, request)
+		switch {
+		case err != nil:
+			a.server.logger.Error("Error forwarding %s#%s: %v", sub.ChannelClass, name, err)
+			if action.Reply {
+				a.mu.Lock()
+				delete(a.pending, reply.RequestID)
+				a.mu.Unlock()
+				a.fail(conn, sub.Identifier, reply, err)
+			}
+			return
+		case action.Reply && action.Target.Webhook != "":
+			reply.Status = ActionStatusOK
+			reply.Data = result
+			a.send(conn, sub.Identifier, reply)
+		}
+		a.server.logger.Debug("🎬 Forwarded %s#%s for connection %s (request %s)", sub.ChannelClass, name, conn.ID, reply.RequestID)
+	}()
+}
+
+// forward hands a request to the action's target, returning the webhook's
+// decoded answer if there is one
+func (a *ActionRouter) forward(identity *Identity, action *ChannelAction, request ActionRequest) (interface{}, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	target := action.Target
+	if target.Webhook == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), actionForwardTimeout)
+		defer cancel()
+		if target.RedisList != "" {
+			return nil, a.redis.LPush(ctx, target.RedisList, body).Err()
+		}
+		return nil, a.redis.XAdd(ctx, &redis.XAddArgs{
+			Stream: target.RedisStream,
+			Values: map[string]interface{}{"data": body},
+		}).Err()
+	}
+
+	timeout := defaultActionWebhookTime
+	if target.TimeoutMS > 0 {
+		timeout = time.Duration(target.TimeoutMS) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range identity.credentials {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", actionWebhookAgent)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, defaultPublishMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+
+	var result interface{}
+	if len(bytes.TrimSpace(answer)) > 0 {
+		if err := json.Unmarshal(answer, &result); err != nil {
+			return nil, fmt.Errorf("webhook answer is not JSON: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// await registers a request whose reply will arrive over the broker,
+// failing it if none comes in time
+func (a *ActionRouter) await(conn *WebSocketConnection, identifier string, reply ActionReply) {
+	pending := &pendingAction{conn: conn, identifier: identifier, reply: reply}
+	pending.timer = time.AfterFunc(a.replyTimeout, func() {
+		a.mu.Lock()
+		_, waiting := a.pending[reply.RequestID]
+		delete(a.pending, reply.RequestID)
+		a.mu.Unlock()
+		if waiting {
+			a.fail(conn, identifier, reply, fmt.Errorf("no reply within %s", a.replyTimeout))
+		}
+	})
+
+	a.mu.Lock()
+	a.pending[reply.RequestID] = pending
+	a.mu.Unlock()
+}
+
+// HandleReply routes a reply published on the reply channel to the
+// connection waiting for it. Replies for other instances are ignored.
+func (a *ActionRouter) HandleReply(data interface{}) {
+	message, _ := data.(map[string]interface{})
+	requestID, _ := message["request_id"].(string)
+
+	a.mu.Lock()
+	pending, ok := a.pending[requestID]
+	delete(a.pending, requestID)
+	a.mu.Unlock()
+	if !ok {
+		a.server.logger.Debug("Ignoring action reply for unknown request %q", requestID)
+		return
+	}
+	pending.timer.Stop()
+
+	reply := pending.reply
+	reply.Status, _ = message["status"].(string)
+	if reply.Status != ActionStatusError {
+		reply.Status = ActionStatusOK
+	}
+	reply.Data = message["data"]
+	reply.Error, _ = message["error"].(string)
+	a.send(pending.conn, pending.identifier, reply)
+}
+
+// fail sends an error reply
+func (a *ActionRouter) fail(conn *WebSocketConnection, identifier string, reply ActionReply, err error) {
+	reply.Status = ActionStatusError
+	reply.Error = err.Error()
+	a.send(conn, identifier, reply)
+}
+
+// send queues a reply for the subscription, if it is still there
+func (a *ActionRouter) send(conn *WebSocketConnection, identifier string, reply ActionReply) {
+	conn.mu.RLock()
+	_, subscribed := conn.Subscriptions[identifier]
+	conn.mu.RUnlock()
+	if !subscribed {
+		return
+	}
+
+	message, err := json.Marshal(reply)
+	if err == nil {
+		var frame []byte
+		if frame, err = actionCableFrame(identifier, MessageTypeActionReply, message); err == nil {
+			a.server.queueWebSocketFrame(conn, &OutboundFrame{Data: frame, Key: identifier + "\x00" + reply.RequestID})
+			return
+		}
+	}
+	a.server.logger.Error("Error marshaling action reply: %v", err)
+}
+
+// newRequestID returns a random ID for correlating an action with its reply
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
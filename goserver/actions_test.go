@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testActionsConfig declares AlertsChannel actions; the webhook URL is
+// filled in per test
+const testActionsConfig = `{
+	"channels": [{
+		"class": "AlertsChannel",
+		"streams": ["alerts"],
+		"actions": {
+			"acknowledge_alert": {
+				"params": {"alert_id": "[0-9]+"},
+				"roles": ["admin", "oncall"],
+				"target": {"webhook": "WEBHOOK_URL"},
+				"reply": true
+			},
+			"escalate": {
+				"params": {"alert_id": "[0-9]+"},
+				"target": {"redis_list": "escalations"},
+				"reply": true
+			}
+		}
+	}],
+	"stream_authorization": {"roles_claim": "app.roles"}
+}`
+
+// newActionServer builds a server whose acknowledge_alert action posts to
+// webhook, with Redis pointed at a closed port
+func newActionServer(t *testing.T, webhook http.HandlerFunc) *Server {
+	t.Helper()
+	target := httptest.NewServer(webhook)
+	t.Cleanup(target.Close)
+
+	path := t.TempDir() + "/channels.json"
+	config := strings.Replace(testActionsConfig, "WEBHOOK_URL", target.URL, 1)
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return newTestServer(t, map[string]string{"CHANNELS_CONFIG": path, "REDIS_URL": "redis://127.0.0.1:1"})
+}
+
+// testWSConnection builds a WebSocket connection holding one AlertsChannel
+// subscription, whose frames queue up without being written anywhere
+func testWSConnection(s *Server, roles ...interface{}) (*WebSocketConnection, *ChannelSubscription) {
+	sub := &ChannelSubscription{
+		Identifier:   `{"channel":"AlertsChannel"}`,
+		ChannelClass: "AlertsChannel",
+		Params:       map[string]interface{}{"channel": "AlertsChannel"},
+		Streams:      []string{"alerts"},
+	}
+	conn := &WebSocketConnection{
+		ID:            s.generateConnectionID(),
+		Identity:      claimsIdentity("u1", map[string]interface{}{"app": map[string]interface{}{"roles": roles}}),
+		Subscriptions: map[string]*ChannelSubscription{sub.Identifier: sub},
+		Queue:         NewSendQueue(s.wsQueueSize, s.wsPolicy),
+		Done:          make(chan struct{}),
+	}
+	return conn, sub
+}
+
+// perform runs an action with the given data on the connection's subscription
+func perform(s *Server, conn *WebSocketConnection, sub *ChannelSubscription, name, data string) {
+	var decoded map[string]interface{}
+	json.Unmarshal([]byte(data), &decoded)
+	def, _ := s.channels.Lookup(sub.ChannelClass)
+	s.actions.Perform(conn, sub, name, def.Actions[name], decoded)
+}
+
+// waitForReply waits for the next action reply queued on a connection
+func waitForReply(t *testing.T, conn *WebSocketConnection, sub *ChannelSubscription) ActionReply {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.Queue.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	frame, ok := conn.Queue.Pop()
+	if !ok {
+		t.Fatal("no action reply queued")
+	}
+	var message struct {
+		Identifier string          `json:"identifier"`
+		Type       string          `json:"type"`
+		Message    json.RawMessage `json:"message"`
+	}
+	if err := json.Unmarshal(frame.Data, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Identifier != sub.Identifier || message.Type != MessageTypeActionReply {
+		t.Errorf("reply frame %s, want an action_reply for %s", frame.Data, sub.Identifier)
+	}
+	var reply ActionReply
+	if err := json.Unmarshal(message.Message, &reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestActionRefused(t *testing.T) {
+	var calls atomic.Int32
+	s := newActionServer(t, func(w http.ResponseWriter, r *http.Request) { calls.Add(1) })
+
+	tests := []struct {
+		name    string
+		roles   []interface{}
+		data    string
+		wantErr string
+	}{
+		{"no roles", nil, `{"alert_id":"42","ref":"r1"}`, "action requires role [admin oncall]"},
+		{"other role", []interface{}{"viewer"}, `{"alert_id":"42","ref":"r1"}`, "action requires role"},
+		{"missing param", []interface{}{"oncall"}, `{"ref":"r1"}`, "missing param alert_id"},
+		{"param not a string", []interface{}{"oncall"}, `{"alert_id":{"id":42},"ref":"r1"}`, "missing param alert_id"},
+		{"param not allowed", []interface{}{"oncall"}, `{"alert_id":"42; DROP","ref":"r1"}`, `param alert_id value "42; DROP" not allowed`},
+		{"partial match", []interface{}{"admin"}, `{"alert_id":"42a","ref":"r1"}`, "not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, sub := testWSConnection(s, tt.roles...)
+			perform(s, conn, sub, "acknowledge_alert", tt.data)
+
+			reply := waitForReply(t, conn, sub)
+			if reply.Status != ActionStatusError || !strings.Contains(reply.Error, tt.wantErr) {
+				t.Errorf("reply = %+v, want error %q", reply, tt.wantErr)
+			}
+			if reply.Action != "acknowledge_alert" || reply.Ref != "r1" || reply.RequestID == "" {
+				t.Errorf("reply = %+v, want the action, ref and a request ID", reply)
+			}
+		})
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("webhook called %d times for refused actions", n)
+	}
+}
+
+func TestActionWebhookReply(t *testing.T) {
+	requests := make(chan ActionRequest, 1)
+	s := newActionServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var request ActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		requests <- request
+		if request.Params["alert_id"] == "500" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"acknowledged":true}`))
+	})
+	conn, sub := testWSConnection(s, "oncall")
+
+	perform(s, conn, sub, "acknowledge_alert", `{"action":"acknowledge_alert","alert_id":"42","ref":"r1","extra":"dropped"}`)
+	reply := waitForReply(t, conn, sub)
+	if reply.Status != ActionStatusOK || reply.Ref != "r1" || reply.Error != "" {
+		t.Errorf("reply = %+v, want ok for ref r1", reply)
+	}
+	if want := map[string]interface{}{"acknowledged": true}; !reflect.DeepEqual(reply.Data, want) {
+		t.Errorf("reply data = %v, want %v", reply.Data, want)
+	}
+
+	request := <-requests
+	if request.RequestID != reply.RequestID || request.Action != "acknowledge_alert" || request.Channel != "AlertsChannel" {
+		t.Errorf("request = %+v, want acknowledge_alert on AlertsChannel as request %s", request, reply.RequestID)
+	}
+	if want := map[string]string{"alert_id": "42"}; !reflect.DeepEqual(request.Params, want) {
+		t.Errorf("forwarded params = %v, want only the declared %v", request.Params, want)
+	}
+	if request.Subject != "u1" || request.ConnectionID != conn.ID || request.Identifier != sub.Identifier || request.ReplyTo != "" {
+		t.Errorf("request = %+v, want the caller's subject, connection and identifier", request)
+	}
+
+	// A failing webhook is reported to the client
+	perform(s, conn, sub, "acknowledge_alert", `{"alert_id":"500"}`)
+	<-requests
+	if reply := waitForReply(t, conn, sub); reply.Status != ActionStatusError || reply.Error != "webhook answered 500" {
+		t.Errorf("reply = %+v, want the webhook error", reply)
+	}
+
+	// Replies are dropped once the client has unsubscribed
+	conn.mu.Lock()
+	delete(conn.Subscriptions, sub.Identifier)
+	conn.mu.Unlock()
+	perform(s, conn, sub, "acknowledge_alert", `{"alert_id":"7"}`)
+	<-requests
+	time.Sleep(20 * time.Millisecond)
+	if conn.Queue.Len() != 0 {
+		t.Error("reply queued for a subscription that is gone")
+	}
+}
+
+func TestActionBrokerReply(t *testing.T) {
+	s := newActionServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	if !s.actions.NeedsReplies() {
+		t.Fatal("NeedsReplies = false with a replying redis_list action")
+	}
+	conn, sub := testWSConnection(s)
+	reply := ActionReply{Type: MessageTypeActionReply, Action: "escalate", RequestID: "req-1", Ref: "r1"}
+	s.actions.await(conn, sub.Identifier, reply)
+
+	// Replies for other instances' requests are ignored, then the awaited
+	// one is routed back to the connection
+	publish := func(message string) {
+		if err := s.broker.Publish(context.Background(), s.actions.ReplyChannel(), []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(`{"request_id":"elsewhere","status":"ok"}`)
+	publish(`{"request_id":"req-1","status":"ok","data":{"escalated_to":"ops"}}`)
+	got := waitForReply(t, conn, sub)
+	if got.RequestID != "req-1" || got.Status != ActionStatusOK || got.Ref != "r1" || got.Action != "escalate" {
+		t.Errorf("reply = %+v, want ok for req-1", got)
+	}
+	if want := map[string]interface{}{"escalated_to": "ops"}; !reflect.DeepEqual(got.Data, want) {
+		t.Errorf("reply data = %v, want %v", got.Data, want)
+	}
+
+	// A reply arriving twice is only delivered once
+	publish(`{"request_id":"req-1","status":"ok"}`)
+	publish(`{"request_id":"sync"}`)
+	time.Sleep(20 * time.Millisecond)
+	if conn.Queue.Len() != 0 {
+		t.Error("duplicate reply delivered")
+	}
+
+	// No reply in time fails the request
+	s.actions.replyTimeout = 10 * time.Millisecond
+	reply.RequestID = "req-2"
+	s.actions.await(conn, sub.Identifier, reply)
+	if got := waitForReply(t, conn, sub); got.RequestID != "req-2" || got.Status != ActionStatusError || !strings.Contains(got.Error, "no reply within") {
+		t.Errorf("reply = %+v, want a timeout error", got)
+	}
+
+	// So does failing to hand the request to Redis
+	perform(s, conn, sub, "escalate", `{"alert_id":"42","ref":"r2"}`)
+	if got := waitForReply(t, conn, sub); got.Status != ActionStatusError || got.Ref != "r2" || got.Error == "" {
+		t.Errorf("reply = %+v, want a forwarding error", got)
+	}
+}
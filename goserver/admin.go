@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// adminReasonPattern matches disconnect reasons an admin may send
+var adminReasonPattern = regexp.MustCompile(`^[a-z_]{1,64}$`)
+
+// TrafficCounters counts what has been written to a connection
+type TrafficCounters struct {
+	messages atomic.Int64
+	bytes    atomic.Int64
+}
+
+// Add records a write; messages is zero for heartbeats and control frames
+func (t *TrafficCounters) Add(messages, bytes int) {
+	t.messages.Add(int64(messages))
+	t.bytes.Add(int64(bytes))
+}
+
+// ConnectionInfo describes a live connection for the admin API
+type ConnectionInfo struct {
+	ID            string                 `json:"id"`
+	Protocol      string                 `json:"protocol"` // "sse" or "websocket"
+	RemoteAddr    string                 `json:"remote_addr"`
+	UserAgent     string                 `json:"user_agent"`
+	Subject       string                 `json:"subject"`
+	Method        string                 `json:"method"`
+	Claims        map[string]interface{} `json:"claims,omitempty"` // Only when inspecting one connection
+	ConnectedAt   time.Time              `json:"connected_at"`
+	AgeSeconds    float64                `json:"age_seconds"`
+	Subscriptions []SubscriptionInfo     `json:"subscriptions"`
+	MessagesSent  int64                  `json:"messages_sent"`
+	BytesSent     int64                  `json:"bytes_sent"`
+	QueueDepth    int                    `json:"queue_depth"`
+	QueueBytes    int                    `json:"queue_bytes"`
+	Dropped       int64                  `json:"dropped"`
+}
+
+// SubscriptionInfo describes what a connection receives. SSE streams have
+// no identifier or channel class.
+type SubscriptionInfo struct {
+	Identifier string                 `json:"identifier,omitempty"`
+	Channel    string                 `json:"channel,omitempty"`
+	Params     map[string]interface{} `json:"params,omitempty"` // Only when inspecting one connection
+	Streams    []string               `json:"streams"`
+	Encoding   string                 `json:"encoding,omitempty"`
+	Filter     string                 `json:"filter,omitempty"`
+	Throttled  bool                   `json:"throttled,omitempty"`
+}
+
+// connectionSelector picks the connections an admin request applies to
+type connectionSelector struct {
+	id       string
+	protocol string
+	subject  string
+	channel  string // Stream name or ActionCable channel class
+}
+
+// empty reports whether the selector would match every connection
+func (c connectionSelector) empty() bool {
+	return c.id == "" && c.protocol == "" && c.subject == "" && c.channel == ""
+}
+
+// matches reports whether a connection is selected
+func (c connectionSelector) matches(info *ConnectionInfo) bool {
+	if c.id != "" && info.ID != c.id {
+		return false
+	}
+	if c.protocol != "" && info.Protocol != c.protocol {
+		return false
+	}
+	if c.subject != "" && info.Subject != c.subject {
+		return false
+	}
+	if c.channel == "" {
+		return true
+	}
+	for _, sub := range info.Subscriptions {
+		if sub.Channel == c.channel {
+			return true
+		}
+		for _, stream := range sub.Streams {
+			if stream == c.channel {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// info describes an SSE connection
+func (c *SSEConnection) info(detailed bool) *ConnectionInfo {
+	info := &ConnectionInfo{
+		ID:           c.ID,
+		Protocol:     "sse",
+		RemoteAddr:   c.RemoteAddr,
+		UserAgent:    c.UserAgent,
+		Subject:      c.Identity.Subject,
+		Method:       c.Identity.Method,
+		ConnectedAt:  c.ConnectedAt,
+		AgeSeconds:   time.Since(c.ConnectedAt).Seconds(),
+		MessagesSent: c.Sent.messages.Load(),
+		BytesSent:    c.Sent.bytes.Load(),
+		QueueDepth:   c.Queue.Len(),
+		QueueBytes:   c.Queue.Bytes(),
+		Dropped:      c.Queue.Dropped(),
+	}
+	if detailed {
+		info.Claims = c.Identity.Claims
+	}
+
+	sub := SubscriptionInfo{Streams: make([]string, 0, len(c.Subscriptions)), Throttled: c.Throttle != nil}
+	for stream := range c.Subscriptions {
+		sub.Streams = append(sub.Streams, stream)
+	}
+	sort.Strings(sub.Streams)
+	if c.Delta != nil {
+		sub.Encoding = DeltaEncodingJSONPatch
+	}
+	if c.Filter != nil {
+		sub.Filter = c.Filter.String()
+	}
+	info.Subscriptions = []SubscriptionInfo{sub}
+	return info
+}
+
+// info describes a WebSocket connection
+func (c *WebSocketConnection) info(detailed bool) *ConnectionInfo {
+	info := &ConnectionInfo{
+		ID:           c.ID,
+		Protocol:     "websocket",
+		RemoteAddr:   c.RemoteAddr,
+		UserAgent:    c.UserAgent,
+		Subject:      c.Identity.Subject,
+		Method:       c.Identity.Method,
+		ConnectedAt:  c.ConnectedAt,
+		AgeSeconds:   time.Since(c.ConnectedAt).Seconds(),
+		MessagesSent: c.Sent.messages.Load(),
+		BytesSent:    c.Sent.bytes.Load(),
+		QueueDepth:   c.Queue.Len(),
+		QueueBytes:   c.Queue.Bytes(),
+		Dropped:      c.Queue.Dropped(),
+	}
+	if detailed {
+		info.Claims = c.Identity.Claims
+	}
+
+	c.mu.RLock()
+	info.Subscriptions = make([]SubscriptionInfo, 0, len(c.Subscriptions))
+	for _, sub := range c.Subscriptions {
+		subInfo := SubscriptionInfo{
+			Identifier: sub.Identifier,
+			Channel:    sub.ChannelClass,
+			Streams:    sub.Streams,
+			Throttled:  sub.Throttle != nil,
+		}
+		if detailed {
+			subInfo.Params = sub.Params
+		}
+		if sub.Delta != nil {
+			subInfo.Encoding = DeltaEncodingJSONPatch
+		}
+		if sub.Filter != nil {
+			subInfo.Filter = sub.Filter.String()
+		}
+		info.Subscriptions = append(info.Subscriptions, subInfo)
+	}
+	c.mu.RUnlock()
+	sort.Slice(info.Subscriptions, func(i, j int) bool { return info.Subscriptions[i].Identifier < info.Subscriptions[j].Identifier })
+	return info
+}
+
+// adminHandler serves the admin API:
+//
+//	GET    /admin/connections        list, filtered by ?protocol=, ?subject= and ?channel=
+//	GET    /admin/connections/{id}   inspect one connection
+//	DELETE /admin/connections/{id}   disconnect one connection
+//	DELETE /admin/connections        disconnect every connection matching the filters
+//
+// Disconnects take ?reason= (default "remote") and ?reconnect=false.
+func (s *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
+	if len(s.adminTokens) == 0 {
+		http.Error(w, "Admin API disabled: ADMIN_TOKEN is not set", http.StatusForbidden)
+		return
+	}
+	if !bearerAuthorized(r, s.adminTokens) {
+		s.logger.Warn("🔒 Admin request refused from %s: invalid admin token", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	selector := connectionSelector{
+		protocol: query.Get("protocol"),
+		subject:  query.Get("subject"),
+		channel:  query.Get("channel"),
+	}
+	if id, ok := strings.CutPrefix(r.URL.Path, "/admin/connections/"); ok {
+		if id == "" {
+			http.NotFound(w, r)
+			return
+		}
+		selector = connectionSelector{id: id}
+	} else if r.URL.Path != "/admin/connections" {
+		http.NotFound(w, r)
+		return
+	}
+	if selector.protocol != "" && selector.protocol != "sse" && selector.protocol != "websocket" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown protocol %q", selector.protocol))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		infos := s.connectionInfos(selector, selector.id != "")
+		if selector.id != "" {
+			if len(infos) == 0 {
+				http.Error(w, "Connection not found", http.StatusNotFound)
+				return
+			}
+			writeAdminJSON(w, infos[0])
+			return
+		}
+		writeAdminJSON(w, map[string]interface{}{"count": len(infos), "connections": infos})
+
+	case http.MethodDelete:
+		if selector.empty() {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("refusing to disconnect everything: select by id, protocol, subject or channel"))
+			return
+		}
+		reason := query.Get("reason")
+		if reason == "" {
+			reason = DisconnectRemote
+		}
+		if !adminReasonPattern.MatchString(reason) {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid reason %q", reason))
+			return
+		}
+		reconnect := true
+		if value := query.Get("reconnect"); value != "" {
+			var err error
+			if reconnect, err = strconv.ParseBool(value); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid reconnect %q", value))
+				return
+			}
+		}
+
+		disconnected := s.disconnectConnections(selector, reason, reconnect)
+		if selector.id != "" && len(disconnected) == 0 {
+			http.Error(w, "Connection not found", http.StatusNotFound)
+			return
+		}
+		s.logger.Info("🔨 Admin disconnected %d connections from %s (reason: %s, reconnect: %v)", len(disconnected), r.RemoteAddr, reason, reconnect)
+		writeAdminJSON(w, map[string]interface{}{"disconnected": len(disconnected), "ids": disconnected})
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// connectionInfos describes the selected connections, oldest first
+func (s *Server) connectionInfos(selector connectionSelector, detailed bool) []*ConnectionInfo {
+	infos := []*ConnectionInfo{}
+	s.sseMutex.RLock()
+	for _, conn := range s.sseConnections {
+		if info := conn.info(detailed); selector.matches(info) {
+			infos = append(infos, info)
+		}
+	}
+	s.sseMutex.RUnlock()
+
+	s.wsMutex.RLock()
+	for _, conn := range s.wsConnections {
+		if info := conn.info(detailed); selector.matches(info) {
+			infos = append(infos, info)
+		}
+	}
+	s.wsMutex.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ConnectedAt.Before(infos[j].ConnectedAt) })
+	return infos
+}
+
+// disconnectConnections closes the selected connections, returning their IDs.
+// WebSocket clients get an ActionCable disconnect with the reason; SSE
+// clients get a final disconnect event carrying the same fields.
+func (s *Server) disconnectConnections(selector connectionSelector, reason string, reconnect bool) []string {
+	disconnected := []string{}
+	s.sseMutex.RLock()
+	for _, conn := range s.sseConnections {
+		if selector.matches(conn.info(false)) {
+			conn.Disconnect(reason, reconnect)
+			disconnected = append(disconnected, conn.ID)
+		}
+	}
+	s.sseMutex.RUnlock()
+
+	s.wsMutex.RLock()
+	for _, conn := range s.wsConnections {
+		if selector.matches(conn.info(false)) {
+			conn.Disconnect(reason, reconnect)
+			disconnected = append(disconnected, conn.ID)
+		}
+	}
+	s.wsMutex.RUnlock()
+	return disconnected
+}
+
+// writeAdminJSON writes an admin API response
+func writeAdminJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// adminRequest sends a request to the admin API with a bearer token
+func adminRequest(s *Server, method, target, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.adminHandler(w, r)
+	return w
+}
+
+// disconnected reports whether an SSE connection was told to disconnect
+func disconnected(conn *SSEConnection) bool {
+	select {
+	case <-conn.disconnect:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestConnectionSelectorMatches(t *testing.T) {
+	info := &ConnectionInfo{
+		ID:       "conn_1_1",
+		Protocol: "websocket",
+		Subject:  "user:42",
+		Subscriptions: []SubscriptionInfo{
+			{Channel: "DashboardUpdatesChannel", Streams: []string{"dashboard_updates"}},
+			{Channel: "AlertsChannel", Streams: []string{"alerts", "alerts:7"}},
+		},
+	}
+	tests := []struct {
+		name     string
+		selector connectionSelector
+		want     bool
+	}{
+		{"empty", connectionSelector{}, true},
+		{"id", connectionSelector{id: "conn_1_1"}, true},
+		{"other id", connectionSelector{id: "conn_1"}, false},
+		{"protocol", connectionSelector{protocol: "websocket"}, true},
+		{"other protocol", connectionSelector{protocol: "sse"}, false},
+		{"subject", connectionSelector{subject: "user:42"}, true},
+		{"subject prefix", connectionSelector{subject: "user:4"}, false},
+		{"channel class", connectionSelector{channel: "AlertsChannel"}, true},
+		{"stream", connectionSelector{channel: "alerts:7"}, true},
+		{"other stream", connectionSelector{channel: "alerts:8"}, false},
+		{"all match", connectionSelector{protocol: "websocket", subject: "user:42", channel: "dashboard_updates"}, true},
+		{"one differs", connectionSelector{protocol: "websocket", subject: "user:7", channel: "dashboard_updates"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.selector.matches(info); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if !(connectionSelector{}).empty() || (connectionSelector{channel: "alerts"}).empty() {
+		t.Error("empty reports the wrong selectors")
+	}
+
+	// SSE connections match on their streams
+	s := newTestServer(t, nil)
+	conn := testSSEConnection(t, s, "alerts", "deployments")
+	sse := conn.info(false)
+	if !(connectionSelector{protocol: "sse", channel: "deployments"}).matches(sse) || (connectionSelector{channel: "AlertsChannel"}).matches(sse) {
+		t.Errorf("selectors matched SSE info %+v wrongly", sse)
+	}
+}
+
+func TestAdminDisconnect(t *testing.T) {
+	s := newTestServer(t, map[string]string{"ADMIN_TOKEN": "admin"})
+	alerts := testSSEConnection(t, s, "alerts")
+	dashboard := testSSEConnection(t, s, "dashboard_updates")
+
+	tests := []struct {
+		name    string
+		target  string
+		status  int
+		wantErr string
+	}{
+		{"no selector", "/admin/connections", http.StatusBadRequest, "refusing to disconnect everything"},
+		{"empty filters", "/admin/connections?protocol=&subject=", http.StatusBadRequest, "refusing to disconnect everything"},
+		{"unknown protocol", "/admin/connections?protocol=ftp", http.StatusBadRequest, "unknown protocol"},
+		{"invalid reason", "/admin/connections?channel=alerts&reason=Bad%20Reason", http.StatusBadRequest, "invalid reason"},
+		{"invalid reconnect", "/admin/connections?channel=alerts&reconnect=maybe", http.StatusBadRequest, "invalid reconnect"},
+		{"unknown id", "/admin/connections/conn_0_0", http.StatusNotFound, "not found"},
+		{"empty id", "/admin/connections/", http.StatusNotFound, "not found"},
+	}
+	for _, tt := range tests {
+		w := adminRequest(s, http.MethodDelete, tt.target, "admin")
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.wantErr) {
+			t.Errorf("%s: response = %d %s, want %d %q", tt.name, w.Code, w.Body, tt.status, tt.wantErr)
+		}
+	}
+	if disconnected(alerts) || disconnected(dashboard) {
+		t.Fatal("refused request disconnected a connection")
+	}
+
+	// A filtered disconnect only reaches the matching connection
+	w := adminRequest(s, http.MethodDelete, "/admin/connections?channel=alerts&reason=maintenance&reconnect=false", "admin")
+	var response struct {
+		Disconnected int      `json:"disconnected"`
+		IDs          []string `json:"ids"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response %d %s: %v", w.Code, w.Body, err)
+	}
+	if response.Disconnected != 1 || len(response.IDs) != 1 || response.IDs[0] != alerts.ID {
+		t.Errorf("response = %+v, want %s alone", response, alerts.ID)
+	}
+	if !disconnected(alerts) || disconnected(dashboard) {
+		t.Error("wrong connections disconnected")
+	}
+	if want := (DisconnectMessage{Type: MessageTypeDisconnect, Reason: "maintenance", Reconnect: false}); alerts.closeMessage != want {
+		t.Errorf("close message = %+v, want %+v", alerts.closeMessage, want)
+	}
+
+	// And by ID
+	if w := adminRequest(s, http.MethodDelete, "/admin/connections/"+dashboard.ID, "admin"); w.Code != http.StatusOK || !disconnected(dashboard) {
+		t.Errorf("DELETE by id = %d %s, connection disconnected: %v", w.Code, w.Body, disconnected(dashboard))
+	}
+}
+
+func TestAdminAccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens string
+		method string
+		token  string
+		status int
+	}{
+		{"disabled", "", http.MethodGet, "admin", http.StatusForbidden},
+		{"no token", "admin", http.MethodGet, "", http.StatusUnauthorized},
+		{"publisher token", "admin", http.MethodGet, "publish", http.StatusUnauthorized},
+		{"rotated token", "old,admin", http.MethodGet, "old", http.StatusOK},
+		{"wrong method", "admin", http.MethodPost, "admin", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"ADMIN_TOKEN": tt.tokens, "PUBLISH_TOKEN": "publish"})
+			if w := adminRequest(s, tt.method, "/admin/connections", tt.token); w.Code != tt.status {
+				t.Errorf("status = %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+		})
+	}
+}
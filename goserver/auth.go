@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Authentication methods
+const (
+	AuthRailsSession = "rails_session"
+	AuthJWT          = "jwt"
+	AuthSignedURL    = "signed_url"
+	AuthNone         = "none"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries
+// no credentials of its kind, so the next one can be tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// Identity is the authenticated caller behind a connection
+type Identity struct {
+	Subject string                 // User, or session when nobody is signed in
+	Method  string                 // Authenticator that accepted the credentials
+	Claims  map[string]interface{} // Session data or token claims, for authorization
+
+	credentials http.Header // Cookie and Authorization headers, forwarded to the authorization callback
+}
+
+// anonymousIdentity is attached to connections when authentication is optional
+func anonymousIdentity() *Identity {
+	return &Identity{Subject: "anonymous", Method: AuthNone, Claims: map[string]interface{}{}}
+}
+
+// Authenticator verifies one kind of request credentials
+type Authenticator interface {
+	Name() string
+	// Authenticate returns ErrNoCredentials when the request carries none of
+	// this authenticator's credentials, and any other error when they are
+	// present but invalid
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// AuthChain tries each configured authenticator in turn
+type AuthChain struct {
+	authenticators []Authenticator
+	required       bool
+}
+
+// NewAuthChainFromEnv builds the authenticators named by AUTH_METHODS
+// (comma separated). When AUTH_METHODS is unset every method whose secret is
+// configured is enabled. Authentication is required whenever a method is
+// enabled, unless AUTH_REQUIRED=false.
+func NewAuthChainFromEnv(logger *Logger) (*AuthChain, error) {
+	var methods []string
+	if value := os.Getenv("AUTH_METHODS"); value != "" {
+		for _, method := range strings.Split(value, ",") {
+			if method = strings.TrimSpace(strings.ToLower(method)); method != "" {
+				methods = append(methods, method)
+			}
+		}
+	} else {
+		if os.Getenv("SECRET_KEY_BASE") != "" {
+			methods = append(methods, AuthRailsSession)
+		}
+		if os.Getenv("JWT_SECRET") != "" || os.Getenv("JWT_JWKS_FILE") != "" {
+			methods = append(methods, AuthJWT)
+		}
+		if os.Getenv("URL_TOKEN_SECRET") != "" {
+			methods = append(methods, AuthSignedURL)
+		}
+	}
+
+	chain := &AuthChain{}
+	for _, method := range methods {
+		var auth Authenticator
+		var err error
+		switch method {
+		case AuthRailsSession:
+			auth, err = newRailsSessionAuthenticatorFromEnv()
+		case AuthJWT:
+			auth, err = newJWTAuthenticatorFromEnv()
+		case AuthSignedURL:
+			auth, err = newSignedURLAuthenticatorFromEnv()
+		case AuthNone:
+			continue
+		default:
+			err = fmt.Errorf("unknown method")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("auth method %s: %w", method, err)
+		}
+		chain.authenticators = append(chain.authenticators, auth)
+	}
+
+	chain.required = len(chain.authenticators) > 0 && os.Getenv("AUTH_REQUIRED") != "false"
+	switch {
+	case len(chain.authenticators) == 0:
+		logger.Warn("⚠️ No authentication configured, streams are open to anyone")
+	case !chain.required:
+		logger.Warn("⚠️ Authentication optional (AUTH_REQUIRED=false), accepting anonymous clients")
+	default:
+		logger.Info("🔐 Authentication required via: %s", strings.Join(methods, ", "))
+	}
+	return chain, nil
+}
+
+// Authenticate returns the identity of the first authenticator that accepts
+// the request. Invalid credentials are rejected even if a later method might
+// accept the request.
+func (a *AuthChain) Authenticate(r *http.Request) (*Identity, error) {
+	credentials := make(http.Header)
+	for _, name := range []string{"Cookie", "Authorization"} {
+		if values := r.Header.Values(name); len(values) > 0 {
+			credentials[name] = values
+		}
+	}
+
+	for _, auth := range a.authenticators {
+		identity, err := auth.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", auth.Name(), err)
+		}
+		identity.Method = auth.Name()
+		identity.credentials = credentials
+		return identity, nil
+	}
+	if a.required {
+		return nil, ErrNoCredentials
+	}
+	identity := anonymousIdentity()
+	identity.credentials = credentials
+	return identity, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtLeeway tolerates clock skew between the token issuer and this server
+const jwtLeeway = 30 * time.Second
+
+// JWTAuthenticator verifies bearer JWTs signed with HS256 (shared secret) or
+// RS256 (keys from a local JWKS file)
+type JWTAuthenticator struct {
+	secret   []byte
+	keys     map[string]*rsa.PublicKey // Key ID -> key
+	issuer   string
+	audience string
+}
+
+// NewJWTAuthenticator creates an authenticator. Either secret or jwksPath
+// must be given; issuer and audience are only checked when set.
+func NewJWTAuthenticator(secret []byte, jwksPath, issuer, audience string) (*JWTAuthenticator, error) {
+	auth := &JWTAuthenticator{secret: secret, issuer: issuer, audience: audience}
+	if jwksPath != "" {
+		keys, err := loadJWKS(jwksPath)
+		if err != nil {
+			return nil, err
+		}
+		auth.keys = keys
+	}
+	if len(auth.secret) == 0 && len(auth.keys) == 0 {
+		return nil, fmt.Errorf("JWT_SECRET or JWT_JWKS_FILE must be set")
+	}
+	return auth, nil
+}
+
+// newJWTAuthenticatorFromEnv configures the authenticator from JWT_SECRET,
+// JWT_JWKS_FILE, JWT_ISSUER and JWT_AUDIENCE
+func newJWTAuthenticatorFromEnv() (*JWTAuthenticator, error) {
+	return NewJWTAuthenticator([]byte(os.Getenv("JWT_SECRET")), os.Getenv("JWT_JWKS_FILE"),
+		os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE"))
+}
+
+// Name returns the method name
+func (a *JWTAuthenticator) Name() string {
+	return AuthJWT
+}
+
+// Authenticate verifies the token from the Authorization header, or from the
+// access_token query parameter since browsers cannot set headers on
+// EventSource or WebSocket requests
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := ""
+	if header := r.Header.Get("Authorization"); len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		token = strings.TrimSpace(header[7:])
+	} else {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("token has no sub claim")
+	}
+	return &Identity{Subject: subject, Claims: claims}, nil
+}
+
+// verify checks a compact JWS and its registered claims
+func (a *JWTAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Alg {
+	case "HS256":
+		if len(a.secret) == 0 {
+			return nil, fmt.Errorf("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+	case "RS256":
+		key, err := a.rsaKey(header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := a.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// rsaKey picks the JWKS key for a key ID; tokens without one may only be
+// used when the JWKS holds a single key
+func (a *JWTAuthenticator) rsaKey(kid string) (*rsa.PublicKey, error) {
+	if len(a.keys) == 0 {
+		return nil, fmt.Errorf("RS256 tokens are not accepted")
+	}
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown token key %q", kid)
+}
+
+// checkClaims validates exp, nbf, iss and aud
+func (a *JWTAuthenticator) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return fmt.Errorf("token issuer %v not accepted", claims["iss"])
+	}
+	if a.audience != "" && !audienceContains(claims["aud"], a.audience) {
+		return fmt.Errorf("token audience %v not accepted", claims["aud"])
+	}
+	return nil
+}
+
+// audienceContains reports whether an aud claim, a string or an array of
+// strings, names the audience
+func audienceContains(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, item := range v {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url JSON token segment
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// loadJWKS reads the RSA keys of a JWKS file, skipping other key types
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("%s key %q modulus: %w", path, jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("%s key %q exponent: %w", path, jwk.Kid, err)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s contains no RSA keys", path)
+	}
+	return keys, nil
+}
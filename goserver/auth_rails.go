@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Rails key derivation, matching Rails.application.key_generator
+const (
+	railsKeyIterations     = 1000
+	railsEncryptedSalt     = "authenticated encrypted cookie"
+	railsSignedSalt        = "signed cookie"
+	railsDefaultCookieName = "_dashboard_session"
+)
+
+// RailsSessionAuthenticator reads the Rails session cookie, either encrypted
+// (AES-256-GCM, the default since Rails 5.2) or only signed
+type RailsSessionAuthenticator struct {
+	cookieName string
+	encryptKey []byte
+	signKey    []byte
+	signDigest func() hash.Hash
+}
+
+// NewRailsSessionAuthenticator derives the cookie keys from secret_key_base.
+// keyDigest is the key generator digest (SHA256 since Rails 7.0) and
+// signDigest the signed cookie digest (SHA1 unless changed in the app).
+func NewRailsSessionAuthenticator(secretKeyBase, cookieName, keyDigest, signDigest string) (*RailsSessionAuthenticator, error) {
+	keyHash, err := railsDigest(keyDigest)
+	if err != nil {
+		return nil, err
+	}
+	signHash, err := railsDigest(signDigest)
+	if err != nil {
+		return nil, err
+	}
+	return &RailsSessionAuthenticator{
+		cookieName: cookieName,
+		encryptKey: pbkdf2Key(keyHash, []byte(secretKeyBase), []byte(railsEncryptedSalt), railsKeyIterations, 32),
+		signKey:    pbkdf2Key(keyHash, []byte(secretKeyBase), []byte(railsSignedSalt), railsKeyIterations, 64),
+		signDigest: signHash,
+	}, nil
+}
+
+// newRailsSessionAuthenticatorFromEnv configures the authenticator from
+// SECRET_KEY_BASE, RAILS_SESSION_COOKIE, RAILS_KEY_DIGEST and
+// RAILS_SIGNED_COOKIE_DIGEST
+func newRailsSessionAuthenticatorFromEnv() (*RailsSessionAuthenticator, error) {
+	secret := os.Getenv("SECRET_KEY_BASE")
+	if secret == "" {
+		return nil, fmt.Errorf("SECRET_KEY_BASE is not set")
+	}
+	cookieName := os.Getenv("RAILS_SESSION_COOKIE")
+	if cookieName == "" {
+		cookieName = railsDefaultCookieName
+	}
+	keyDigest := os.Getenv("RAILS_KEY_DIGEST")
+	if keyDigest == "" {
+		keyDigest = "sha256"
+	}
+	signDigest := os.Getenv("RAILS_SIGNED_COOKIE_DIGEST")
+	if signDigest == "" {
+		signDigest = "sha1"
+	}
+	return NewRailsSessionAuthenticator(secret, cookieName, keyDigest, signDigest)
+}
+
+// Name returns the method name
+func (a *RailsSessionAuthenticator) Name() string {
+	return AuthRailsSession
+}
+
+// Authenticate decodes the session cookie and identifies the caller by
+// user_id, falling back to the session id
+func (a *RailsSessionAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	cookie, err := r.Cookie(a.cookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, ErrNoCredentials
+	}
+	value, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cookie: %w", err)
+	}
+
+	var plain []byte
+	switch parts := strings.Split(value, "--"); len(parts) {
+	case 3:
+		plain, err = a.decrypt(parts[0], parts[1], parts[2])
+	case 2:
+		plain, err = a.verify(parts[0], parts[1])
+	default:
+		err = fmt.Errorf("malformed cookie")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := unwrapRailsMessage(plain, "cookie."+a.cookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, ok := paramString(session["user_id"])
+	if !ok {
+		subject, ok = paramString(session["session_id"])
+	}
+	if !ok {
+		return nil, fmt.Errorf("session has no user_id or session_id")
+	}
+	return &Identity{Subject: subject, Claims: session}, nil
+}
+
+// decrypt opens an ActiveSupport::MessageEncryptor aes-256-gcm message
+func (a *RailsSessionAuthenticator) decrypt(data, iv, tag string) ([]byte, error) {
+	ciphertext, err1 := base64.StdEncoding.DecodeString(data)
+	nonce, err2 := base64.StdEncoding.DecodeString(iv)
+	authTag, err3 := base64.StdEncoding.DecodeString(tag)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("malformed encrypted cookie: %w", err)
+	}
+
+	block, err := aes.NewCipher(a.encryptKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, nonce, append(ciphertext, authTag...), nil)
+	if err != nil {
+		return nil, fmt.Errorf("cookie decryption failed")
+	}
+	return plain, nil
+}
+
+// verify checks an ActiveSupport::MessageVerifier signature
+func (a *RailsSessionAuthenticator) verify(data, digest string) ([]byte, error) {
+	mac := hmac.New(a.signDigest, a.signKey)
+	mac.Write([]byte(data))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(digest)) != 1 {
+		return nil, fmt.Errorf("cookie signature mismatch")
+	}
+	plain, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signed cookie: %w", err)
+	}
+	return plain, nil
+}
+
+// unwrapRailsMessage strips the _rails metadata envelope, checking purpose
+// and expiry. Both the Rails 7.1+ layout (data inline) and the older one
+// (base64 message) are accepted, as are messages without metadata.
+func unwrapRailsMessage(plain []byte, purpose string) (map[string]interface{}, error) {
+	var envelope struct {
+		Rails *struct {
+			Data    json.RawMessage `json:"data"`
+			Message string          `json:"message"`
+			Exp     *string         `json:"exp"`
+			Pur     string          `json:"pur"`
+		} `json:"_rails"`
+	}
+	if err := json.Unmarshal(plain, &envelope); err != nil {
+		return nil, fmt.Errorf("session is not JSON (cookies_serializer must be :json): %w", err)
+	}
+
+	payload := plain
+	if meta := envelope.Rails; meta != nil {
+		if meta.Pur != "" && meta.Pur != purpose {
+			return nil, fmt.Errorf("cookie purpose %q does not match %q", meta.Pur, purpose)
+		}
+		if meta.Exp != nil {
+			exp, err := time.Parse(time.RFC3339, *meta.Exp)
+			if err != nil {
+				return nil, fmt.Errorf("malformed cookie expiry: %w", err)
+			}
+			if time.Now().After(exp) {
+				return nil, fmt.Errorf("cookie expired")
+			}
+		}
+		if meta.Message != "" {
+			decoded, err := base64.StdEncoding.DecodeString(meta.Message)
+			if err != nil {
+				return nil, fmt.Errorf("malformed cookie message: %w", err)
+			}
+			payload = decoded
+		} else {
+			payload = meta.Data
+		}
+	}
+
+	var session map[string]interface{}
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return nil, fmt.Errorf("session is not a JSON object: %w", err)
+	}
+	return session, nil
+}
+
+// railsDigest maps a Rails digest name to its hash
+func railsDigest(name string) (func() hash.Hash, error) {
+	switch strings.ToLower(name) {
+	case "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported digest %q", name)
+	}
+}
+
+// pbkdf2Key derives a key as OpenSSL::PKCS5.pbkdf2_hmac does
+func pbkdf2Key(h func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	key := make([]byte, 0, blocks*hashLen)
+	var counter [4]byte
+	for block := 1; block <= blocks; block++ {
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testSecretKeyBase is the secret_key_base the cookie vectors below were
+// encrypted with, using a 12-byte IV of "0123456789ab"
+const testSecretKeyBase = "b3c7a1f0e4d5c6b7a8f9e0d1c2b3a4f5e6d7c8b9a0f1e2d3c4b5a6f7e8d9c0b1a2f3e4d5c6b7a8f9e0d1c2b3a4f5e6d7c8b9a0f1e2d3c4b5a6f7e8d9c0b1a2"
+
+// Cookie vectors, as set by Rails for the _dashboard_session cookie
+const (
+	// Rails 7.1 layout: {"_rails":{"data":{"session_id":"9f1c2e","user_id":42,...},"exp":null,"pur":"cookie._dashboard_session"}}
+	railsCookieV71 = "YaK7JBFV6ZdxBIb2bxZWSYZRUZ6Pmr8Cz1ld%2Bb9SXPNP2uYqbbqVeOKQtyZPtZDxH8t1pc5EpY6CD1kG%2FDUFodnKoJDE%2Bn2oHmShXd80mqqivfcYDqtn%2Be6Y0Y7hcX1H5%2BvqvvXIpcttl%2BPpgpDugb8WQJDmZMvuuA%3D%3D--MDEyMzQ1Njc4OWFi--1wMULBbyDmAqfT%2F6P7xPEA%3D%3D"
+	// Rails 5.2-7.0 layout: base64 message, {"session_id":"9f1c2e","user_id":"7"}, expiring in 2099
+	railsCookieV70 = "YaK7JBFV6ZdxBIb2ZhJRW8UMT57G3akI7Exp%2FphMH55UltgpYuO5MKHbjQJzv5biMYUG%2BIsB44mvEEgMmjEwifWSy8CF8nmaASSvU5gpkvi66aBGUr58pKvb3oTyRiIYtrK%2Fqpqc6og1xdaq3NbBh6hHCdvqZYb4rG%2B6%2BrO83OhbbNPXA0dqgSdfJ1CYoKcq--MDEyMzQ1Njc4OWFi--%2FVK5IlcBxPPTuORp9cMT8A%3D%3D"
+	// Expired on 2020-01-01
+	railsCookieExpired = "YaK7JBFV6ZdxBIb2bxZWSYZRUZ6JjKkD%2BV9XhOwCTLRBweVjfqrKePyC8GUH9%2F61S9gbocxSt%2BHbTBtOk3FakJ6IoNqT6T3xHnriENElj6bHt%2BMHCuUq6OmOrMemYWFB4%2Byt7dc%3D--MDEyMzQ1Njc4OWFi--8Sk0VW8QmKKVsh3VtdmO7Q%3D%3D"
+	// Encrypted for the other_session cookie
+	railsCookieOtherPurpose = "YaK7JBFV6ZdxBIb2bxZWSYZRUZ6JjKkD%2BV9XhOwCTLRBweVjfqrKNLvernkIt7rqWdNt8pMH7LiEUkQUyyQYlc%2FB8dmP9HHpQWQ%3D--MDEyMzQ1Njc4OWFi--J3wMebdpxI61JH%2BUZ5JV1w%3D%3D"
+	// Marshal serialized session, from an app still on cookies_serializer :marshal
+	railsCookieMarshal = "HoifUDkeipc2TY69ZBl9QcBtELq5q4VToA9VoO02Kg%3D%3D--MDEyMzQ1Njc4OWFi--Owm8G8PsnQRR0TVkxao9Iw%3D%3D"
+	// Signed only (HMAC-SHA1), session {"session_id":"9f1c2e","user_id":"7"}
+	railsCookieSigned = "eyJfcmFpbHMiOnsibWVzc2FnZSI6ImV5SnpaWE56YVc5dVgybGtJam9pT1dZeFl6SmxJaXdpZFhObGNsOXBaQ0k2SWpjaWZRPT0iLCJleHAiOm51bGwsInB1ciI6ImNvb2tpZS5fZGFzaGJvYXJkX3Nlc3Npb24ifX0%3D--f5966948ae1bffe473b1dc7c2f10c9e6c24d844b"
+)
+
+func TestPBKDF2KnownAnswers(t *testing.T) {
+	tests := []struct {
+		name       string
+		digest     func() hash.Hash
+		password   string
+		salt       string
+		iterations int
+		keyLen     int
+		want       string
+	}{
+		// RFC 6070
+		{"sha1 1 iteration", sha1.New, "password", "salt", 1, 20, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"sha1 4096 iterations", sha1.New, "password", "salt", 4096, 20, "4b007901b765489abead49d926f721d065a429c1"},
+		{"sha1 multi-block", sha1.New, "passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 25, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+		{"sha256 4096 iterations", sha256.New, "password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"sha256 truncated block", sha256.New, "passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 40, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+		// Rails key_generator.generate_key("authenticated encrypted cookie", 32)
+		{"rails encrypted cookie key", sha256.New, testSecretKeyBase, railsEncryptedSalt, railsKeyIterations, 32, "e1142ad50da34c574a3be9787eda888ae7d5b50cd356386e6fceb5fb507ff2c4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hex.EncodeToString(pbkdf2Key(tt.digest, []byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen))
+			if got != tt.want {
+				t.Errorf("pbkdf2Key = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// railsRequest builds a request carrying the session cookie
+func railsRequest(value string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	r.AddCookie(&http.Cookie{Name: railsDefaultCookieName, Value: value})
+	return r
+}
+
+func TestRailsSessionAuthenticator(t *testing.T) {
+	auth, err := NewRailsSessionAuthenticator(testSecretKeyBase, railsDefaultCookieName, "sha256", "sha1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cookie  string
+		subject string
+		wantErr string
+	}{
+		{"rails 7.1 envelope", railsCookieV71, "42", ""},
+		{"rails 7.0 envelope", railsCookieV70, "7", ""},
+		{"signed cookie", railsCookieSigned, "7", ""},
+		{"expired", railsCookieExpired, "", "cookie expired"},
+		{"wrong purpose", railsCookieOtherPurpose, "", "does not match"},
+		{"marshal serializer", railsCookieMarshal, "", "cookies_serializer must be :json"},
+		{"tampered ciphertext", "ZaK7" + railsCookieV71[4:], "", "decryption failed"},
+		{"tampered tag", strings.Replace(railsCookieV71, "--1wMU", "--2wMU", 1), "", "decryption failed"},
+		{"tampered signed data", "fyJf" + railsCookieSigned[4:], "", "signature mismatch"},
+		{"one part", "YaK7JBFV6ZdxBIb2", "", "malformed cookie"},
+		{"bad base64", "!!!--MDEyMzQ1Njc4OWFi--1wMULBbyDmAqfT", "", "malformed encrypted cookie"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := auth.Authenticate(railsRequest(tt.cookie))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Authenticate error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if identity.Subject != tt.subject {
+				t.Errorf("Subject = %q, want %q", identity.Subject, tt.subject)
+			}
+			if identity.Claims["session_id"] != "9f1c2e" {
+				t.Errorf("Claims[session_id] = %v, want 9f1c2e", identity.Claims["session_id"])
+			}
+		})
+	}
+}
+
+func TestRailsSessionAuthenticatorWrongSecret(t *testing.T) {
+	auth, err := NewRailsSessionAuthenticator(strings.Repeat("0", 128), railsDefaultCookieName, "sha256", "sha1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range []string{railsCookieV71, railsCookieSigned} {
+		if _, err := auth.Authenticate(railsRequest(cookie)); err == nil {
+			t.Errorf("cookie %.16s... accepted with the wrong secret_key_base", cookie)
+		}
+	}
+}
+
+func TestRailsSessionAuthenticatorNoCookie(t *testing.T) {
+	auth, err := NewRailsSessionAuthenticator(testSecretKeyBase, railsDefaultCookieName, "sha256", "sha1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = auth.Authenticate(httptest.NewRequest(http.MethodGet, "/events", nil))
+	if !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Authenticate error = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestNewRailsSessionAuthenticatorDigests(t *testing.T) {
+	if _, err := NewRailsSessionAuthenticator(testSecretKeyBase, railsDefaultCookieName, "md5", "sha1"); err == nil {
+		t.Error("md5 key digest accepted")
+	}
+	if _, err := NewRailsSessionAuthenticator(testSecretKeyBase, railsDefaultCookieName, "SHA256", "SHA256"); err != nil {
+		t.Errorf("upper-case digest names rejected: %v", err)
+	}
+}
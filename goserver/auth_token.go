@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// SignedURLAuthenticator accepts a short-lived token minted by the Rails app
+// and passed as the token query parameter. The token is
+// base64url(claims JSON) + "." + base64url(HMAC-SHA256(secret, first part)).
+// Claims must include sub and exp (Unix seconds); an optional path claim
+// restricts the token to one endpoint.
+type SignedURLAuthenticator struct {
+	secret []byte
+}
+
+// NewSignedURLAuthenticator creates an authenticator for the shared secret
+func NewSignedURLAuthenticator(secret []byte) (*SignedURLAuthenticator, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("URL_TOKEN_SECRET is not set")
+	}
+	return &SignedURLAuthenticator{secret: secret}, nil
+}
+
+// newSignedURLAuthenticatorFromEnv configures the authenticator from URL_TOKEN_SECRET
+func newSignedURLAuthenticatorFromEnv() (*SignedURLAuthenticator, error) {
+	return NewSignedURLAuthenticator([]byte(os.Getenv("URL_TOKEN_SECRET")))
+}
+
+// Name returns the method name
+func (a *SignedURLAuthenticator) Name() string {
+	return AuthSignedURL
+}
+
+// Authenticate verifies the token query parameter
+func (a *SignedURLAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("token has no exp claim")
+	}
+	if time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if path, ok := claims["path"].(string); ok && path != r.URL.Path {
+		return nil, fmt.Errorf("token not valid for %s", r.URL.Path)
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("token has no sub claim")
+	}
+	return &Identity{Subject: subject, Claims: claims}, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// BrokerMessage is a raw payload received from a broker
+type BrokerMessage struct {
+	Channel string
+	Payload []byte
+	ID      string // Backend-specific message ID, empty for plain pub/sub
+}
+
+// Subscription is a live subscription to one or more broker channels. The
+// Messages channel is closed once the subscription is closed.
+type Subscription interface {
+	Messages() <-chan *BrokerMessage
+	Subscribe(ctx context.Context, channels ...string) error
+	Unsubscribe(ctx context.Context, channels ...string) error
+	Close() error
+}
+
+// Broker delivers published messages to subscribers
+type Broker interface {
+	Name() string
+	Subscribe(ctx context.Context, channels ...string) (Subscription, error)
+	Publish(ctx context.Context, channel string, payload []byte) error
+	Close() error
+}
+
+// ResumableBroker is implemented by brokers that keep messages, so a
+// replacement subscription can pick up each channel after the last message ID
+// read. Channels without an ID start at the end, as with Subscribe.
+type ResumableBroker interface {
+	SubscribeFrom(ctx context.Context, lastIDs map[string]string, channels ...string) (Subscription, error)
+}
+
+// ResumableSubscription reports the last message ID read on each channel
+type ResumableSubscription interface {
+	LastIDs() map[string]string
+}
+
+// Broker backend names accepted by the BROKER environment variable
+const (
+	BrokerAuto         = "auto"
+	BrokerMemory       = "memory"
+	BrokerRedis        = "redis"
+	BrokerRedisStreams = "redis_streams"
+	BrokerDatabase     = "database"
+)
+
+// brokerMessageBuffer is the number of messages buffered per subscription
+const brokerMessageBuffer = 256
+
+// NewBrokerFromEnv creates the broker selected by the BROKER environment
+// variable. In auto mode it mirrors the Rails PubsubService: Redis pub/sub
+// when Redis answers a ping, otherwise the pubsub_events table when
+// DATABASE_URL is set, otherwise the in-memory broker. An explicitly
+// configured Redis (BROKER=redis, or REDIS_URL in auto mode) is used even
+// when it is down at startup; the BrokerSupervisor reconnects later.
+func NewBrokerFromEnv(logger *Logger) (Broker, error) {
+	kind := strings.ToLower(os.Getenv("BROKER"))
+	if kind == "" {
+		kind = BrokerAuto
+	}
+
+	switch kind {
+	case BrokerMemory:
+		return NewMemoryBroker(), nil
+	case BrokerRedis:
+		client := newRedisClientFromEnv(logger)
+		pingRedis(client, logger)
+		return NewRedisBroker(client), nil
+	case BrokerRedisStreams:
+		client := newRedisClientFromEnv(logger)
+		pingRedis(client, logger)
+		return NewRedisStreamsBroker(client, logger, os.Getenv("REDIS_STREAM_PREFIX"), int64(getEnvInt("REDIS_STREAM_MAXLEN", 1000))), nil
+	case BrokerDatabase:
+		return newDatabaseBrokerFromEnv(logger)
+	case BrokerAuto:
+		client := newRedisClientFromEnv(logger)
+		if pingRedis(client, logger) == nil || os.Getenv("REDIS_URL") != "" {
+			return NewRedisBroker(client), nil
+		}
+		client.Close()
+
+		if os.Getenv("DATABASE_URL") != "" {
+			broker, err := newDatabaseBrokerFromEnv(logger)
+			if err == nil {
+				return broker, nil
+			}
+			logger.Warn("Database not available: %v", err)
+		}
+
+		logger.Warn("⚠️ Falling back to in-memory broker")
+		return NewMemoryBroker(), nil
+	default:
+		return nil, fmt.Errorf("unknown broker %q", kind)
+	}
+}
+
+// newRedisClientFromEnv creates a client for REDIS_URL
+func newRedisClientFromEnv(logger *Logger) *redis.Client {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		redisURL = "redis://localhost:6379"
+	}
+
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		logger.Warn("Failed to parse Redis URL: %v", err)
+		opt = &redis.Options{
+			Addr: "localhost:6379",
+		}
+	}
+
+	return redis.NewClient(opt)
+}
+
+// pingRedis checks that Redis is reachable, logging the outcome
+func pingRedis(client *redis.Client, logger *Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		logger.Warn("Redis not available: %v", err)
+		return err
+	}
+	logger.Info("✅ Redis connected successfully")
+	return nil
+}
+
+// newDatabaseBrokerFromEnv opens DATABASE_URL with the DATABASE_DRIVER driver
+func newDatabaseBrokerFromEnv(logger *Logger) (*DatabaseBroker, error) {
+	driver := os.Getenv("DATABASE_DRIVER")
+	if driver == "" {
+		driver = "sqlite3"
+	}
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		dsn = "../storage/development.sqlite3"
+	}
+
+	registered := false
+	for _, name := range sql.Drivers() {
+		if name == driver {
+			registered = true
+			break
+		}
+	}
+	if !registered {
+		return nil, fmt.Errorf("database driver %q is not compiled in (build with -tags %s)", driver, driver)
+	}
+
+	interval := time.Duration(getEnvInt("DATABASE_POLL_INTERVAL_MS", 1000)) * time.Millisecond
+	return NewDatabaseBroker(driver, dsn, interval, logger)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// databasePollBatch matches the batch size of PubsubEvent.poll_for_new_events
+const databasePollBatch = 50
+
+// DatabaseBroker polls the Rails pubsub_events table, giving parity with the
+// Rails DatabasePubsubService on deployments without Redis. Any database/sql
+// driver can be used; it must be linked into the binary (see the driver_*.go
+// files) and named by DATABASE_DRIVER. Events stay in the table, so the
+// subscription replacing one lost in an outage resumes after the last event read.
+type DatabaseBroker struct {
+	db       *sql.DB
+	driver   string
+	interval time.Duration
+	logger   *Logger
+}
+
+// NewDatabaseBroker opens the database and verifies the connection
+func NewDatabaseBroker(driver, dsn string, interval time.Duration, logger *Logger) (*DatabaseBroker, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	logger.Info("✅ Database connected successfully (%s)", driver)
+
+	return &DatabaseBroker{
+		db:       db,
+		driver:   driver,
+		interval: interval,
+		logger:   logger,
+	}, nil
+}
+
+// Name returns the backend name
+func (b *DatabaseBroker) Name() string {
+	return BrokerDatabase
+}
+
+// placeholder returns the bind parameter syntax for the nth argument
+func (b *DatabaseBroker) placeholder(n int) string {
+	switch b.driver {
+	case "postgres", "pgx":
+		return fmt.Sprintf("$%d", n)
+	default:
+		return "?"
+	}
+}
+
+// Subscribe starts polling for events newer than the latest existing one
+func (b *DatabaseBroker) Subscribe(ctx context.Context, channels ...string) (Subscription, error) {
+	return b.SubscribeFrom(ctx, nil, channels...)
+}
+
+// SubscribeFrom starts polling after the oldest event ID in lastIDs, skipping
+// each channel's events up to its own ID. Channels without an ID start after
+// the latest existing event.
+func (b *DatabaseBroker) SubscribeFrom(ctx context.Context, lastIDs map[string]string, channels ...string) (Subscription, error) {
+	var latest sql.NullInt64
+	if err := b.db.QueryRowContext(ctx, "SELECT MAX(id) FROM pubsub_events").Scan(&latest); err != nil {
+		return nil, fmt.Errorf("reading latest pubsub event: %w", err)
+	}
+
+	subCtx, cancel := context.WithCancel(context.Background())
+	sub := &databaseSubscription{
+		broker:   b,
+		channels: make(map[string]bool),
+		floors:   make(map[string]int64),
+		lastID:   latest.Int64,
+		messages: make(chan *BrokerMessage, brokerMessageBuffer),
+		ctx:      subCtx,
+		cancel:   cancel,
+	}
+	for _, channel := range channels {
+		id, err := strconv.ParseInt(lastIDs[channel], 10, 64)
+		if err != nil {
+			// Channels without an ID start at the end, as with Subscribe
+			id = latest.Int64
+		}
+		sub.floors[channel] = id
+		if id < sub.lastID {
+			sub.lastID = id
+		}
+	}
+	sub.Subscribe(ctx, channels...)
+	go sub.run()
+	return sub, nil
+}
+
+// Publish inserts an event the same way PubsubEvent.publish does
+func (b *DatabaseBroker) Publish(ctx context.Context, channel string, payload []byte) error {
+	query := fmt.Sprintf("INSERT INTO pubsub_events (channel, data, created_at) VALUES (%s, %s, %s)",
+		b.placeholder(1), b.placeholder(2), b.placeholder(3))
+	_, err := b.db.ExecContext(ctx, query, channel, string(payload), time.Now().UTC())
+	return err
+}
+
+// Ping checks that the database is reachable
+func (b *DatabaseBroker) Ping(ctx context.Context) error {
+	return b.db.PingContext(ctx)
+}
+
+// Close closes the database handle
+func (b *DatabaseBroker) Close() error {
+	return b.db.Close()
+}
+
+// databaseSubscription polls pubsub_events by id > last seen
+type databaseSubscription struct {
+	broker   *DatabaseBroker
+	channels map[string]bool
+	floors   map[string]int64 // Channel -> event ID already read by a previous subscription
+	lastID   int64
+	messages chan *BrokerMessage
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+}
+
+// Messages returns the channel of received messages
+func (s *databaseSubscription) Messages() <-chan *BrokerMessage {
+	return s.messages
+}
+
+// Subscribe adds channels to the subscription
+func (s *databaseSubscription) Subscribe(ctx context.Context, channels ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, channel := range channels {
+		s.channels[channel] = true
+	}
+	return nil
+}
+
+// Unsubscribe removes channels from the subscription
+func (s *databaseSubscription) Unsubscribe(ctx context.Context, channels ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, channel := range channels {
+		delete(s.channels, channel)
+		delete(s.floors, channel)
+	}
+	return nil
+}
+
+// LastIDs returns the last event ID read, which is shared by every channel
+func (s *databaseSubscription) LastIDs() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lastIDs := make(map[string]string, len(s.channels))
+	for channel := range s.channels {
+		lastIDs[channel] = strconv.FormatInt(s.lastID, 10)
+	}
+	return lastIDs
+}
+
+// Close stops polling and closes the messages channel
+func (s *databaseSubscription) Close() error {
+	s.cancel()
+	return nil
+}
+
+// run polls on the broker interval until the subscription is closed
+func (s *databaseSubscription) run() {
+	defer close(s.messages)
+
+	ticker := time.NewTicker(s.broker.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Keep reading while full batches come back so bursts drain quickly
+		for {
+			n, err := s.poll()
+			if err != nil {
+				if s.ctx.Err() == nil {
+					s.broker.logger.Error("Error polling pubsub_events: %v", err)
+				}
+				break
+			}
+			if n < databasePollBatch {
+				break
+			}
+		}
+	}
+}
+
+// poll delivers one batch of new events, returning how many rows it read
+func (s *databaseSubscription) poll() (int, error) {
+	s.mu.Lock()
+	channels := make([]string, 0, len(s.channels))
+	for channel := range s.channels {
+		channels = append(channels, channel)
+	}
+	lastID := s.lastID
+	s.mu.Unlock()
+
+	if len(channels) == 0 {
+		return 0, nil
+	}
+
+	args := []interface{}{lastID}
+	placeholders := make([]string, len(channels))
+	for i, channel := range channels {
+		args = append(args, channel)
+		placeholders[i] = s.broker.placeholder(i + 2)
+	}
+	query := fmt.Sprintf("SELECT id, channel, data FROM pubsub_events WHERE id > %s AND channel IN (%s) ORDER BY id LIMIT %d",
+		s.broker.placeholder(1), strings.Join(placeholders, ", "), databasePollBatch)
+
+	rows, err := s.broker.db.QueryContext(s.ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var id int64
+		var channel string
+		var data []byte
+		if err := rows.Scan(&id, &channel, &data); err != nil {
+			return n, err
+		}
+		n++
+
+		s.mu.Lock()
+		floor, seen := s.floors[channel]
+		if seen && id > floor {
+			delete(s.floors, channel)
+		}
+		s.mu.Unlock()
+
+		if !seen || id > floor {
+			select {
+			case s.messages <- &BrokerMessage{Channel: channel, Payload: data, ID: fmt.Sprint(id)}:
+			case <-s.ctx.Done():
+				return n, s.ctx.Err()
+			}
+		}
+
+		s.mu.Lock()
+		s.lastID = id
+		s.mu.Unlock()
+	}
+	return n, rows.Err()
+}
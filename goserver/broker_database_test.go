@@ -0,0 +1,90 @@
+//go:build sqlite3
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestDatabaseBroker creates the Rails pubsub_events table in a fresh
+// SQLite database and polls it every 10ms
+func newTestDatabaseBroker(t *testing.T) *DatabaseBroker {
+	t.Helper()
+	broker, err := NewDatabaseBroker("sqlite3", t.TempDir()+"/pubsub.db", 10*time.Millisecond, NewLogger("error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+	_, err = broker.db.Exec(`CREATE TABLE pubsub_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		channel VARCHAR NOT NULL,
+		data TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return broker
+}
+
+func TestDatabaseBroker(t *testing.T) {
+	broker := newTestDatabaseBroker(t)
+	ctx := context.Background()
+
+	// Subscriptions start after the latest existing event
+	publishAll(t, broker, "a", "old")
+	sub, err := broker.Subscribe(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	publishAll(t, broker, "b", "x", "a", "1", "a", "2")
+	if got, want := receive(t, sub, 2), []string{"a 1", "a 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %q, want %q", got, want)
+	}
+
+	sub.Subscribe(ctx, "b")
+	sub.Unsubscribe(ctx, "a")
+	publishAll(t, broker, "a", "3", "b", "y")
+	if got := receive(t, sub, 1); got[0] != "b y" {
+		t.Errorf("after switching channels received %q, want b y", got)
+	}
+	if ids := sub.(ResumableSubscription).LastIDs(); !reflect.DeepEqual(ids, map[string]string{"b": "6"}) {
+		t.Errorf("LastIDs = %v, want b at 6", ids)
+	}
+
+	// Bursts larger than a poll batch are read in full
+	var burst []string
+	for i := 0; i < databasePollBatch+10; i++ {
+		burst = append(burst, "b", "burst")
+	}
+	publishAll(t, broker, burst...)
+	receive(t, sub, databasePollBatch+10)
+}
+
+func TestDatabaseBrokerResume(t *testing.T) {
+	broker := newTestDatabaseBroker(t)
+	ctx := context.Background()
+
+	// IDs 1 to 6
+	publishAll(t, broker, "a", "a1", "b", "b1", "a", "a2", "b", "b2", "a", "a3", "c", "c1")
+
+	// Each channel picks up after its own ID, and channels without one start
+	// after the latest event
+	sub, err := broker.SubscribeFrom(ctx, map[string]string{"a": "1", "b": "4"}, "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if got, want := receive(t, sub, 2), []string{"a a2", "a a3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed with %q, want %q", got, want)
+	}
+	publishAll(t, broker, "c", "c2", "b", "b3")
+	if got, want := receive(t, sub, 2), []string{"c c2", "b b3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %q, want only new events", got)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBroker is an in-process broker for local development and tests.
+// Messages only reach subscribers in the same process.
+type MemoryBroker struct {
+	subscriptions map[*memorySubscription]bool
+	mu            sync.RWMutex
+}
+
+// NewMemoryBroker creates an empty in-memory broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subscriptions: make(map[*memorySubscription]bool),
+	}
+}
+
+// Name returns the backend name
+func (b *MemoryBroker) Name() string {
+	return BrokerMemory
+}
+
+// Subscribe creates a subscription to the given channels
+func (b *MemoryBroker) Subscribe(ctx context.Context, channels ...string) (Subscription, error) {
+	sub := &memorySubscription{
+		broker:   b,
+		channels: make(map[string]bool),
+		messages: make(chan *BrokerMessage, brokerMessageBuffer),
+		closed:   make(chan struct{}),
+	}
+	sub.Subscribe(ctx, channels...)
+
+	b.mu.Lock()
+	b.subscriptions[sub] = true
+	b.mu.Unlock()
+	return sub, nil
+}
+
+// Publish delivers a payload to every subscription on the channel
+func (b *MemoryBroker) Publish(ctx context.Context, channel string, payload []byte) error {
+	msg := &BrokerMessage{Channel: channel, Payload: payload}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscriptions {
+		if !sub.subscribed(channel) {
+			continue
+		}
+		select {
+		case sub.messages <- msg:
+		case <-sub.closed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Close closes every open subscription
+func (b *MemoryBroker) Close() error {
+	b.mu.RLock()
+	subs := make([]*memorySubscription, 0, len(b.subscriptions))
+	for sub := range b.subscriptions {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+	return nil
+}
+
+// memorySubscription is a subscription to a MemoryBroker
+type memorySubscription struct {
+	broker    *MemoryBroker
+	channels  map[string]bool
+	messages  chan *BrokerMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+	mu        sync.RWMutex
+}
+
+func (s *memorySubscription) subscribed(channel string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.channels[channel]
+}
+
+// Messages returns the channel of received messages
+func (s *memorySubscription) Messages() <-chan *BrokerMessage {
+	return s.messages
+}
+
+// Subscribe adds channels to the subscription
+func (s *memorySubscription) Subscribe(ctx context.Context, channels ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, channel := range channels {
+		s.channels[channel] = true
+	}
+	return nil
+}
+
+// Unsubscribe removes channels from the subscription
+func (s *memorySubscription) Unsubscribe(ctx context.Context, channels ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, channel := range channels {
+		delete(s.channels, channel)
+	}
+	return nil
+}
+
+// Close detaches the subscription from the broker and closes its messages
+func (s *memorySubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+
+		// Publishers hold the read lock while delivering, so once the
+		// subscription is removed nothing else can send on messages
+		s.broker.mu.Lock()
+		delete(s.broker.subscriptions, s)
+		s.broker.mu.Unlock()
+
+		close(s.messages)
+	})
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisSnapshotNamespace prefixes the hash holding a channel's snapshots,
+// "snapshot#<channel>". Channel names cannot contain "#", so the hash never
+// takes the key of a channel's stream.
+const redisSnapshotNamespace = "snapshot#"
+
+// redisPresenceNamespace prefixes the hash holding a channel's presence
+// members, "presence#<channel>", kept out of the stream key space like
+// snapshots
+const redisPresenceNamespace = "presence#"
+
+// RedisBroker delivers messages over Redis PUBLISH/SUBSCRIBE, the same
+// transport the Rails RedisPubsubService publishes to
+type RedisBroker struct {
+	client *redis.Client
+}
+
+// NewRedisBroker creates a pub/sub broker on an existing client
+func NewRedisBroker(client *redis.Client) *RedisBroker {
+	return &RedisBroker{client: client}
+}
+
+// Name returns the backend name
+func (b *RedisBroker) Name() string {
+	return BrokerRedis
+}
+
+// Subscribe opens a pub/sub connection subscribed to the given channels
+func (b *RedisBroker) Subscribe(ctx context.Context, channels ...string) (Subscription, error) {
+	pubsub := b.client.Subscribe(ctx, channels...)
+
+	sub := &redisSubscription{
+		pubsub:   pubsub,
+		messages: make(chan *BrokerMessage, brokerMessageBuffer),
+	}
+	go sub.run()
+	return sub, nil
+}
+
+// Publish publishes a payload to the channel
+func (b *RedisBroker) Publish(ctx context.Context, channel string, payload []byte) error {
+	return b.client.Publish(ctx, channel, payload).Err()
+}
+
+// Ping checks that Redis is reachable
+func (b *RedisBroker) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx).Err()
+}
+
+// SaveSnapshot stores the latest payload for a snapshot key of the channel
+func (b *RedisBroker) SaveSnapshot(ctx context.Context, channel, key string, payload []byte, ttl time.Duration) error {
+	return saveRedisField(ctx, b.client, redisSnapshotNamespace+channel, key, payload, ttl)
+}
+
+// LoadSnapshots returns the stored snapshot payloads of the channel
+func (b *RedisBroker) LoadSnapshots(ctx context.Context, channel string) (map[string][]byte, error) {
+	return loadRedisFields(ctx, b.client, redisSnapshotNamespace+channel)
+}
+
+// SavePresence stores a presence member of the channel
+func (b *RedisBroker) SavePresence(ctx context.Context, channel, id string, member []byte, ttl time.Duration) error {
+	return saveRedisField(ctx, b.client, redisPresenceNamespace+channel, id, member, ttl)
+}
+
+// RemovePresence deletes a presence member, reporting whether it was there
+func (b *RedisBroker) RemovePresence(ctx context.Context, channel, id string) (bool, error) {
+	return removeRedisField(ctx, b.client, redisPresenceNamespace+channel, id)
+}
+
+// LoadPresence returns the stored presence members of the channel
+func (b *RedisBroker) LoadPresence(ctx context.Context, channel string) (map[string][]byte, error) {
+	return loadRedisFields(ctx, b.client, redisPresenceNamespace+channel)
+}
+
+// Close closes the Redis client
+func (b *RedisBroker) Close() error {
+	return b.client.Close()
+}
+
+// redisSubscription adapts a go-redis PubSub to the Subscription interface
+type redisSubscription struct {
+	pubsub   *redis.PubSub
+	messages chan *BrokerMessage
+}
+
+// run forwards Redis messages until the pub/sub connection is closed
+func (s *redisSubscription) run() {
+	defer close(s.messages)
+	for msg := range s.pubsub.Channel() {
+		s.messages <- &BrokerMessage{Channel: msg.Channel, Payload: []byte(msg.Payload)}
+	}
+}
+
+// Messages returns the channel of received messages
+func (s *redisSubscription) Messages() <-chan *BrokerMessage {
+	return s.messages
+}
+
+// Subscribe adds channels to the subscription
+func (s *redisSubscription) Subscribe(ctx context.Context, channels ...string) error {
+	return s.pubsub.Subscribe(ctx, channels...)
+}
+
+// Unsubscribe removes channels from the subscription
+func (s *redisSubscription) Unsubscribe(ctx context.Context, channels ...string) error {
+	return s.pubsub.Unsubscribe(ctx, channels...)
+}
+
+// Close closes the pub/sub connection
+func (s *redisSubscription) Close() error {
+	return s.pubsub.Close()
+}
+
+// saveRedisField writes one field of a hash, refreshing its expiry
+func saveRedisField(ctx context.Context, client *redis.Client, hashKey, key string, payload []byte, ttl time.Duration) error {
+	pipe := client.TxPipeline()
+	pipe.HSet(ctx, hashKey, key, payload)
+	if ttl > 0 {
+		pipe.Expire(ctx, hashKey, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// loadRedisFields reads every field of a hash
+func loadRedisFields(ctx context.Context, client *redis.Client, hashKey string) (map[string][]byte, error) {
+	values, err := client.HGetAll(ctx, hashKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	payloads := make(map[string][]byte, len(values))
+	for key, value := range values {
+		payloads[key] = []byte(value)
+	}
+	return payloads, nil
+}
+
+// removeRedisField deletes one field of a hash, reporting whether it existed
+func removeRedisField(ctx context.Context, client *redis.Client, hashKey, field string) (bool, error) {
+	removed, err := client.HDel(ctx, hashKey, field).Result()
+	return removed > 0, err
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisStreamsBlock is how long a single XREAD blocks, which also bounds how
+// long a newly added channel waits before it is read
+const redisStreamsBlock = time.Second
+
+// RedisStreamsBroker stores messages in one Redis stream per channel with
+// XADD and reads them with XREAD. Unlike pub/sub, messages survive subscriber
+// outages: a subscription retries from the last ID it saw, and one replacing
+// it after an outage starts there too (see SubscribeFrom).
+type RedisStreamsBroker struct {
+	client *redis.Client
+	logger *Logger
+	prefix string
+	maxLen int64
+}
+
+// NewRedisStreamsBroker creates a streams broker. Stream keys are the channel
+// name with prefix prepended, trimmed to roughly maxLen entries.
+func NewRedisStreamsBroker(client *redis.Client, logger *Logger, prefix string, maxLen int64) *RedisStreamsBroker {
+	return &RedisStreamsBroker{
+		client: client,
+		logger: logger,
+		prefix: prefix,
+		maxLen: maxLen,
+	}
+}
+
+// Name returns the backend name
+func (b *RedisStreamsBroker) Name() string {
+	return BrokerRedisStreams
+}
+
+// streamKey returns the Redis key holding a channel's stream
+func (b *RedisStreamsBroker) streamKey(channel string) string {
+	return b.prefix + channel
+}
+
+// Subscribe starts reading the given channels' streams from their current end
+func (b *RedisStreamsBroker) Subscribe(ctx context.Context, channels ...string) (Subscription, error) {
+	return b.SubscribeFrom(ctx, nil, channels...)
+}
+
+// SubscribeFrom starts reading each channel's stream after its entry in
+// lastIDs, or from the current end when it has none
+func (b *RedisStreamsBroker) SubscribeFrom(ctx context.Context, lastIDs map[string]string, channels ...string) (Subscription, error) {
+	subCtx, cancel := context.WithCancel(context.Background())
+	sub := &redisStreamsSubscription{
+		broker:   b,
+		lastIDs:  make(map[string]string),
+		messages: make(chan *BrokerMessage, brokerMessageBuffer),
+		ctx:      subCtx,
+		cancel:   cancel,
+	}
+	for _, channel := range channels {
+		if id, ok := lastIDs[channel]; ok {
+			sub.lastIDs[channel] = id
+		}
+	}
+	if err := sub.Subscribe(ctx, channels...); err != nil {
+		cancel()
+		return nil, err
+	}
+	go sub.run()
+	return sub, nil
+}
+
+// Publish appends a payload to the channel's stream
+func (b *RedisStreamsBroker) Publish(ctx context.Context, channel string, payload []byte) error {
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.streamKey(channel),
+		MaxLen: b.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"data": payload},
+	}).Err()
+}
+
+// Ping checks that Redis is reachable
+func (b *RedisStreamsBroker) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx).Err()
+}
+
+// SaveSnapshot stores the latest payload for a snapshot key under the stream prefix
+func (b *RedisStreamsBroker) SaveSnapshot(ctx context.Context, channel, key string, payload []byte, ttl time.Duration) error {
+	return saveRedisField(ctx, b.client, b.prefix+redisSnapshotNamespace+channel, key, payload, ttl)
+}
+
+// LoadSnapshots returns the stored snapshot payloads of the channel
+func (b *RedisStreamsBroker) LoadSnapshots(ctx context.Context, channel string) (map[string][]byte, error) {
+	return loadRedisFields(ctx, b.client, b.prefix+redisSnapshotNamespace+channel)
+}
+
+// SavePresence stores a presence member under the stream prefix
+func (b *RedisStreamsBroker) SavePresence(ctx context.Context, channel, id string, member []byte, ttl time.Duration) error {
+	return saveRedisField(ctx, b.client, b.prefix+redisPresenceNamespace+channel, id, member, ttl)
+}
+
+// RemovePresence deletes a presence member, reporting whether it was there
+func (b *RedisStreamsBroker) RemovePresence(ctx context.Context, channel, id string) (bool, error) {
+	return removeRedisField(ctx, b.client, b.prefix+redisPresenceNamespace+channel, id)
+}
+
+// LoadPresence returns the stored presence members of the channel
+func (b *RedisStreamsBroker) LoadPresence(ctx context.Context, channel string) (map[string][]byte, error) {
+	return loadRedisFields(ctx, b.client, b.prefix+redisPresenceNamespace+channel)
+}
+
+// Close closes the Redis client
+func (b *RedisStreamsBroker) Close() error {
+	return b.client.Close()
+}
+
+// redisStreamsSubscription polls a set of streams with blocking XREAD
+type redisStreamsSubscription struct {
+	broker   *RedisStreamsBroker
+	lastIDs  map[string]string // Channel -> last delivered stream ID
+	messages chan *BrokerMessage
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+}
+
+// Messages returns the channel of received messages
+func (s *redisStreamsSubscription) Messages() <-chan *BrokerMessage {
+	return s.messages
+}
+
+// Subscribe adds channels, starting each at the current end of its stream
+func (s *redisStreamsSubscription) Subscribe(ctx context.Context, channels ...string) error {
+	for _, channel := range channels {
+		s.mu.Lock()
+		_, subscribed := s.lastIDs[channel]
+		s.mu.Unlock()
+		if subscribed {
+			continue
+		}
+
+		lastID := "0-0"
+		entries, err := s.broker.client.XRevRangeN(ctx, s.broker.streamKey(channel), "+", "-", 1).Result()
+		if err != nil {
+			return fmt.Errorf("reading end of stream %s: %w", channel, err)
+		}
+		if len(entries) > 0 {
+			lastID = entries[0].ID
+		}
+
+		s.mu.Lock()
+		if _, ok := s.lastIDs[channel]; !ok {
+			s.lastIDs[channel] = lastID
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Unsubscribe removes channels from the subscription
+func (s *redisStreamsSubscription) Unsubscribe(ctx context.Context, channels ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, channel := range channels {
+		delete(s.lastIDs, channel)
+	}
+	return nil
+}
+
+// LastIDs returns the ID of the last entry handed on from each stream
+func (s *redisStreamsSubscription) LastIDs() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lastIDs := make(map[string]string, len(s.lastIDs))
+	for channel, id := range s.lastIDs {
+		lastIDs[channel] = id
+	}
+	return lastIDs
+}
+
+// Close stops reading and closes the messages channel
+func (s *redisStreamsSubscription) Close() error {
+	s.cancel()
+	return nil
+}
+
+// run reads new stream entries until the subscription is closed
+func (s *redisStreamsSubscription) run() {
+	defer close(s.messages)
+
+	for s.ctx.Err() == nil {
+		s.mu.Lock()
+		channels := make([]string, 0, len(s.lastIDs))
+		streams := make([]string, 0, 2*len(s.lastIDs))
+		ids := make([]string, 0, len(s.lastIDs))
+		for channel, id := range s.lastIDs {
+			channels = append(channels, channel)
+			streams = append(streams, s.broker.streamKey(channel))
+			ids = append(ids, id)
+		}
+		s.mu.Unlock()
+
+		if len(channels) == 0 {
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(redisStreamsBlock):
+			}
+			continue
+		}
+
+		results, err := s.broker.client.XRead(s.ctx, &redis.XReadArgs{
+			Streams: append(streams, ids...),
+			Block:   redisStreamsBlock,
+			Count:   100,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			s.broker.logger.Error("Error reading Redis streams: %v", err)
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(redisStreamsBlock):
+			}
+			continue
+		}
+
+		for _, stream := range results {
+			channel := stream.Stream[len(s.broker.prefix):]
+			for _, entry := range stream.Messages {
+				s.mu.Lock()
+				_, subscribed := s.lastIDs[channel]
+				s.mu.Unlock()
+				if !subscribed {
+					break
+				}
+
+				payload, _ := entry.Values["data"].(string)
+				select {
+				case s.messages <- &BrokerMessage{Channel: channel, Payload: []byte(payload), ID: entry.ID}:
+				case <-s.ctx.Done():
+					return
+				}
+
+				// Only advance once handed on, so a replacement re-reads anything dropped here
+				s.mu.Lock()
+				if _, subscribed := s.lastIDs[channel]; subscribed {
+					s.lastIDs[channel] = entry.ID
+				}
+				s.mu.Unlock()
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestStreamsBroker connects a streams broker to REDIS_URL under a prefix
+// of its own, skipping the test when Redis is not reachable
+func newTestStreamsBroker(t *testing.T) *RedisStreamsBroker {
+	t.Helper()
+	client := newRedisClientFromEnv(NewLogger("error"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		t.Skipf("Redis not available: %v", err)
+	}
+
+	prefix := fmt.Sprintf("goserver_test_%d:", time.Now().UnixNano())
+	t.Cleanup(func() {
+		keys, _ := client.Keys(context.Background(), prefix+"*").Result()
+		if len(keys) > 0 {
+			client.Del(context.Background(), keys...)
+		}
+		client.Close()
+	})
+	return NewRedisStreamsBroker(client, NewLogger("error"), prefix, 100)
+}
+
+func TestRedisStreamsBroker(t *testing.T) {
+	broker := newTestStreamsBroker(t)
+	ctx := context.Background()
+
+	// Subscriptions start at the end of each stream
+	publishAll(t, broker, "a", "old")
+	sub, err := broker.Subscribe(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	publishAll(t, broker, "b", "x", "a", "1", "a", "2")
+	if got, want := receive(t, sub, 2), []string{"a 1", "a 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %q, want %q", got, want)
+	}
+
+	if err := sub.Subscribe(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	sub.Unsubscribe(ctx, "a")
+	publishAll(t, broker, "a", "3", "b", "y")
+	if got := receive(t, sub, 1); got[0] != "b y" {
+		t.Errorf("after switching channels received %q, want b y", got)
+	}
+	if ids := sub.(ResumableSubscription).LastIDs(); len(ids) != 1 || ids["b"] == "" {
+		t.Errorf("LastIDs = %v, want the last entry of b", ids)
+	}
+}
+
+func TestRedisStreamsBrokerResume(t *testing.T) {
+	broker := newTestStreamsBroker(t)
+	ctx := context.Background()
+
+	sub, err := broker.Subscribe(ctx, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishAll(t, broker, "a", "a1", "b", "b1", "a", "a2", "b", "b2")
+	ids := make(map[string]string)
+	for len(ids) < 4 {
+		select {
+		case msg := <-sub.Messages():
+			ids[string(msg.Payload)] = msg.ID
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received IDs %v, want 4", ids)
+		}
+	}
+	sub.Close()
+	publishAll(t, broker, "a", "a3", "c", "c1")
+
+	// Each channel picks up after its own ID, and channels without one start
+	// at the end
+	resumed, err := broker.SubscribeFrom(ctx, map[string]string{"a": ids["a1"], "b": ids["b2"]}, "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if got, want := receive(t, resumed, 2), []string{"a a2", "a a3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed with %q, want %q", got, want)
+	}
+	publishAll(t, broker, "c", "c2")
+	if got := receive(t, resumed, 1); got[0] != "c c2" {
+		t.Errorf("received %q on c, want only new entries", got)
+	}
+}
+
+func TestRedisStreamsSnapshotKeys(t *testing.T) {
+	broker := newTestStreamsBroker(t)
+	ctx := context.Background()
+
+	if err := broker.SaveSnapshot(ctx, "a", "metrics", []byte(`{"n":1}`), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Channels named like the snapshot hash still get streams of their own
+	publishAll(t, broker, "a:snapshot", "1", "snapshot", "2", "snapshot:a", "3")
+	snapshots, err := broker.LoadSnapshots(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]byte{"metrics": []byte(`{"n":1}`)}; !reflect.DeepEqual(snapshots, want) {
+		t.Errorf("LoadSnapshots = %q, want %q", snapshots, want)
+	}
+
+	// As do channels named like the presence hash
+	if err := broker.SavePresence(ctx, "a", "conn_1", []byte(`{"subject":"u1"}`), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	publishAll(t, broker, "a:presence_members", "4", "presence:a", "5")
+	members, err := broker.LoadPresence(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]byte{"conn_1": []byte(`{"subject":"u1"}`)}; !reflect.DeepEqual(members, want) {
+		t.Errorf("LoadPresence = %q, want %q", members, want)
+	}
+}
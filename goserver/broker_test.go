@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// receive waits for the next n messages of a subscription, formatted as
+// "channel payload"
+func receive(t *testing.T, sub Subscription, n int) []string {
+	t.Helper()
+	var got []string
+	for i := 0; i < n; i++ {
+		select {
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				t.Fatalf("subscription closed after %q", got)
+			}
+			got = append(got, msg.Channel+" "+string(msg.Payload))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %q, want %d messages", got, n)
+		}
+	}
+	return got
+}
+
+// publishAll publishes each payload to its channel, given as channel,
+// payload pairs
+func publishAll(t *testing.T, broker Broker, pairs ...string) {
+	t.Helper()
+	for i := 0; i < len(pairs); i += 2 {
+		if err := broker.Publish(context.Background(), pairs[i], []byte(pairs[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	broker := NewMemoryBroker()
+	sub, err := broker.Subscribe(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := broker.Subscribe(context.Background(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishAll(t, broker, "a", "1", "b", "x", "a", "2")
+	if got, want := receive(t, sub, 2), []string{"a 1", "a 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %q, want %q", got, want)
+	}
+	if got := receive(t, other, 1); got[0] != "b x" {
+		t.Errorf("other subscription received %q", got)
+	}
+
+	sub.Subscribe(context.Background(), "b")
+	sub.Unsubscribe(context.Background(), "a")
+	publishAll(t, broker, "a", "3", "b", "y")
+	if got := receive(t, sub, 1); got[0] != "b y" {
+		t.Errorf("after switching channels received %q, want b y", got)
+	}
+
+	// Closing the broker closes every subscription
+	broker.Close()
+	for _, s := range []Subscription{sub, other} {
+		for range s.Messages() {
+		}
+	}
+}
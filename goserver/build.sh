@@ -13,7 +13,7 @@ fi
 
 # Build the server
 echo "📦 Building server..."
-go build -o sse-server main.go
+go build -o sse-server .
 
 if [ $? -eq 0 ]; then
     echo "✅ Server built successfully!"
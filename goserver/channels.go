@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// streamParamPattern matches {param} placeholders in stream templates
+var streamParamPattern = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// ChannelDefinition declares an ActionCable channel class, the streams a
+// subscription to it receives and who may subscribe
+type ChannelDefinition struct {
+	Class         string                    `json:"class"`
+	Streams       []string                  `json:"streams"` // Templates such as "dashboard_updates:{team_id}"
+	Authorization ChannelAuthorization      `json:"authorization"`
+	Actions       map[string]*ChannelAction `json:"actions"` // Performed by subscribers and forwarded to the Rails app
+}
+
+// ChannelAuthorization is the rule a subscription's identifier params must
+// satisfy. Params referenced by stream templates are always required.
+type ChannelAuthorization struct {
+	RequireParams []string          `json:"require_params"`
+	ParamPatterns map[string]string `json:"param_patterns"` // Param -> regexp its value must fully match
+
+	patterns map[string]*regexp.Regexp
+}
+
+// ChannelRegistryConfig is the on-disk channel registry format
+type ChannelRegistryConfig struct {
+	Channels            []*ChannelDefinition       `json:"channels"`
+	StreamAuthorization *StreamAuthorizationConfig `json:"stream_authorization"`
+}
+
+// ChannelRegistry maps ActionCable channel classes to their definitions
+type ChannelRegistry struct {
+	channels   map[string]*ChannelDefinition
+	templates  []streamTemplate // Every channel's stream templates, static ones first
+	authorizer *Authorizer
+}
+
+// streamTemplate is a stream template declared by a channel, and the
+// streams it produces
+type streamTemplate struct {
+	template string
+	pattern  *regexp.Regexp
+	channel  *ChannelDefinition
+}
+
+// DefaultChannelRegistry returns the registry used when no config file
+// exists, matching the Rails DashboardUpdatesChannel
+func DefaultChannelRegistry() *ChannelRegistry {
+	registry, _ := NewChannelRegistry(&ChannelRegistryConfig{
+		Channels: []*ChannelDefinition{
+			{Class: "DashboardUpdatesChannel", Streams: []string{"dashboard_updates"}},
+		},
+	})
+	return registry
+}
+
+// LoadChannelRegistry reads a JSON channel registry, falling back to the
+// default registry when the file does not exist
+func LoadChannelRegistry(path string, logger *Logger) (*ChannelRegistry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		logger.Info("📋 Channel config %s not found, using default channels", path)
+		return DefaultChannelRegistry(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config ChannelRegistryConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	registry, err := NewChannelRegistry(&config)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", path, err)
+	}
+	logger.Info("📋 Loaded %d channels from %s", len(registry.channels), path)
+	return registry, nil
+}
+
+// NewChannelRegistry validates a registry config
+func NewChannelRegistry(config *ChannelRegistryConfig) (*ChannelRegistry, error) {
+	registry := &ChannelRegistry{channels: make(map[string]*ChannelDefinition)}
+	for _, def := range config.Channels {
+		if def.Class == "" {
+			return nil, fmt.Errorf("channel without class")
+		}
+		if len(def.Streams) == 0 {
+			return nil, fmt.Errorf("channel %s has no streams", def.Class)
+		}
+		if _, exists := registry.channels[def.Class]; exists {
+			return nil, fmt.Errorf("channel %s defined twice", def.Class)
+		}
+
+		def.Authorization.patterns = make(map[string]*regexp.Regexp)
+		for param, pattern := range def.Authorization.ParamPatterns {
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("channel %s param %s: %w", def.Class, param, err)
+			}
+			def.Authorization.patterns[param] = re
+		}
+		for name, action := range def.Actions {
+			if err := action.compile(); err != nil {
+				return nil, fmt.Errorf("channel %s action %s: %w", def.Class, name, err)
+			}
+		}
+		registry.channels[def.Class] = def
+		for _, template := range def.Streams {
+			registry.templates = append(registry.templates, streamTemplate{template: template, pattern: compileStreamPattern(template), channel: def})
+		}
+	}
+	sort.SliceStable(registry.templates, func(i, j int) bool {
+		return !streamParamPattern.MatchString(registry.templates[i].template) && streamParamPattern.MatchString(registry.templates[j].template)
+	})
+
+	authorizer, err := NewAuthorizer(config.StreamAuthorization)
+	if err != nil {
+		return nil, fmt.Errorf("stream authorization: %w", err)
+	}
+	authorizer.declared = registry.Declared
+	registry.authorizer = authorizer
+	return registry, nil
+}
+
+// Lookup returns the definition for a channel class
+func (r *ChannelRegistry) Lookup(channelClass string) (*ChannelDefinition, bool) {
+	def, ok := r.channels[channelClass]
+	return def, ok
+}
+
+// Template returns the declared stream template a stream comes from, such
+// as "dashboard_updates:{team_id}" for "dashboard_updates:42". A presence
+// stream comes from its channel's template plus ":presence".
+func (r *ChannelRegistry) Template(stream string) (string, bool) {
+	if base, ok := strings.CutSuffix(stream, presenceStreamSuffix); ok {
+		template, ok := r.Template(base)
+		return template + presenceStreamSuffix, ok
+	}
+	for _, t := range r.templates {
+		if t.pattern.MatchString(stream) {
+			return t.template, true
+		}
+	}
+	return "", false
+}
+
+// Declared checks that some channel produces the stream, with params its
+// param_patterns allow, so SSE clients cannot name streams a subscription
+// could not resolve to. A presence stream is declared with its channel.
+func (r *ChannelRegistry) Declared(stream string) error {
+	base := strings.TrimSuffix(stream, presenceStreamSuffix)
+	for _, t := range r.templates {
+		values := t.pattern.FindStringSubmatch(base)
+		if values == nil {
+			continue
+		}
+		allowed := true
+		for i, name := range t.pattern.SubexpNames() {
+			if re, ok := t.channel.Authorization.patterns[name]; ok && name != "" && !re.MatchString(values[i]) {
+				allowed = false
+			}
+		}
+		if allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("stream %s is not declared by any channel", stream)
+}
+
+// Authorizer returns the stream policies shared by WebSocket and SSE clients
+func (r *ChannelRegistry) Authorizer() *Authorizer {
+	return r.authorizer
+}
+
+// Resolve authorizes a subscription's params and returns the concrete
+// streams it receives
+func (d *ChannelDefinition) Resolve(params map[string]interface{}) ([]string, error) {
+	values := make(map[string]string)
+	for name, value := range params {
+		if name == "channel" {
+			continue
+		}
+		if s, ok := paramString(value); ok {
+			values[name] = s
+		}
+	}
+
+	for _, name := range d.Authorization.RequireParams {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("missing required param %s", name)
+		}
+	}
+	for name, re := range d.Authorization.patterns {
+		value, ok := values[name]
+		if ok && !re.MatchString(value) {
+			return nil, fmt.Errorf("param %s value %q not allowed", name, value)
+		}
+	}
+
+	streams := make([]string, 0, len(d.Streams))
+	for _, template := range d.Streams {
+		var missing string
+		stream := streamParamPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+			name := placeholder[1 : len(placeholder)-1]
+			value, ok := values[name]
+			if !ok {
+				missing = name
+			}
+			return value
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("missing stream param %s", missing)
+		}
+		if !sseEventNamePattern.MatchString(stream) {
+			return nil, fmt.Errorf("invalid stream name %q", stream)
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// paramString formats a scalar identifier param for use in a stream name
+func paramString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		v = strings.TrimSpace(v)
+		return v, v != ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// DeltaEncodingJSONPatch is the opt-in encoding that sends RFC 6902 patches
+// against the last payload a client received, instead of full payloads
+const DeltaEncodingJSONPatch = "json-patch"
+
+// Delta frame kinds
+const (
+	DeltaFull  = "full"
+	DeltaPatch = "patch"
+)
+
+// defaultDeltaFullEvery is how many patches a stream gets between full payloads
+const defaultDeltaFullEvery = 50
+
+// DeltaMessage is the payload sent in place of each event in json-patch mode.
+// Patches apply to the document last received for the same stream and key,
+// whose event ID is Base; clients that lost track should resync.
+type DeltaMessage struct {
+	Delta  string           `json:"delta"`
+	Stream string           `json:"stream"`
+	Key    string           `json:"key"`
+	ID     uint64           `json:"id"`
+	Base   uint64           `json:"base,omitempty"`
+	Data   interface{}      `json:"data,omitempty"`
+	Patch  []PatchOperation `json:"patch,omitempty"`
+}
+
+// parseDeltaEncoding validates a requested encoding; empty means full payloads
+func parseDeltaEncoding(encoding string) (bool, error) {
+	switch encoding {
+	case "":
+		return false, nil
+	case DeltaEncodingJSONPatch:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+// DeltaEncoder tracks what one client last received for each stream and
+// payload type. Encoding happens in the client's writer, after the send
+// queue, so frames dropped for a slow consumer never leave the client's
+// state behind the encoder's.
+type DeltaEncoder struct {
+	fullEvery int
+	states    map[string]*deltaState // stream + key -> last sent document
+	mu        sync.Mutex
+}
+
+// deltaState is the document a client holds for one stream and key
+type deltaState struct {
+	id        uint64
+	doc       interface{}
+	sinceFull int
+}
+
+// NewDeltaEncoder creates an encoder sending a full payload at least every
+// fullEvery frames per stream and key
+func NewDeltaEncoder(fullEvery int) *DeltaEncoder {
+	if fullEvery <= 0 {
+		fullEvery = defaultDeltaFullEvery
+	}
+	return &DeltaEncoder{fullEvery: fullEvery, states: make(map[string]*deltaState)}
+}
+
+// Encode returns the delta frame for an event: a full payload the first time
+// a stream and key is seen, periodically, or when a patch would be no
+// smaller, and a patch otherwise
+func (d *DeltaEncoder) Encode(event *Event) ([]byte, error) {
+	key := event.Type
+	if key == "" {
+		key = snapshotUntyped
+	}
+	doc, err := decodedData(event)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	full, err := json.Marshal(DeltaMessage{Delta: DeltaFull, Stream: event.Channel, Key: key, ID: event.ID, Data: doc})
+	if err != nil {
+		return nil, err
+	}
+
+	stateKey := event.Channel + "\x00" + key
+	if state, ok := d.states[stateKey]; ok && state.sinceFull < d.fullEvery {
+		patch, err := json.Marshal(DeltaMessage{
+			Delta:  DeltaPatch,
+			Stream: event.Channel,
+			Key:    key,
+			ID:     event.ID,
+			Base:   state.id,
+			Patch:  DiffJSON(state.doc, doc),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(patch) < len(full) {
+			state.id, state.doc = event.ID, doc
+			state.sinceFull++
+			return patch, nil
+		}
+	}
+
+	d.states[stateKey] = &deltaState{id: event.ID, doc: doc}
+	return full, nil
+}
+
+// Reset forgets everything sent, so the next frame of every stream is full
+func (d *DeltaEncoder) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.states = make(map[string]*deltaState)
+}
+
+// decodedData returns an event's payload as generic decoded JSON, which is
+// what the diff compares
+func decodedData(event *Event) (interface{}, error) {
+	switch event.Data.(type) {
+	case map[string]interface{}, []interface{}, string, float64, bool, nil:
+		return event.Data, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(event.JSON, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
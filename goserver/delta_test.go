@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// deltaEvent builds an event from a JSON payload
+func deltaEvent(t *testing.T, id uint64, payload string) *Event {
+	t.Helper()
+	return &Event{ID: id, Channel: "dashboard_updates", Type: "metrics", Data: decodeJSON(t, payload), JSON: []byte(payload)}
+}
+
+// encodeDelta encodes an event and decodes the frame
+func encodeDelta(t *testing.T, d *DeltaEncoder, event *Event) (DeltaMessage, []byte) {
+	t.Helper()
+	frame, err := d.Encode(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message DeltaMessage
+	if err := json.Unmarshal(frame, &message); err != nil {
+		t.Fatal(err)
+	}
+	return message, frame
+}
+
+func TestDeltaEncoderPatchesApply(t *testing.T) {
+	d := NewDeltaEncoder(0)
+	payloads := []string{
+		`{"cpu":{"load":1,"cores":8},"hosts":["a","b","c"],"note":"a note long enough that resending it costs more than describing the change to the rest of the document"}`,
+		`{"cpu":{"load":2,"cores":8},"hosts":["z","a","b","c"],"note":"a note long enough that resending it costs more than describing the change to the rest of the document"}`,
+		`{"cpu":{"load":2,"cores":8},"hosts":["a","b"],"note":"a note long enough that resending it costs more than describing the change to the rest of the document","extra":null}`,
+		`{"cpu":{"load":3,"cores":8},"hosts":["a","b"],"note":"a note long enough that resending it costs more than describing the change to the rest of the document"}`,
+	}
+
+	var doc interface{}
+	var lastID uint64
+	for i, payload := range payloads {
+		message, _ := encodeDelta(t, d, deltaEvent(t, uint64(i+1), payload))
+		switch message.Delta {
+		case DeltaFull:
+			if i != 0 {
+				t.Errorf("update %d sent full, want a patch", i)
+			}
+			doc = message.Data
+		case DeltaPatch:
+			if message.Base != lastID {
+				t.Fatalf("update %d patches base %d, want %d", i, message.Base, lastID)
+			}
+			doc = applyPatch(t, doc, message.Patch)
+		}
+		lastID = message.ID
+		if want := decodeJSON(t, payload); !reflect.DeepEqual(doc, want) {
+			t.Fatalf("after update %d client holds %v, want %v", i, doc, want)
+		}
+	}
+}
+
+func TestDeltaEncoderFullWhenPatchIsLarger(t *testing.T) {
+	d := NewDeltaEncoder(0)
+	encodeDelta(t, d, deltaEvent(t, 1, `{"a":1,"b":2,"c":3,"d":4}`))
+	message, frame := encodeDelta(t, d, deltaEvent(t, 2, `{"e":5,"padding":"enough text to make a patch worthwhile"}`))
+	if message.Delta != DeltaFull {
+		t.Fatalf("frame %s is a patch larger than the document, want full", frame)
+	}
+
+	// The full frame becomes the new base
+	message, _ = encodeDelta(t, d, deltaEvent(t, 3, `{"e":6,"padding":"enough text to make a patch worthwhile"}`))
+	if message.Delta != DeltaPatch || message.Base != 2 {
+		t.Errorf("next frame = %s from base %d, want a patch from 2", message.Delta, message.Base)
+	}
+}
+
+func TestDeltaEncoderFullEvery(t *testing.T) {
+	d := NewDeltaEncoder(2)
+	base := `{"counter":%d,"padding":"enough text to make a patch worthwhile"}`
+	var kinds []string
+	for i := 1; i <= 6; i++ {
+		message, _ := encodeDelta(t, d, deltaEvent(t, uint64(i), fmt.Sprintf(base, i)))
+		kinds = append(kinds, message.Delta)
+	}
+	want := []string{DeltaFull, DeltaPatch, DeltaPatch, DeltaFull, DeltaPatch, DeltaPatch}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("frames = %v, want %v", kinds, want)
+	}
+
+	d.Reset()
+	if message, _ := encodeDelta(t, d, deltaEvent(t, 7, fmt.Sprintf(base, 7))); message.Delta != DeltaFull {
+		t.Errorf("first frame after Reset = %s, want full", message.Delta)
+	}
+}
+
+func TestDeltaEncoderKeysAreIndependent(t *testing.T) {
+	d := NewDeltaEncoder(0)
+	payload := `{"value":1,"padding":"enough text to make a patch worthwhile"}`
+	encodeDelta(t, d, deltaEvent(t, 1, payload))
+
+	other := deltaEvent(t, 2, payload)
+	other.Type = "alerts"
+	if message, _ := encodeDelta(t, d, other); message.Delta != DeltaFull || message.Key != "alerts" {
+		t.Errorf("first alerts frame = %s %q, want full alerts", message.Delta, message.Key)
+	}
+
+	untyped := deltaEvent(t, 3, payload)
+	untyped.Type = ""
+	if message, _ := encodeDelta(t, d, untyped); message.Key != snapshotUntyped {
+		t.Errorf("untyped key = %q, want %q", message.Key, snapshotUntyped)
+	}
+}
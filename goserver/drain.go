@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Drain tuning
+const (
+	// drainMinRetry is the smallest reconnect delay hinted to SSE clients
+	drainMinRetry = 1 * time.Second
+	// drainPollInterval is how often the drain checks for remaining connections
+	drainPollInterval = 100 * time.Millisecond
+)
+
+// Drain asks an SSE connection's handler to flush queued events, send a
+// final disconnect event with a retry hint, and close. Only the first call has any
+// effect.
+func (c *SSEConnection) Drain(retry time.Duration) {
+	c.drainOnce.Do(func() {
+		c.drainRetry = retry
+		close(c.drain)
+	})
+}
+
+// Draining reports whether the server has begun shutting down
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// Shutdown drains the server: it stops accepting connections, closes every
+// open stream spread over the stagger window so clients do not all reconnect
+// to the next instance at once, and waits for the handlers to finish until
+// ctx expires.
+func (s *Server) Shutdown(ctx context.Context, httpServer *http.Server, stagger time.Duration) {
+	s.draining.Store(true)
+
+	// Stats streams have nothing to drain; end them with the sampler
+	s.sampler.Stop()
+
+	// Shutdown closes the listeners immediately, then waits for the SSE
+	// handlers; hijacked WebSocket connections are tracked separately below
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- httpServer.Shutdown(ctx)
+	}()
+
+	s.drainConnections(ctx, stagger)
+
+	if err := <-shutdownDone; err != nil {
+		s.logger.Warn("⚠️ Drain timed out, closing remaining connections: %v", err)
+		httpServer.Close()
+	}
+	s.waitForConnections(ctx)
+}
+
+// drainConnections disconnects every open connection, one at a time, evenly
+// spread over the stagger window
+func (s *Server) drainConnections(ctx context.Context, stagger time.Duration) {
+	s.sseMutex.RLock()
+	sseConns := make([]*SSEConnection, 0, len(s.sseConnections))
+	for _, conn := range s.sseConnections {
+		sseConns = append(sseConns, conn)
+	}
+	s.sseMutex.RUnlock()
+
+	s.wsMutex.RLock()
+	wsConns := make([]*WebSocketConnection, 0, len(s.wsConnections))
+	for _, conn := range s.wsConnections {
+		wsConns = append(wsConns, conn)
+	}
+	s.wsMutex.RUnlock()
+
+	total := len(sseConns) + len(wsConns)
+	if total == 0 {
+		return
+	}
+	s.logger.Info("🚰 Draining %d SSE and %d WebSocket connections over %s", len(sseConns), len(wsConns), stagger)
+
+	// Interleave the protocols so neither reconnects in one burst
+	closers := make([]func(), 0, total)
+	for _, conn := range sseConns {
+		conn := conn
+		closers = append(closers, func() {
+			// Jitter the hinted retry as well, for clients that reconnect
+			// before the drain has finished
+			retry := drainMinRetry
+			if stagger > 0 {
+				retry += time.Duration(rand.Int63n(int64(stagger)))
+			}
+			conn.Drain(retry)
+		})
+	}
+	for _, conn := range wsConns {
+		conn := conn
+		closers = append(closers, func() {
+			conn.Disconnect(DisconnectServerRestart, true)
+		})
+	}
+	rand.Shuffle(len(closers), func(i, j int) { closers[i], closers[j] = closers[j], closers[i] })
+
+	interval := stagger / time.Duration(total)
+	for i, closeConn := range closers {
+		closeConn()
+		if i == len(closers)-1 || interval <= 0 {
+			continue
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			// Out of time: close the rest without waiting
+			for _, rest := range closers[i+1:] {
+				rest()
+			}
+			return
+		}
+	}
+}
+
+// waitForConnections waits until every connection handler has exited or ctx
+// expires
+func (s *Server) waitForConnections(ctx context.Context) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		s.sseMutex.RLock()
+		s.wsMutex.RLock()
+		remaining := len(s.sseConnections) + len(s.wsConnections)
+		s.wsMutex.RUnlock()
+		s.sseMutex.RUnlock()
+		if remaining == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.logger.Warn("⚠️ %d connections still open at end of drain", remaining)
+			return
+		}
+	}
+}
+
+// rejectWhileDraining refuses new streams once shutdown has begun, pointing
+// clients at another instance via Retry-After
+func (s *Server) rejectWhileDraining(w http.ResponseWriter) bool {
+	if !s.Draining() {
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+	return true
+}
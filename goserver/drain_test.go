@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// onlySSEConnection returns the server's single open SSE connection
+func onlySSEConnection(t *testing.T, s *Server) *SSEConnection {
+	t.Helper()
+	s.sseMutex.RLock()
+	defer s.sseMutex.RUnlock()
+	if len(s.sseConnections) != 1 {
+		t.Fatalf("%d SSE connections open, want 1", len(s.sseConnections))
+	}
+	for _, conn := range s.sseConnections {
+		return conn
+	}
+	return nil
+}
+
+func TestSSEDisconnectEvents(t *testing.T) {
+	tests := []struct {
+		name      string
+		close     func(conn *SSEConnection)
+		flushes   bool // Delivers events queued before the close
+		wantRetry string
+		wantData  string
+	}{
+		{
+			name:      "drain",
+			close:     func(conn *SSEConnection) { conn.Drain(1500 * time.Millisecond) },
+			flushes:   true,
+			wantRetry: "1500",
+			wantData:  `{"type":"disconnect","reason":"server_restart","reconnect":true}`,
+		},
+		{
+			name:      "disconnect and reconnect",
+			close:     func(conn *SSEConnection) { conn.Disconnect(DisconnectRemote, true) },
+			wantRetry: "",
+			wantData:  `{"type":"disconnect","reason":"remote","reconnect":true}`,
+		},
+		{
+			name:      "disconnect for good",
+			close:     func(conn *SSEConnection) { conn.Disconnect(DisconnectUnauthorized, false) },
+			wantRetry: "86400000",
+			wantData:  `{"type":"disconnect","reason":"unauthorized","reconnect":false}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			stream := openSSEStream(t, s, "/stream/alerts", nil)
+			conn := onlySSEConnection(t, s)
+
+			if _, err := s.hub.Publish(context.Background(), "alerts", []byte(`{"type":"alert","n":1}`)); err != nil {
+				t.Fatal(err)
+			}
+			if tt.flushes {
+				tt.close(conn)
+			}
+			if frame := stream.Next(t); frame.Event != "alerts.alert" || frame.Data != `{"n":1,"type":"alert"}` {
+				t.Errorf("first frame = %+v, want the alert", frame)
+			}
+			if !tt.flushes {
+				tt.close(conn)
+			}
+			frame := stream.Next(t)
+			if frame.Event != "disconnect" || frame.Retry != tt.wantRetry || frame.Data != tt.wantData || frame.ID != "" {
+				t.Errorf("final frame = %+v, want event disconnect, retry %q, data %s", frame, tt.wantRetry, tt.wantData)
+			}
+			if frame, ok := <-stream.frames; ok {
+				t.Errorf("frame %+v after the disconnect", frame)
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build postgres
+
+package main
+
+// Links the PostgreSQL driver for the database broker when building with
+// -tags postgres.
+import _ "github.com/lib/pq"
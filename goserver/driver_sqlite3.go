@@ -0,0 +1,7 @@
+//go:build sqlite3
+
+package main
+
+// Links the SQLite driver for the database broker when building with
+// -tags sqlite3. Requires cgo.
+import _ "github.com/mattn/go-sqlite3"
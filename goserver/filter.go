@@ -0,0 +1,521 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Bounds on filter expressions accepted from clients
+const (
+	maxFilterLength = 1024
+	maxFilterDepth  = 32 // Nested parentheses and negations
+)
+
+// Filter is a compiled subscription filter: a predicate evaluated against
+// each payload before it is queued for the client. Expressions compare
+// payload paths with literals, e.g.
+//
+//	type == "metrics"
+//	activities[*].level in ["warning", "error"] && !(system_status.status == "online")
+//
+// A path through [*] yields every array element, and a comparison holds when
+// any of the path's values satisfies it.
+type Filter struct {
+	source string
+	root   filterNode
+}
+
+// filterNode is a node of a compiled filter expression
+type filterNode interface {
+	eval(data interface{}) bool
+}
+
+// ParseFilter compiles a filter expression; an empty expression means no filter
+func ParseFilter(source string) (*Filter, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	if len(source) > maxFilterLength {
+		return nil, fmt.Errorf("filter longer than %d characters", maxFilterLength)
+	}
+	tokens, err := lexFilter(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return &Filter{source: source, root: root}, nil
+}
+
+// Match reports whether a decoded payload passes the filter
+func (f *Filter) Match(data interface{}) bool {
+	return f.root.eval(data)
+}
+
+// Allows reports whether an event should be sent to a client with this
+// filter. A nil filter allows everything, and server notices always pass.
+func (f *Filter) Allows(event *Event) bool {
+	return f == nil || event.System || f.Match(event.Data)
+}
+
+// String returns the filter's source expression
+func (f *Filter) String() string {
+	return f.source
+}
+
+// Token kinds
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenPunct
+)
+
+// filterToken is a lexed piece of a filter expression
+type filterToken struct {
+	kind  int
+	text  string
+	value interface{} // Decoded string or number literal
+}
+
+func (t filterToken) String() string {
+	if t.kind == tokenEOF {
+		return "end of filter"
+	}
+	return strconv.Quote(t.text)
+}
+
+// filterPuncts lists operators, longest first so "==" wins over "="
+var filterPuncts = []string{"==", "!=", "<=", ">=", "=~", "&&", "||", "[*]", "<", ">", "!", "(", ")", "[", "]", ",", "."}
+
+// lexFilter splits a filter expression into tokens
+func lexFilter(source string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"' || c == '\'':
+			j := i + 1
+			var text strings.Builder
+			for ; j < len(source) && source[j] != c; j++ {
+				if source[j] == '\\' && j+1 < len(source) {
+					j++
+				}
+				text.WriteByte(source[j])
+			}
+			if j >= len(source) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: source[i : j+1], value: text.String()})
+			i = j + 1
+
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(source) && (source[j] == '.' || (source[j] >= '0' && source[j] <= '9')) {
+				j++
+			}
+			n, err := strconv.ParseFloat(source[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", source[i:j])
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: source[i:j], value: n})
+			i = j
+
+		case c == '_' || isIdentStart(source[i:]):
+			j := i
+			for j < len(source) {
+				r, size := utf8.DecodeRuneInString(source[j:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				j += size
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: source[i:j]})
+			i = j
+
+		default:
+			matched := false
+			for _, punct := range filterPuncts {
+				if strings.HasPrefix(source[i:], punct) {
+					tokens = append(tokens, filterToken{kind: tokenPunct, text: punct})
+					i += len(punct)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEOF}), nil
+}
+
+// isIdentStart reports whether source starts with a letter
+func isIdentStart(source string) bool {
+	r, _ := utf8.DecodeRuneInString(source)
+	return unicode.IsLetter(r)
+}
+
+// filterParser is a recursive descent parser over filter tokens
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	depth  int // Current nesting of parentheses and negations
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+// accept consumes the next token if it is the given punctuation or keyword
+func (p *filterParser) accept(text string) bool {
+	if token := p.peek(); (token.kind == tokenPunct || token.kind == tokenIdent) && token.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %q but found %s", text, p.peek())
+	}
+	return nil
+}
+
+// parseOr parses a || b || ...
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right filterNode
+		if right, err = p.parseAnd(); err == nil {
+			left = filterOr{left, right}
+		}
+	}
+	return left, err
+}
+
+// parseAnd parses a && b && ...
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept("&&") {
+		var right filterNode
+		if right, err = p.parseUnary(); err == nil {
+			left = filterAnd{left, right}
+		}
+	}
+	return left, err
+}
+
+// parseUnary parses !a, (a) and comparisons
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.depth++; p.depth > maxFilterDepth {
+		return nil, fmt.Errorf("nested deeper than %d levels", maxFilterDepth)
+	}
+	defer func() { p.depth-- }()
+
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		return filterNot{operand}, err
+	}
+	if p.accept("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses path op value, or a bare path tested for truthiness
+func (p *filterParser) parseComparison() (filterNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+	switch {
+	case op.kind == tokenPunct && (op.text == "==" || op.text == "!=" || op.text == "<" || op.text == "<=" || op.text == ">" || op.text == ">="):
+	case op.kind == tokenPunct && op.text == "=~":
+	case op.kind == tokenIdent && op.text == "in":
+	default:
+		if left.path == nil {
+			return nil, fmt.Errorf("expected a comparison after %v", left.literal)
+		}
+		return filterTruthy{left}, nil
+	}
+	p.next()
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	node := filterCompare{op: op.text, left: left, right: right}
+	switch op.text {
+	case "=~":
+		pattern, ok := right.literal.(string)
+		if right.path != nil || !ok {
+			return nil, fmt.Errorf("=~ needs a string pattern")
+		}
+		if node.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+	case "in":
+		if _, ok := right.literal.([]interface{}); right.path == nil && !ok {
+			return nil, fmt.Errorf("in needs a list or a path")
+		}
+	}
+	return node, nil
+}
+
+// parseOperand parses a payload path or a literal
+func (p *filterParser) parseOperand() (filterOperand, error) {
+	token := p.next()
+	switch token.kind {
+	case tokenString, tokenNumber:
+		return filterOperand{literal: token.value}, nil
+	case tokenIdent:
+		switch token.text {
+		case "true":
+			return filterOperand{literal: true}, nil
+		case "false":
+			return filterOperand{literal: false}, nil
+		case "null":
+			return filterOperand{literal: nil}, nil
+		}
+		return p.parsePath(token.text)
+	case tokenPunct:
+		if token.text == "[" {
+			return p.parseList()
+		}
+	}
+	return filterOperand{}, fmt.Errorf("unexpected %s", token)
+}
+
+// parsePath parses the rest of a path such as metrics.cpu or activities[*].level
+func (p *filterParser) parsePath(first string) (filterOperand, error) {
+	path := []string{first}
+	for {
+		switch {
+		case p.accept("."):
+			token := p.next()
+			if token.kind != tokenIdent {
+				return filterOperand{}, fmt.Errorf("expected a field name but found %s", token)
+			}
+			path = append(path, token.text)
+		case p.accept("[*]"):
+			path = append(path, "*")
+		default:
+			return filterOperand{path: path}, nil
+		}
+	}
+}
+
+// parseList parses the rest of a list literal such as ["warning", "error"]
+func (p *filterParser) parseList() (filterOperand, error) {
+	items := []interface{}{}
+	if p.accept("]") {
+		return filterOperand{literal: items}, nil
+	}
+	for {
+		token := p.next()
+		switch {
+		case token.kind == tokenString || token.kind == tokenNumber:
+			items = append(items, token.value)
+		case token.kind == tokenIdent && (token.text == "true" || token.text == "false"):
+			items = append(items, token.text == "true")
+		case token.kind == tokenIdent && token.text == "null":
+			items = append(items, nil)
+		default:
+			return filterOperand{}, fmt.Errorf("expected a literal but found %s", token)
+		}
+		if p.accept("]") {
+			return filterOperand{literal: items}, nil
+		}
+		if err := p.expect(","); err != nil {
+			return filterOperand{}, err
+		}
+	}
+}
+
+// filterOperand is a payload path or a literal value
+type filterOperand struct {
+	path    []string // Nil for literals; "*" segments fan out over arrays
+	literal interface{}
+}
+
+// values returns every value the operand refers to in the payload
+func (o filterOperand) values(data interface{}) []interface{} {
+	if o.path == nil {
+		return []interface{}{o.literal}
+	}
+	current := []interface{}{data}
+	for _, segment := range o.path {
+		var next []interface{}
+		for _, value := range current {
+			switch v := value.(type) {
+			case map[string]interface{}:
+				if child, ok := v[segment]; ok && segment != "*" {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if segment == "*" {
+					next = append(next, v...)
+				}
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+type filterOr struct{ left, right filterNode }
+
+func (n filterOr) eval(data interface{}) bool { return n.left.eval(data) || n.right.eval(data) }
+
+type filterAnd struct{ left, right filterNode }
+
+func (n filterAnd) eval(data interface{}) bool { return n.left.eval(data) && n.right.eval(data) }
+
+type filterNot struct{ operand filterNode }
+
+func (n filterNot) eval(data interface{}) bool { return !n.operand.eval(data) }
+
+// filterTruthy holds when any of a path's values is present and not false,
+// null, zero or empty
+type filterTruthy struct{ operand filterOperand }
+
+func (n filterTruthy) eval(data interface{}) bool {
+	for _, value := range n.operand.values(data) {
+		switch v := value.(type) {
+		case nil:
+		case bool:
+			if v {
+				return true
+			}
+		case float64:
+			if v != 0 {
+				return true
+			}
+		case string:
+			if v != "" {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// filterCompare holds when any pair of left and right values satisfies op
+type filterCompare struct {
+	op          string
+	left, right filterOperand
+	pattern     *regexp.Regexp // Compiled right operand of =~
+}
+
+func (n filterCompare) eval(data interface{}) bool {
+	rights := n.right.values(data)
+	for _, left := range n.left.values(data) {
+		for _, right := range rights {
+			if n.compare(left, right) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (n filterCompare) compare(left, right interface{}) bool {
+	switch n.op {
+	case "==":
+		return filterEqual(left, right)
+	case "!=":
+		return !filterEqual(left, right)
+	case "=~":
+		s, ok := left.(string)
+		return ok && n.pattern.MatchString(s)
+	case "in":
+		items, ok := right.([]interface{})
+		if !ok {
+			return filterEqual(left, right)
+		}
+		for _, item := range items {
+			if filterEqual(left, item) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Ordering compares numbers with numbers and strings with strings
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// filterEqual compares scalar JSON values; arrays and objects never compare equal
+func filterEqual(left, right interface{}) bool {
+	switch left.(type) {
+	case nil:
+		return right == nil
+	case bool, float64, string:
+		return left == right
+	}
+	return false
+}
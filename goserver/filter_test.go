@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// filterPayload is the payload the filter tests evaluate against
+const filterPayload = `{
+	"type": "metrics",
+	"metrics": {"cpu": 85, "memory": "4G", "load": 0, "label": ""},
+	"system_status": {"status": "online"},
+	"activities": [
+		{"level": "info", "message": "Deploy started"},
+		{"level": "error", "message": "Disk full", "tags": ["disk", "prod"]}
+	],
+	"enabled": true,
+	"disabled": false,
+	"nothing": null,
+	"empty": [],
+	"dotted.key": 1,
+	"héllo": "wörld"
+}`
+
+func TestFilterMatch(t *testing.T) {
+	var payload interface{}
+	if err := json.Unmarshal([]byte(filterPayload), &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// Comparisons
+		{`type == "metrics"`, true},
+		{`type != "metrics"`, false},
+		{`type == 'metrics'`, true},
+		{`metrics.cpu > 80`, true},
+		{`metrics.cpu >= 85`, true},
+		{`metrics.cpu < 85`, false},
+		{`metrics.cpu <= 85.0`, true},
+		{`metrics.cpu == -85`, false},
+		{`metrics.memory < "5G"`, true},
+		{`type in ["alerts", "metrics"]`, true},
+		{`type in []`, false},
+		{`activities[*].level in ["warning", "error"]`, true},
+		{`activities[*].level == "debug"`, false},
+		{`activities[*].tags[*] == "prod"`, true},
+		{`activities[*].message =~ "(?i)deploy"`, true},
+		{`activities[*].message =~ "^Deploy$"`, false},
+		{`nothing == null`, true},
+		{`enabled == true`, true},
+		{`héllo == "wörld"`, true},
+		{`type == "with \"quotes\""`, false},
+
+		// Truthiness of bare paths
+		{`enabled`, true},
+		{`disabled`, false},
+		{`nothing`, false},
+		{`metrics.load`, false},
+		{`metrics.label`, false},
+		{`metrics`, true},
+		{`empty`, true},
+		{`missing`, false},
+		{`missing.deeper[*].path`, false},
+
+		// Precedence: ! binds tightest, then &&, then ||
+		{`type == "x" || type == "metrics" && enabled`, true},
+		{`type == "metrics" || type == "x" && disabled`, true},
+		{`(type == "metrics" || type == "x") && disabled`, false},
+		{`!disabled && enabled`, true},
+		{`!(disabled || enabled)`, false},
+		{`!!enabled`, true},
+		{`metrics.cpu > 80 && !(system_status.status == "online")`, false},
+
+		// Type mismatches never match, in either direction
+		{`metrics.cpu > "80"`, false},
+		{`metrics.cpu < "80"`, false},
+		{`metrics.memory > 1`, false},
+		{`metrics.cpu == "85"`, false},
+		{`metrics.cpu =~ "85"`, false},
+		{`metrics == metrics`, false},
+		{`activities == activities`, false},
+		{`enabled > false`, false},
+		{`nothing < 1`, false},
+		{`metrics.cpu != "85"`, true},
+		{`metrics.cpu in type`, false},
+		{`missing == null`, false},
+		{`missing != 1`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			filter, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+			if got := filter.Match(payload); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{`type ==`, "unexpected end of filter"},
+		{`== "metrics"`, `unexpected "=="`},
+		{`type == "metrics`, "unterminated string"},
+		{`type == "metrics\`, "unterminated string"},
+		{`type = "metrics"`, `unexpected '='`},
+		{`type == "a" &&`, "unexpected end of filter"},
+		{`type == "a" || || enabled`, `unexpected "||"`},
+		{`(type == "a"`, `expected ")"`},
+		{`type == "a")`, `unexpected ")"`},
+		{`()`, `unexpected ")"`},
+		{`type in "a"`, "in needs a list or a path"},
+		{`type in [path]`, "expected a literal"},
+		{`type in ["a" "b"]`, `expected ","`},
+		{`type in ["a",`, "expected a literal"},
+		{`type =~ 1`, "=~ needs a string pattern"},
+		{`type =~ other`, "=~ needs a string pattern"},
+		{`type =~ "("`, "missing closing )"},
+		{`metrics. == 1`, "expected a field name"},
+		{`metrics.[*]`, "expected a field name"},
+		{`1.2.3 == x`, "invalid number"},
+		{`- == x`, "invalid number"},
+		{`"literal"`, "expected a comparison"},
+		{`42`, "expected a comparison"},
+		{`type == 1 2`, `unexpected "2"`},
+		{`type @ 1`, `unexpected '@'`},
+		{"type == \x00", `unexpected '\x00'`},
+		{"\xff\xfe", "unexpected"},
+		{`activities[0]`, `unexpected "["`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			filter, err := ParseFilter(tt.expr)
+			if err == nil {
+				t.Fatalf("ParseFilter accepted %q as %v", tt.expr, filter)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseFilter error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseFilterEmpty(t *testing.T) {
+	for _, expr := range []string{"", "   ", "\n\t"} {
+		filter, err := ParseFilter(expr)
+		if filter != nil || err != nil {
+			t.Errorf("ParseFilter(%q) = %v, %v, want no filter", expr, filter, err)
+		}
+	}
+	var none *Filter
+	if !none.Allows(&Event{Data: map[string]interface{}{}}) {
+		t.Error("nil filter blocked an event")
+	}
+}
+
+func TestParseFilterLength(t *testing.T) {
+	base := `type == "`
+	atLimit := base + strings.Repeat("x", maxFilterLength-len(base)-1) + `"`
+	if _, err := ParseFilter(atLimit); err != nil {
+		t.Errorf("filter of %d characters refused: %v", len(atLimit), err)
+	}
+	overLimit := base + strings.Repeat("x", maxFilterLength-len(base)) + `"`
+	if _, err := ParseFilter(overLimit); err == nil || !strings.Contains(err.Error(), "longer than") {
+		t.Errorf("filter of %d characters: error = %v, want too long", len(overLimit), err)
+	}
+}
+
+func TestParseFilterDepth(t *testing.T) {
+	nested := func(open, close string, depth int) string {
+		return strings.Repeat(open, depth) + "enabled" + strings.Repeat(close, depth)
+	}
+	tests := []struct {
+		name string
+		expr string
+		ok   bool
+	}{
+		{"parentheses at limit", nested("(", ")", maxFilterDepth-1), true},
+		{"parentheses over limit", nested("(", ")", maxFilterDepth), false},
+		{"negations at limit", nested("!", "", maxFilterDepth-1), true},
+		{"negations over limit", nested("!", "", maxFilterDepth), false},
+		{"mixed over limit", nested("!(", ")", maxFilterDepth), false},
+		{"unbalanced", strings.Repeat("(", maxFilterLength), false},
+		{"long flat chain", strings.TrimSuffix(strings.Repeat("enabled && ", 90), " && "), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFilter(tt.expr)
+			if tt.ok && err != nil {
+				t.Errorf("ParseFilter: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("ParseFilter accepted an over-nested filter")
+			}
+		})
+	}
+}
+
+func TestFilterAllowsSystemEvents(t *testing.T) {
+	filter, err := ParseFilter(`type == "metrics"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Allows(&Event{System: true, Data: map[string]interface{}{"type": "system_status"}}) {
+		t.Error("system event filtered out")
+	}
+	if filter.Allows(&Event{Data: map[string]interface{}{"type": "alerts"}}) {
+		t.Error("non-matching event allowed")
+	}
+	for _, data := range []interface{}{nil, "text", 1.0, []interface{}{1.0}} {
+		if filter.Allows(&Event{Data: data}) {
+			t.Errorf("non-object payload %v allowed", data)
+		}
+	}
+}
+
+func FuzzParseFilter(f *testing.F) {
+	for _, seed := range []string{
+		`type == "metrics"`,
+		`activities[*].level in ["warning", "error"] && !(system_status.status == "online")`,
+		`activities[*].message =~ "(?i)deploy"`,
+		`a.b.c >= -1.5 || !d`,
+		`x in [1, "a", true, null]`,
+		`(((a)))`,
+		`"\`,
+	} {
+		f.Add(seed)
+	}
+	var payload interface{}
+	if err := json.Unmarshal([]byte(filterPayload), &payload); err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, expr string) {
+		filter, err := ParseFilter(expr)
+		if err != nil || filter == nil {
+			return
+		}
+		filter.Match(payload)
+		filter.Match(nil)
+	})
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// echoTTL is how long a locally published message waits for its copy to
+// come back from the broker
+const echoTTL = 10 * time.Second
+
+// HubConfig tunes event replay, typing and snapshots
+type HubConfig struct {
+	ReplaySize      int           // Events kept per channel for reconnecting SSE clients
+	TypeField       string        // Payload field naming the event type; empty disables event types
+	SnapshotByType  bool          // Keep the latest payload of each type rather than of each channel
+	SnapshotPersist bool          // Persist snapshots when the broker supports it
+	SnapshotTTL     time.Duration // Expiry of persisted snapshots; zero keeps them forever
+}
+
+// Hub owns the single server-wide broker subscription and fans every message
+// out to the registered SSE and WebSocket connections. Channels are
+// reference counted so the broker is only subscribed to streams somebody is
+// actually listening on.
+type Hub struct {
+	server *Server
+	ctx    context.Context
+	cancel context.CancelFunc
+	sub    Subscription
+	refs   map[string]int
+	resume map[string]string // Channel -> last broker message ID read by a replaced subscription
+	lost   chan struct{}     // Signalled when the subscription ends unexpectedly or misses a channel
+	mu     sync.Mutex
+
+	subscribed map[string]bool // Channels the current subscription covers
+	subMu      sync.Mutex      // Orders broker subscription calls, which run without mu
+
+	ids        *EventIDGenerator
+	typeField  string
+	replay     map[string]*ReplayBuffer
+	replaySize int
+	replayMu   sync.Mutex
+
+	snapshots      map[string]map[string]*Event // Channel -> snapshot key -> latest event
+	snapshotLoaded map[string]chan struct{}     // Closed once the channel is seeded from the store
+	snapshotByType bool
+	snapshotStore  SnapshotStore
+	snapshotTTL    time.Duration
+	snapshotMu     sync.Mutex
+
+	persistQueue map[string]*Event // Channel + snapshot key -> latest event waiting to be persisted
+	persistWake  chan struct{}
+	persistDone  chan struct{} // Closed once the snapshot writer has flushed and stopped
+	persistMu    sync.Mutex
+
+	deliverMu sync.Mutex             // Keeps broker and locally published events in ID order
+	echoes    map[string][]time.Time // Channel + payload hash -> expiry of each expected broker copy
+	echoMu    sync.Mutex
+
+	intercepts  map[string]func(data interface{}) // Channel -> handler consuming its messages instead of clients
+	interceptMu sync.Mutex
+}
+
+// NewHub creates a hub for the given server
+func NewHub(server *Server, config HubConfig) *Hub {
+	ctx, cancel := context.WithCancel(context.Background())
+	hub := &Hub{
+		server:         server,
+		ctx:            ctx,
+		cancel:         cancel,
+		refs:           make(map[string]int),
+		resume:         make(map[string]string),
+		subscribed:     make(map[string]bool),
+		lost:           make(chan struct{}, 1),
+		ids:            NewEventIDGenerator(),
+		typeField:      config.TypeField,
+		replay:         make(map[string]*ReplayBuffer),
+		replaySize:     config.ReplaySize,
+		snapshots:      make(map[string]map[string]*Event),
+		snapshotLoaded: make(map[string]chan struct{}),
+		snapshotByType: config.SnapshotByType,
+		snapshotTTL:    config.SnapshotTTL,
+		echoes:         make(map[string][]time.Time),
+		intercepts:     make(map[string]func(data interface{})),
+	}
+	if store, ok := server.broker.(SnapshotStore); ok && config.SnapshotPersist {
+		hub.snapshotStore = store
+		hub.persistQueue = make(map[string]*Event)
+		hub.persistWake = make(chan struct{}, 1)
+		hub.persistDone = make(chan struct{})
+		go hub.runSnapshotWriter()
+	}
+	return hub
+}
+
+// replayBuffer returns the replay buffer for a channel, creating it on first use
+func (h *Hub) replayBuffer(channel string) *ReplayBuffer {
+	h.replayMu.Lock()
+	defer h.replayMu.Unlock()
+
+	buffer, ok := h.replay[channel]
+	if !ok {
+		buffer = NewReplayBuffer(h.replaySize)
+		h.replay[channel] = buffer
+	}
+	return buffer
+}
+
+// Replay returns the buffered events published after lastID on channels,
+// and the channels whose gap cannot be filled from the buffers, which need
+// their snapshot instead. When lastID is not buffered on any of the
+// channels, it was not issued here, or long ago, and every channel is stale.
+func (h *Hub) Replay(channels map[string]bool, lastID uint64) (missed []*Event, stale []string) {
+	known := false
+	replayed := make(map[string][]*Event, len(channels))
+	for channel := range channels {
+		events, found, complete := h.replayBuffer(channel).Since(lastID)
+		known = known || found
+		if !complete {
+			stale = append(stale, channel)
+			continue
+		}
+		replayed[channel] = events
+	}
+	if !known {
+		stale = stale[:0]
+		for channel := range channels {
+			stale = append(stale, channel)
+		}
+		return nil, stale
+	}
+	for _, events := range replayed {
+		missed = append(missed, events...)
+	}
+	return missed, stale
+}
+
+// Acquire registers interest in a channel, subscribing the broker on first use
+func (h *Hub) Acquire(channel string) {
+	h.mu.Lock()
+	h.refs[channel]++
+	first := h.refs[channel] == 1
+	h.mu.Unlock()
+
+	if first {
+		h.syncChannel(channel)
+	}
+}
+
+// Release drops interest in a channel, unsubscribing the broker when the
+// last listener goes away
+func (h *Hub) Release(channel string) {
+	h.mu.Lock()
+	if h.refs[channel] == 0 {
+		h.mu.Unlock()
+		return
+	}
+	h.refs[channel]--
+	last := h.refs[channel] == 0
+	if last {
+		delete(h.refs, channel)
+		delete(h.resume, channel)
+	}
+	h.mu.Unlock()
+
+	if last {
+		h.syncChannel(channel)
+	}
+}
+
+// syncChannel subscribes or unsubscribes the broker so it matches whether the
+// channel has listeners. Broker calls are made without holding h.mu; subMu
+// keeps them in order, so a quick Acquire and Release cannot cross.
+func (h *Hub) syncChannel(channel string) {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+
+	h.mu.Lock()
+	wanted := h.refs[channel] > 0
+	sub := h.sub
+	subscribed := sub != nil && h.subscribed[channel]
+	h.mu.Unlock()
+
+	switch {
+	case wanted && sub == nil:
+		sub, err := h.server.broker.Subscribe(h.ctx, channel)
+		if err != nil {
+			h.subscribeFailed(channel, err)
+			return
+		}
+		h.mu.Lock()
+		h.sub = sub
+		h.subscribed = map[string]bool{channel: true}
+		h.mu.Unlock()
+		go h.run(sub)
+		h.server.logger.Info("🔗 %s subscription started for channel: %s", h.server.broker.Name(), channel)
+
+	case wanted && !subscribed:
+		if err := sub.Subscribe(h.ctx, channel); err != nil {
+			h.subscribeFailed(channel, err)
+			return
+		}
+		h.mu.Lock()
+		if h.sub == sub {
+			h.subscribed[channel] = true
+		}
+		h.mu.Unlock()
+		h.server.logger.Info("🔗 %s subscription added channel: %s", h.server.broker.Name(), channel)
+
+	case !wanted && subscribed:
+		h.mu.Lock()
+		delete(h.subscribed, channel)
+		h.mu.Unlock()
+		if err := sub.Unsubscribe(h.ctx, channel); err != nil {
+			h.server.logger.Error("Error unsubscribing from %s channel %s: %v", h.server.broker.Name(), channel, err)
+			h.server.metrics.BrokerErrors.Inc(h.server.broker.Name(), "unsubscribe")
+			return
+		}
+		h.server.logger.Info("🔌 %s subscription removed channel: %s", h.server.broker.Name(), channel)
+	}
+}
+
+// subscribeFailed records a channel the broker could not subscribe to. Its
+// listeners keep their references, and the supervisor is signalled so
+// Resubscribe picks the channel up once the broker answers.
+func (h *Hub) subscribeFailed(channel string, err error) {
+	h.server.logger.Error("Error subscribing to %s channel %s: %v", h.server.broker.Name(), channel, err)
+	h.server.metrics.BrokerErrors.Inc(h.server.broker.Name(), "subscribe")
+	select {
+	case h.lost <- struct{}{}:
+	default:
+	}
+}
+
+// Resubscribe replaces the broker subscription with a fresh one covering
+// every channel that still has listeners. Brokers that keep messages resume
+// each channel after the last message the old subscription read.
+func (h *Hub) Resubscribe() error {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+
+	h.mu.Lock()
+	old := h.sub
+	if old != nil {
+		h.saveResumePoints(old)
+		h.sub = nil
+		h.subscribed = make(map[string]bool)
+	}
+	channels := make([]string, 0, len(h.refs))
+	for channel := range h.refs {
+		channels = append(channels, channel)
+	}
+	resume := make(map[string]string, len(h.resume))
+	for channel, id := range h.resume {
+		resume[channel] = id
+	}
+	h.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+
+	var sub Subscription
+	var err error
+	if resumable, ok := h.server.broker.(ResumableBroker); ok {
+		sub, err = resumable.SubscribeFrom(h.ctx, resume, channels...)
+	} else {
+		sub, err = h.server.broker.Subscribe(h.ctx, channels...)
+	}
+	if err != nil {
+		h.server.metrics.BrokerErrors.Inc(h.server.broker.Name(), "subscribe")
+		return err
+	}
+
+	h.mu.Lock()
+	h.sub = sub
+	h.subscribed = make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		h.subscribed[channel] = true
+		delete(h.resume, channel)
+	}
+	h.mu.Unlock()
+	go h.run(sub)
+	h.server.logger.Info("🔗 %s subscription re-established for channels: %v", h.server.broker.Name(), channels)
+
+	return nil
+}
+
+// saveResumePoints remembers how far a subscription being replaced read each
+// channel. Points saved from an earlier subscription are kept, as the one
+// being replaced never read those channels. The caller holds h.mu.
+func (h *Hub) saveResumePoints(sub Subscription) {
+	resumable, ok := sub.(ResumableSubscription)
+	if !ok {
+		return
+	}
+	for channel, id := range resumable.LastIDs() {
+		if _, saved := h.resume[channel]; !saved && h.refs[channel] > 0 {
+			h.resume[channel] = id
+		}
+	}
+}
+
+// Listening reports whether this server has listeners on a channel
+func (h *Hub) Listening(channel string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.refs[channel] > 0
+}
+
+// Lost is signalled when the broker subscription ends without being closed,
+// or a channel could not be subscribed
+func (h *Hub) Lost() <-chan struct{} {
+	return h.lost
+}
+
+// Notify fans a server-generated message out on every active channel. Like
+// any event with an ID it is kept for replay, so a client whose last event
+// was a notice can still resume from it, but it is not part of the snapshot.
+func (h *Hub) Notify(data interface{}) {
+	h.mu.Lock()
+	channels := make([]string, 0, len(h.refs))
+	for channel := range h.refs {
+		channels = append(channels, channel)
+	}
+	h.mu.Unlock()
+
+	h.deliverMu.Lock()
+	defer h.deliverMu.Unlock()
+	for _, channel := range channels {
+		event := h.newEvent(channel, data)
+		if event == nil {
+			continue
+		}
+		event.System = true
+		if !isPresenceStream(channel) {
+			h.replayBuffer(channel).Append(event)
+		}
+		h.broadcast(event)
+	}
+}
+
+// Close stops the broker subscription and waits for queued snapshots to be
+// persisted
+func (h *Hub) Close() {
+	h.cancel()
+
+	h.mu.Lock()
+	if h.sub != nil {
+		h.sub.Close()
+		h.sub = nil
+	}
+	h.mu.Unlock()
+
+	if h.persistDone != nil {
+		<-h.persistDone
+	}
+}
+
+// run receives broker messages, decodes each one once and hands it to the
+// broadcast helpers
+func (h *Hub) run(sub Subscription) {
+	for msg := range sub.Messages() {
+		receivedAt := time.Now()
+		h.server.stats.IncrementRedisMessage()
+		h.server.metrics.BrokerMessages.Inc(h.server.broker.Name(), h.server.channelLabel(msg.Channel))
+
+		if h.consumeEcho(msg.Channel, msg.Payload) {
+			h.server.logger.Debug("Skipping broker copy of message published here on %s", msg.Channel)
+			continue
+		}
+
+		var data interface{}
+		if err := json.Unmarshal(msg.Payload, &data); err != nil {
+			h.server.logger.Error("Error parsing broker message: %v", err)
+			h.server.metrics.BrokerErrors.Inc(h.server.broker.Name(), "decode")
+			continue
+		}
+
+		if event := h.deliver(msg.Channel, data, receivedAt); event != nil {
+			h.server.logger.Debug("Broker message %d received on %s: %s", event.ID, msg.Channel, msg.Payload)
+		}
+	}
+	h.server.logger.Info("🛑 Broker receive loop exiting")
+
+	// Report the loss unless the subscription was closed or replaced on purpose
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sub == sub && h.ctx.Err() == nil {
+		h.saveResumePoints(sub)
+		h.sub = nil
+		h.subscribed = make(map[string]bool)
+		h.server.metrics.BrokerErrors.Inc(h.server.broker.Name(), "subscription_lost")
+		select {
+		case h.lost <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Intercept hands every message on a channel to handler instead of clients,
+// keeping the channel subscribed for the life of the server
+func (h *Hub) Intercept(channel string, handler func(data interface{})) {
+	h.interceptMu.Lock()
+	h.intercepts[channel] = handler
+	h.interceptMu.Unlock()
+	h.Acquire(channel)
+}
+
+// deliver records a message for replay and snapshots and fans it out, or
+// hands it to the channel's interceptor
+func (h *Hub) deliver(channel string, data interface{}, receivedAt time.Time) *Event {
+	h.interceptMu.Lock()
+	handler := h.intercepts[channel]
+	h.interceptMu.Unlock()
+	if handler != nil {
+		handler(data)
+		return nil
+	}
+
+	h.deliverMu.Lock()
+	defer h.deliverMu.Unlock()
+
+	event := h.newEvent(channel, data)
+	if event == nil {
+		return nil
+	}
+	event.ReceivedAt = receivedAt
+	if isPresenceStream(channel) {
+		// Join and leave events are not state: nothing to replay or snapshot
+		h.broadcast(event)
+		return event
+	}
+	h.replayBuffer(channel).Append(event)
+	h.updateSnapshot(event)
+	h.broadcast(event)
+	h.persistSnapshot(event)
+	return event
+}
+
+// Publish delivers a message to this server's clients straight away and
+// publishes it to the broker for other instances. The broker's copy is
+// skipped when it comes back, so local clients do not see it twice.
+func (h *Hub) Publish(ctx context.Context, channel string, payload []byte) (*Event, error) {
+	var data interface{}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, err
+	}
+
+	// Only channels with a live subscription get the copy back
+	h.mu.Lock()
+	expectEcho := h.sub != nil && h.refs[channel] > 0
+	h.mu.Unlock()
+	if expectEcho {
+		h.expectEcho(channel, payload)
+	}
+
+	event := h.deliver(channel, data, time.Now())
+	if err := h.server.broker.Publish(ctx, channel, payload); err != nil {
+		if expectEcho {
+			h.consumeEcho(channel, payload)
+		}
+		return event, err
+	}
+	return event, nil
+}
+
+// echoKey identifies a payload published on a channel
+func echoKey(channel string, payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return channel + "\x00" + string(sum[:])
+}
+
+// expectEcho records that the broker will hand back a copy of a payload
+func (h *Hub) expectEcho(channel string, payload []byte) {
+	h.echoMu.Lock()
+	defer h.echoMu.Unlock()
+
+	now := time.Now()
+	for key, expiries := range h.echoes {
+		if expiries[len(expiries)-1].Before(now) {
+			delete(h.echoes, key)
+		}
+	}
+	key := echoKey(channel, payload)
+	h.echoes[key] = append(h.echoes[key], now.Add(echoTTL))
+}
+
+// consumeEcho reports whether a received payload is the copy of one
+// published here, forgetting it if so
+func (h *Hub) consumeEcho(channel string, payload []byte) bool {
+	h.echoMu.Lock()
+	defer h.echoMu.Unlock()
+
+	if len(h.echoes) == 0 {
+		return false
+	}
+	key := echoKey(channel, payload)
+	now := time.Now()
+	for expiries := h.echoes[key]; len(expiries) > 0; expiries = expiries[1:] {
+		if expiries[0].After(now) {
+			if len(expiries) == 1 {
+				delete(h.echoes, key)
+			} else {
+				h.echoes[key] = expiries[1:]
+			}
+			return true
+		}
+	}
+	delete(h.echoes, key)
+	return false
+}
+
+// newEvent assigns the next event ID to decoded data
+func (h *Hub) newEvent(channel string, data interface{}) *Event {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		h.server.logger.Error("Error marshaling SSE data: %v", err)
+		return nil
+	}
+	return &Event{
+		ID:      h.ids.Next(),
+		Channel: channel,
+		Data:    data,
+		JSON:    jsonData,
+		Type:    h.eventType(data),
+	}
+}
+
+// eventType reads the payload's type field. Types that are not safe as an
+// SSE event name are ignored.
+func (h *Hub) eventType(data interface{}) string {
+	object, ok := data.(map[string]interface{})
+	if h.typeField == "" || !ok {
+		return ""
+	}
+	eventType, _ := lookupPath(object, h.typeField).(string)
+	if !sseEventNamePattern.MatchString(eventType) {
+		return ""
+	}
+	return eventType
+}
+
+// broadcast hands an event to the SSE and WebSocket broadcast helpers
+func (h *Hub) broadcast(event *Event) {
+	h.server.broadcastToSSE(event)
+	h.server.broadcastToWebSocket(event)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation is a single RFC 6902 JSON Patch operation
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON leaves out the value of remove operations only, since add and
+// replace must carry one even when it is null
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	if op.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+	type operation PatchOperation
+	return json.Marshal(operation(op))
+}
+
+// jsonPointerEscaper escapes a key for use in a JSON Pointer (RFC 6901)
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// DiffJSON returns the operations turning from into to. Both must be
+// decoded JSON (maps, slices and scalars). Arrays skip their common prefix
+// and suffix, so inserting or removing near either end only touches those
+// elements, and the rest is compared element by element. An object or array
+// whose patch would be larger than its new value is replaced whole.
+func DiffJSON(from, to interface{}) []PatchOperation {
+	ops, _, _ := diffJSON(nil, "", from, to)
+	return ops
+}
+
+// diffJSON appends the operations turning from into to at path. It also
+// returns the encoded size of those operations and of to, which the caller
+// weighs against replacing its own value whole. Objects are compared by
+// walking them rather than up front, so however deep the document is, no
+// subtree is compared or encoded more than once.
+func diffJSON(ops []PatchOperation, path string, from, to interface{}) ([]PatchOperation, int, int) {
+	start := len(ops)
+	patchSize := 0
+
+	switch toValue := to.(type) {
+	case map[string]interface{}:
+		fromValue, ok := from.(map[string]interface{})
+		if !ok {
+			break
+		}
+		// Sorted keys keep patches deterministic
+		keys := make([]string, 0, len(fromValue)+len(toValue))
+		for key := range fromValue {
+			keys = append(keys, key)
+		}
+		for key := range toValue {
+			if _, ok := fromValue[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		valueSize := delimiterSize(len(toValue))
+		for _, key := range keys {
+			keyPath := path + "/" + jsonPointerEscaper.Replace(key)
+			oldValue, inFrom := fromValue[key]
+			newValue, inTo := toValue[key]
+			childPatch, childSize := 0, 0
+			switch {
+			case !inTo:
+				ops = append(ops, PatchOperation{Op: "remove", Path: keyPath})
+				patchSize += patchOpSize("remove", keyPath, 0)
+				continue
+			case !inFrom:
+				childSize = jsonSize(newValue)
+				ops = append(ops, PatchOperation{Op: "add", Path: keyPath, Value: newValue})
+				childPatch = patchOpSize("add", keyPath, childSize)
+			default:
+				ops, childPatch, childSize = diffJSON(ops, keyPath, oldValue, newValue)
+			}
+			patchSize += childPatch
+			valueSize += jsonSize(key) + 1 + childSize
+		}
+		return replaceIfSmaller(ops, start, path, to, patchSize, valueSize)
+
+	case []interface{}:
+		fromValue, ok := from.([]interface{})
+		if !ok {
+			break
+		}
+		prefix := 0
+		for prefix < len(fromValue) && prefix < len(toValue) && reflect.DeepEqual(fromValue[prefix], toValue[prefix]) {
+			prefix++
+		}
+		suffix := 0
+		for suffix < len(fromValue)-prefix && suffix < len(toValue)-prefix &&
+			reflect.DeepEqual(fromValue[len(fromValue)-1-suffix], toValue[len(toValue)-1-suffix]) {
+			suffix++
+		}
+		oldItems := fromValue[prefix : len(fromValue)-suffix]
+		newItems := toValue[prefix : len(toValue)-suffix]
+
+		valueSize := delimiterSize(len(toValue))
+		for _, item := range toValue[:prefix] {
+			valueSize += jsonSize(item)
+		}
+		for _, item := range toValue[len(toValue)-suffix:] {
+			valueSize += jsonSize(item)
+		}
+
+		common := len(oldItems)
+		if len(newItems) < common {
+			common = len(newItems)
+		}
+		for i := 0; i < common; i++ {
+			var childPatch, childSize int
+			ops, childPatch, childSize = diffJSON(ops, path+"/"+strconv.Itoa(prefix+i), oldItems[i], newItems[i])
+			patchSize += childPatch
+			valueSize += childSize
+		}
+		// Remove from the end so earlier indexes stay valid
+		for i := len(oldItems) - 1; i >= common; i-- {
+			itemPath := path + "/" + strconv.Itoa(prefix+i)
+			ops = append(ops, PatchOperation{Op: "remove", Path: itemPath})
+			patchSize += patchOpSize("remove", itemPath, 0)
+		}
+		for i := common; i < len(newItems); i++ {
+			itemPath := path + "/" + strconv.Itoa(prefix+i)
+			itemSize := jsonSize(newItems[i])
+			ops = append(ops, PatchOperation{Op: "add", Path: itemPath, Value: newItems[i]})
+			patchSize += patchOpSize("add", itemPath, itemSize)
+			valueSize += itemSize
+		}
+		return replaceIfSmaller(ops, start, path, to, patchSize, valueSize)
+	}
+
+	// Scalars, and values whose type changed
+	valueSize := jsonSize(to)
+	if reflect.DeepEqual(from, to) {
+		return ops, 0, valueSize
+	}
+	return append(ops, PatchOperation{Op: "replace", Path: path, Value: to}), patchOpSize("replace", path, valueSize), valueSize
+}
+
+// replaceIfSmaller swaps the operations appended since start, patchSize
+// bytes in all, for a single replace of the value at path when that encodes
+// shorter
+func replaceIfSmaller(ops []PatchOperation, start int, path string, to interface{}, patchSize, valueSize int) ([]PatchOperation, int, int) {
+	replaceSize := patchOpSize("replace", path, valueSize)
+	if replaceSize >= patchSize {
+		return ops, patchSize, valueSize
+	}
+	return append(ops[:start], PatchOperation{Op: "replace", Path: path, Value: to}), replaceSize, valueSize
+}
+
+// patchOpSize is the encoded size of an operation whose value encodes to
+// valueSize bytes, plus the comma separating it from the next one
+func patchOpSize(op, path string, valueSize int) int {
+	size := len(`{"op":"","path":},`) + len(op) + jsonSize(path)
+	if op != "remove" {
+		size += len(`,"value":`) + valueSize
+	}
+	return size
+}
+
+// jsonSize returns the length of a decoded JSON value's encoding, walking
+// objects and arrays so only their scalars are encoded
+func jsonSize(value interface{}) int {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		size := delimiterSize(len(value))
+		for key, item := range value {
+			size += jsonSize(key) + 1 + jsonSize(item)
+		}
+		return size
+	case []interface{}:
+		size := delimiterSize(len(value))
+		for _, item := range value {
+			size += jsonSize(item)
+		}
+		return size
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// delimiterSize is the length of the brackets and commas of an object or
+// array with n members
+func delimiterSize(n int) int {
+	if n == 0 {
+		return 2
+	}
+	return n + 1
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// applyPatch applies add, remove and replace operations the way an RFC 6902
+// client would, after a JSON round trip of the patch
+func applyPatch(t *testing.T, doc interface{}, ops []PatchOperation) interface{} {
+	t.Helper()
+	data, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	doc = cloneJSON(t, doc)
+	for _, op := range decoded {
+		path, _ := op["path"].(string)
+		value, hasValue := op["value"]
+		if op["op"] != "remove" && !hasValue {
+			t.Fatalf("%v at %q has no value", op["op"], path)
+		}
+		var err error
+		doc, err = applyOperation(doc, op["op"].(string), splitPointer(path), value)
+		if err != nil {
+			t.Fatalf("applying %v %q: %v", op["op"], path, err)
+		}
+	}
+	return doc
+}
+
+// splitPointer splits and unescapes a JSON Pointer
+func splitPointer(path string) []string {
+	if path == "" {
+		return nil
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens
+}
+
+// applyOperation applies one operation below doc, returning the new doc
+func applyOperation(doc interface{}, op string, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		if op == "remove" {
+			return nil, fmt.Errorf("cannot remove the root")
+		}
+		return value, nil
+	}
+	token, rest := tokens[0], tokens[1:]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if len(rest) > 0 {
+			child, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("missing key %q", token)
+			}
+			updated, err := applyOperation(child, op, rest, value)
+			node[token] = updated
+			return node, err
+		}
+		_, exists := node[token]
+		switch {
+		case op == "remove" || op == "replace":
+			if !exists {
+				return nil, fmt.Errorf("missing key %q", token)
+			}
+		}
+		if op == "remove" {
+			delete(node, token)
+		} else {
+			node[token] = value
+		}
+		return node, nil
+	case []interface{}:
+		index, err := strconv.Atoi(token)
+		if err != nil || index < 0 || index > len(node) || (index == len(node) && (op != "add" || len(rest) > 0)) {
+			return nil, fmt.Errorf("bad index %q for length %d", token, len(node))
+		}
+		if len(rest) > 0 {
+			updated, err := applyOperation(node[index], op, rest, value)
+			node[index] = updated
+			return node, err
+		}
+		switch op {
+		case "add":
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+		case "remove":
+			node = append(node[:index], node[index+1:]...)
+		case "replace":
+			node[index] = value
+		}
+		return node, nil
+	}
+	return nil, fmt.Errorf("cannot descend into %T", doc)
+}
+
+// cloneJSON decodes a JSON document fresh, so patching never touches the input
+func cloneJSON(t *testing.T, doc interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clone interface{}
+	if err := json.Unmarshal(data, &clone); err != nil {
+		t.Fatal(err)
+	}
+	return clone
+}
+
+// decodeJSON decodes a test document
+func decodeJSON(t *testing.T, text string) interface{} {
+	t.Helper()
+	var doc interface{}
+	if err := json.Unmarshal([]byte(text), &doc); err != nil {
+		t.Fatalf("decoding %s: %v", text, err)
+	}
+	return doc
+}
+
+func TestDiffJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+	}{
+		{"identical", `{"a":1}`, `{"a":1}`},
+		{"scalar change", `{"a":1,"b":"x"}`, `{"a":2,"b":"x"}`},
+		{"nested objects", `{"m":{"cpu":{"load":1,"cores":8},"mem":"1G"}}`, `{"m":{"cpu":{"load":3,"cores":8},"disk":"5G"}}`},
+		{"key added", `{"a":1}`, `{"a":1,"b":{"c":[1,2]}}`},
+		{"key removed", `{"a":1,"b":2}`, `{"a":1}`},
+		{"null replaces value", `{"a":1,"b":2}`, `{"a":null,"b":2}`},
+		{"null key added", `{"a":1}`, `{"a":1,"b":null}`},
+		{"null key removed", `{"a":1,"b":null}`, `{"a":1}`},
+		{"value replaces null", `{"a":null}`, `{"a":{"x":1}}`},
+		{"array grows at end", `{"l":[1,2,3]}`, `{"l":[1,2,3,4,5]}`},
+		{"array shrinks at end", `{"l":[1,2,3,4,5]}`, `{"l":[1,2]}`},
+		{"array grows at front", `{"l":[{"id":1},{"id":2},{"id":3}]}`, `{"l":[{"id":0},{"id":1},{"id":2},{"id":3}]}`},
+		{"array shrinks at front", `{"l":[{"id":0},{"id":1},{"id":2},{"id":3}]}`, `{"l":[{"id":2},{"id":3}]}`},
+		{"array insert in the middle", `{"l":["a","b","d","e"]}`, `{"l":["a","b","c","d","e"]}`},
+		{"array item changed", `{"l":[{"id":1,"v":"a"},{"id":2,"v":"b"}]}`, `{"l":[{"id":1,"v":"a"},{"id":2,"v":"c"}]}`},
+		{"array emptied", `{"l":[1,2,3]}`, `{"l":[]}`},
+		{"array filled", `{"l":[]}`, `{"l":[1,2,3]}`},
+		{"repeated items", `{"l":[1,1,1]}`, `{"l":[1,1,1,1]}`},
+		{"array of arrays", `{"l":[[1,2],[3,4]]}`, `{"l":[[1,2,5],[3]]}`},
+		{"type change", `{"a":{"b":1}}`, `{"a":[1]}`},
+		{"root array", `[1,2,3]`, `[0,1,2,3]`},
+		{"root type change", `{"a":1}`, `"text"`},
+		{"escaped keys", `{"a/b":1,"c~d":{"e":1}}`, `{"a/b":2,"c~d":{"e":2}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := decodeJSON(t, tt.from), decodeJSON(t, tt.to)
+			ops := DiffJSON(from, to)
+			if got := applyPatch(t, from, ops); !reflect.DeepEqual(got, to) {
+				t.Fatalf("patch %+v turned %s into %v, want %s", ops, tt.from, got, tt.to)
+			}
+			if !reflect.DeepEqual(from, decodeJSON(t, tt.from)) {
+				t.Error("DiffJSON modified its input")
+			}
+		})
+	}
+}
+
+func TestDiffJSONArrayEnds(t *testing.T) {
+	items := make([]interface{}, 50)
+	for i := range items {
+		items[i] = map[string]interface{}{"id": float64(i), "name": "item " + strconv.Itoa(i)}
+	}
+	prepended := append([]interface{}{map[string]interface{}{"id": float64(-1), "name": "new"}}, items...)
+
+	tests := []struct {
+		name     string
+		from, to []interface{}
+		want     []PatchOperation
+	}{
+		{"insert at front", items, prepended, []PatchOperation{{Op: "add", Path: "/0", Value: prepended[0]}}},
+		{"remove from front", prepended, items, []PatchOperation{{Op: "remove", Path: "/0"}}},
+		{"append", items, append(items[:50:50], "tail"), []PatchOperation{{Op: "add", Path: "/50", Value: "tail"}}},
+		{"remove from end", items, items[:49], []PatchOperation{{Op: "remove", Path: "/49"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffJSON(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffJSON = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffJSONReplacesWhenSmaller(t *testing.T) {
+	from := decodeJSON(t, `{"stats":{"a":1,"b":2,"c":3,"d":4,"e":5},"keep":{"x":"long unchanged value"}}`)
+	to := decodeJSON(t, `{"stats":{"f":6},"keep":{"x":"long unchanged value"}}`)
+	want := []PatchOperation{{Op: "replace", Path: "/stats", Value: map[string]interface{}{"f": float64(6)}}}
+	if got := DiffJSON(from, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffJSON = %+v, want %+v", got, want)
+	}
+
+	from = decodeJSON(t, `{"l":[1,2,3,4,5,6,7,8]}`)
+	to = decodeJSON(t, `{"l":[8,7,6,5,4,3,2,1]}`)
+	want = []PatchOperation{{Op: "replace", Path: "/l", Value: to.(map[string]interface{})["l"]}}
+	if got := DiffJSON(from, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffJSON = %+v, want %+v", got, want)
+	}
+}
+
+func TestDiffJSONSizes(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+	}{
+		{"identical", `{"a":1}`, `{"a":1}`},
+		{"nested change", `{"m":{"cpu":{"load":1,"cores":8},"mem":"1G"}}`, `{"m":{"cpu":{"load":3,"cores":8},"disk":"5G"}}`},
+		{"array ends", `{"l":[1,{"a":[]},3,4]}`, `{"l":[0,1,{"a":[]},3]}`},
+		{"replaced whole", `{"stats":{"a":1,"b":2,"c":3}}`, `{"stats":{"f":6}}`},
+		{"empty containers", `{"a":{"x":1},"b":[1]}`, `{"a":{},"b":[],"c":{}}`},
+		{"escaping", `{"k":"plain"}`, `{"k":"<tag> & \"quoted\" \u2028 é","a/b~c":1.5e-7,"n":null,"t":true}`},
+		{"root scalar", `1`, `"two"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to := decodeJSON(t, tt.to)
+			ops, patchSize, valueSize := diffJSON(nil, "", decodeJSON(t, tt.from), to)
+
+			// Each operation is counted with the comma after it
+			encoded, err := json.Marshal(ops)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := len(encoded) - 1; len(ops) > 0 && patchSize != want || len(ops) == 0 && patchSize != 0 {
+				t.Errorf("patch size = %d for %s", patchSize, encoded)
+			}
+			value, err := json.Marshal(to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valueSize != len(value) || jsonSize(to) != len(value) {
+				t.Errorf("value size = %d, jsonSize = %d, want %d for %s", valueSize, jsonSize(to), len(value), value)
+			}
+		})
+	}
+}
+
+func TestDiffJSONDeepDocument(t *testing.T) {
+	// Each level is compared and encoded once, and a deep change is one op
+	var from, to interface{} = 1.0, 2.0
+	for i := 0; i < 1000; i++ {
+		from = map[string]interface{}{"child": from, "name": "level " + strconv.Itoa(i)}
+		to = map[string]interface{}{"child": to, "name": "level " + strconv.Itoa(i)}
+	}
+	ops := DiffJSON(from, to)
+	if len(ops) != 1 || ops[0].Op != "replace" || ops[0].Value != 2.0 || !strings.HasSuffix(ops[0].Path, "/child/child") {
+		t.Errorf("DiffJSON of a deep change = %d ops, first %s %.40s", len(ops), ops[0].Op, ops[0].Path)
+	}
+}
+
+func TestPatchOperationJSON(t *testing.T) {
+	tests := []struct {
+		op   PatchOperation
+		want string
+	}{
+		{PatchOperation{Op: "remove", Path: "/a"}, `{"op":"remove","path":"/a"}`},
+		{PatchOperation{Op: "add", Path: "/a", Value: nil}, `{"op":"add","path":"/a","value":null}`},
+		{PatchOperation{Op: "replace", Path: "/a", Value: nil}, `{"op":"replace","path":"/a","value":null}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Marshal(%+v) = %s, want %s", tt.op, got, tt.want)
+		}
+	}
+}
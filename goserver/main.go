@@ -3,18 +3,22 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/redis/go-redis/v9"
 )
 
 // LogLevel represents the logging level
@@ -111,6 +115,7 @@ type DashboardData struct {
 type ActionCableMessage struct {
 	Command    string      `json:"command,omitempty"`
 	Identifier string      `json:"identifier,omitempty"`
+	Data       string      `json:"data,omitempty"`
 	Message    interface{} `json:"message,omitempty"`
 	Type       string      `json:"type,omitempty"`
 }
@@ -119,20 +124,51 @@ type ActionCableMessage struct {
 type WebSocketConnection struct {
 	ID            string
 	Conn          *websocket.Conn
-	Subscriptions map[string]bool
+	Identity      *Identity
+	RemoteAddr    string
+	UserAgent     string
+	ConnectedAt   time.Time
+	Sent          TrafficCounters
+	Subscriptions map[string]*ChannelSubscription // Keyed by identifier
+	Queue         *SendQueue                      // Outbound frames, drained only by the writer goroutine
+	Done          chan struct{}                   // Closed by Disconnect
+	closeMessage  DisconnectMessage
+	closeOnce     sync.Once
 	mu            sync.RWMutex // Protects Subscriptions map from concurrent access
 }
 
 // Server represents the combined SSE and WebSocket server
 type Server struct {
-	sseConnections map[string]*SSEConnection
-	wsConnections  map[string]*WebSocketConnection
-	sseMutex       sync.RWMutex
-	wsMutex        sync.RWMutex
-	redisClient    *redis.Client
-	upgrader       websocket.Upgrader
-	logger         *Logger
-	stats          *ServerStats
+	sseConnections  map[string]*SSEConnection
+	wsConnections   map[string]*WebSocketConnection
+	sseMutex        sync.RWMutex
+	wsMutex         sync.RWMutex
+	broker          Broker
+	hub             *Hub
+	supervisor      *BrokerSupervisor
+	channels        *ChannelRegistry
+	actions         *ActionRouter
+	presence        *Presence
+	sampler         *StatsSampler
+	auth            *AuthChain
+	origins         *OriginPolicy
+	wsQueueSize     int
+	wsPolicy        SlowConsumerPolicy
+	wsWriteTimeout  time.Duration
+	sseQueueSize    int
+	ssePolicy       SlowConsumerPolicy
+	sseWriteTimeout time.Duration
+	deltaFullEvery  int
+	maxUpdateRate   float64
+	publishTokens   []string
+	publishMaxBytes int64
+	adminTokens     []string
+	upgrader        websocket.Upgrader
+	logger          *Logger
+	stats           *ServerStats
+	metrics         *Metrics
+	draining        atomic.Bool
+	connectionSeq   atomic.Uint64 // Keeps connection IDs unique within the process
 }
 
 // ServerStats represents server statistics
@@ -144,6 +180,10 @@ type ServerStats struct {
 	TotalSSEMessages            int64
 	TotalWebSocketMessages      int64
 	TotalRedisMessages          int64
+	DroppedSSEMessages          int64
+	EvictedSSEConnections       int64
+	ConflatedSSEMessages        int64
+	ConflatedWebSocketMessages  int64
 	StartTime                   time.Time
 	mu                          sync.RWMutex
 }
@@ -210,6 +250,48 @@ func (s *ServerStats) IncrementRedisMessage() {
 	s.TotalRedisMessages++
 }
 
+// AddSSEDropped records SSE messages discarded because a client fell behind
+func (s *ServerStats) AddSSEDropped(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DroppedSSEMessages += int64(n)
+}
+
+// IncrementSSEEvicted increments the count of SSE clients evicted for falling behind
+func (s *ServerStats) IncrementSSEEvicted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.EvictedSSEConnections++
+}
+
+// IncrementSSEConflated counts an SSE update replaced by a newer one before it was sent
+func (s *ServerStats) IncrementSSEConflated() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ConflatedSSEMessages++
+}
+
+// IncrementWebSocketConflated counts a WebSocket update replaced by a newer one before it was sent
+func (s *ServerStats) IncrementWebSocketConflated() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ConflatedWebSocketMessages++
+}
+
+// GetConflatedStats returns how many SSE and WebSocket updates were conflated by throttling
+func (s *ServerStats) GetConflatedStats() (sse, ws int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ConflatedSSEMessages, s.ConflatedWebSocketMessages
+}
+
+// GetSSEBackpressureStats returns dropped SSE messages and evicted SSE clients
+func (s *ServerStats) GetSSEBackpressureStats() (dropped, evicted int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.DroppedSSEMessages, s.EvictedSSEConnections
+}
+
 // GetStats returns a copy of current statistics
 func (s *ServerStats) GetStats() (totalSSE, currentSSE, totalWS, currentWS, sseMsgs, wsMsgs, redisMsgs int64, uptime time.Duration) {
 	s.mu.RLock()
@@ -219,60 +301,177 @@ func (s *ServerStats) GetStats() (totalSSE, currentSSE, totalWS, currentWS, sseM
 
 // SSEConnection represents a single SSE connection
 type SSEConnection struct {
-	ID      string
-	Writer  http.ResponseWriter
-	Flusher http.Flusher
-	Done    chan bool
+	ID            string
+	Writer        http.ResponseWriter
+	Flusher       http.Flusher
+	Identity      *Identity
+	RemoteAddr    string
+	UserAgent     string
+	ConnectedAt   time.Time
+	Sent          TrafficCounters
+	Subscriptions map[string]bool // Streams this connection receives, fixed at creation
+	NamedEvents   bool            // Tag frames with an event: line naming their channel
+	Delta         *DeltaEncoder   // Set for ?encoding=json-patch clients
+	Throttle      *Throttle       // Set when updates are rate limited
+	Filter        *Filter         // Set for ?filter= clients
+	Queue         *SendQueue      // Outbound events queued by the hub
+	Done          chan struct{}   // Closed by Evict
+	evictOnce     sync.Once
+
+	drain      chan struct{} // Closed by Drain
+	drainRetry time.Duration // Reconnect delay hinted to the client when draining
+	drainOnce  sync.Once
+
+	disconnect     chan struct{} // Closed by Disconnect
+	closeMessage   DisconnectMessage
+	disconnectOnce sync.Once
+}
+
+// eventFrame builds the frame sending an event to this connection
+func (c *SSEConnection) eventFrame(event *Event) *OutboundFrame {
+	frame := &OutboundFrame{Data: event.JSON, Key: event.Channel, Channel: event.Channel, Type: event.Type, ID: event.ID, ReceivedAt: event.ReceivedAt}
+	if c.Delta != nil {
+		frame.Encode = func() ([]byte, error) { return c.Delta.Encode(event) }
+	}
+	return frame
 }
 
+// Evict asks the connection's handler to close the stream. The client
+// reconnects and catches up through Last-Event-ID replay.
+func (c *SSEConnection) Evict() {
+	c.evictOnce.Do(func() {
+		close(c.Done)
+	})
+}
+
+// sseNoReconnectRetry is the reconnect delay hinted to SSE clients told not
+// to reconnect, for clients that do not close the EventSource themselves
+const sseNoReconnectRetry = 24 * time.Hour
+
+// Disconnect asks the connection's handler to send a final disconnect event
+// with the given reason and close. Unlike Evict, it is not counted as an
+// eviction. Only the first call has any effect.
+func (c *SSEConnection) Disconnect(reason string, reconnect bool) {
+	c.disconnectOnce.Do(func() {
+		c.closeMessage = DisconnectMessage{Type: MessageTypeDisconnect, Reason: reason, Reconnect: reconnect}
+		close(c.disconnect)
+	})
+}
+
+// connectionSendBuffer is the number of outbound frames queued per connection
+// before further broadcasts to it are dropped
+const connectionSendBuffer = 64
+
 // NewServer creates a new combined server
 func NewServer(logLevel string) *Server {
 	// Initialize logger
 	logger := NewLogger(logLevel)
 
-	// Initialize Redis client
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" {
-		redisURL = "redis://localhost:6379"
+	// Initialize pub/sub broker
+	broker, err := NewBrokerFromEnv(logger)
+	if err != nil {
+		logger.Error("Failed to initialize broker: %v", err)
+		os.Exit(1)
+	}
+	logger.Info("📬 Pub/sub broker: %s", broker.Name())
+
+	// Load ActionCable channel definitions
+	channelsConfig := os.Getenv("CHANNELS_CONFIG")
+	if channelsConfig == "" {
+		channelsConfig = "channels.json"
+	}
+	channels, err := LoadChannelRegistry(channelsConfig, logger)
+	if err != nil {
+		logger.Error("Failed to load channel config: %v", err)
+		os.Exit(1)
 	}
 
-	opt, err := redis.ParseURL(redisURL)
+	// WebSocket send queue settings
+	wsPolicy, err := ParseSlowConsumerPolicy(os.Getenv("WS_SLOW_CONSUMER_POLICY"))
 	if err != nil {
-		logger.Warn("Failed to parse Redis URL: %v", err)
-		opt = &redis.Options{
-			Addr: "localhost:6379",
-		}
+		logger.Error("Invalid WS_SLOW_CONSUMER_POLICY: %v", err)
+		os.Exit(1)
 	}
 
-	redisClient := redis.NewClient(opt)
+	// SSE send queue settings; by default clients that fall behind are evicted
+	// and recover the gap through replay when they reconnect
+	ssePolicyName := os.Getenv("SSE_SLOW_CONSUMER_POLICY")
+	if ssePolicyName == "" {
+		ssePolicyName = string(PolicyDisconnect)
+	}
+	ssePolicy, err := ParseSlowConsumerPolicy(ssePolicyName)
+	if err != nil {
+		logger.Error("Invalid SSE_SLOW_CONSUMER_POLICY: %v", err)
+		os.Exit(1)
+	}
 
-	// Test Redis connection
-	ctx := context.Background()
-	_, err = redisClient.Ping(ctx).Result()
+	// Browser origins allowed to connect
+	origins, err := NewOriginPolicyFromEnv(logger)
 	if err != nil {
-		logger.Warn("Redis not available: %v", err)
-		redisClient = nil
-	} else {
-		logger.Info("✅ Redis connected successfully")
+		logger.Error("Invalid ALLOWED_ORIGINS: %v", err)
+		os.Exit(1)
+	}
+
+	// Authentication for stream endpoints
+	auth, err := NewAuthChainFromEnv(logger)
+	if err != nil {
+		logger.Error("Failed to configure authentication: %v", err)
+		os.Exit(1)
 	}
 
-	return &Server{
-		sseConnections: make(map[string]*SSEConnection),
-		wsConnections:  make(map[string]*WebSocketConnection),
-		redisClient:    redisClient,
+	server := &Server{
+		sseConnections:  make(map[string]*SSEConnection),
+		wsConnections:   make(map[string]*WebSocketConnection),
+		broker:          broker,
+		channels:        channels,
+		auth:            auth,
+		origins:         origins,
+		wsQueueSize:     getEnvInt("WS_SEND_QUEUE_SIZE", connectionSendBuffer),
+		wsPolicy:        wsPolicy,
+		wsWriteTimeout:  time.Duration(getEnvInt("WS_WRITE_TIMEOUT_SECONDS", 10)) * time.Second,
+		sseQueueSize:    getEnvInt("SSE_SEND_QUEUE_SIZE", connectionSendBuffer),
+		ssePolicy:       ssePolicy,
+		sseWriteTimeout: time.Duration(getEnvInt("SSE_WRITE_TIMEOUT_SECONDS", 10)) * time.Second,
+		deltaFullEvery:  getEnvInt("DELTA_FULL_EVERY", defaultDeltaFullEvery),
+		maxUpdateRate:   getEnvFloat("MAX_UPDATES_PER_SECOND", 0),
+		publishTokens:   parseTokens(os.Getenv("PUBLISH_TOKEN")),
+		publishMaxBytes: int64(getEnvInt("PUBLISH_MAX_BYTES", defaultPublishMaxBytes)),
+		adminTokens:     parseTokens(os.Getenv("ADMIN_TOKEN")),
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins for development
-			},
+			Subprotocols: []string{ActionCableProtocol, ActionCableUnsupportedProtocol},
+			CheckOrigin:  origins.CheckRequest,
 		},
-		logger: logger,
-		stats:  NewServerStats(),
+		logger:  logger,
+		stats:   NewServerStats(),
+		metrics: NewMetrics(),
+	}
+	// Payload field that names each event's type; set it empty to disable
+	typeField, ok := os.LookupEnv("EVENT_TYPE_FIELD")
+	if !ok {
+		typeField = "type"
 	}
+	server.hub = NewHub(server, HubConfig{
+		ReplaySize:      getEnvInt("SSE_REPLAY_SIZE", 100),
+		TypeField:       typeField,
+		SnapshotByType:  os.Getenv("SNAPSHOT_BY_TYPE") != "false",
+		SnapshotPersist: os.Getenv("SNAPSHOT_PERSIST") != "false",
+		SnapshotTTL:     time.Duration(getEnvInt("SNAPSHOT_TTL_SECONDS", 86400)) * time.Second,
+	})
+	server.presence = NewPresenceFromEnv(server)
+	server.sampler = NewStatsSampler(server)
+	server.actions = NewActionRouterFromEnv(server)
+	if server.actions.NeedsReplies() {
+		server.hub.Intercept(server.actions.ReplyChannel(), server.actions.HandleReply)
+	}
+	server.supervisor = NewBrokerSupervisor(server)
+	return server
 }
 
-// generateConnectionID generates a unique connection ID
+// generateConnectionID generates a unique connection ID. The timestamp
+// tells instances' connections apart, and the sequence number connections
+// accepted here in the same clock tick.
 func (s *Server) generateConnectionID() string {
-	return fmt.Sprintf("conn_%d", time.Now().UnixNano())
+	return fmt.Sprintf("conn_%d_%d", time.Now().UnixNano(), s.connectionSeq.Add(1))
 }
 
 // addSSEConnection adds a new SSE connection
@@ -311,89 +510,183 @@ func (s *Server) removeWSConnection(id string) {
 	s.logger.Info("❌ WebSocket connection removed: %s (total: %d)", id, len(s.wsConnections))
 }
 
-// broadcastToSSE broadcasts a message to all SSE connections
-func (s *Server) broadcastToSSE(data interface{}) {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		s.logger.Error("Error marshaling SSE data: %v", err)
-		return
-	}
-
+// broadcastToSSE queues an event for all SSE connections subscribed to its channel
+func (s *Server) broadcastToSSE(event *Event) {
 	s.sseMutex.RLock()
 	defer s.sseMutex.RUnlock()
 
 	s.logger.Debug("Broadcasting to %d SSE connections", len(s.sseConnections))
 	for _, conn := range s.sseConnections {
-		_, err := fmt.Fprintf(conn.Writer, "data: %s\n\n", jsonData)
-		if err != nil {
-			s.logger.Error("Error sending SSE data to connection %s: %v", conn.ID, err)
+		if !conn.Subscriptions[event.Channel] || !conn.Filter.Allows(event) {
 			continue
 		}
-		conn.Flusher.Flush()
-		s.stats.IncrementSSEMessage()
-		s.logger.Debug("Sent SSE data to connection %s", conn.ID)
+		if conn.Throttle != nil {
+			conn.Throttle.Offer(conn.eventFrame(event))
+			continue
+		}
+		s.queueSSEFrame(conn, conn.eventFrame(event))
 	}
 }
 
-// broadcastToWebSocket broadcasts a message to all WebSocket connections subscribed to a channel
-func (s *Server) broadcastToWebSocket(channel string, data interface{}) {
-	// Map stream name back to channel class for the identifier
-	var channelClass string
-	switch channel {
-	case "dashboard_updates":
-		channelClass = "DashboardUpdatesChannel"
-	default:
-		channelClass = channel // fallback
-	}
-
-	message := ActionCableMessage{
-		Identifier: fmt.Sprintf(`{"channel":"%s"}`, channelClass),
-		Message:    data,
+// queueSSEFrame pushes a frame onto an SSE connection's queue, evicting the
+// client if its slow-consumer policy says so
+func (s *Server) queueSSEFrame(conn *SSEConnection, frame *OutboundFrame) {
+	dropped, ok := conn.Queue.Push(frame)
+	if dropped > 0 {
+		s.stats.AddSSEDropped(dropped)
+		s.logger.Debug("SSE connection %s fell behind, dropped %d queued messages", conn.ID, dropped)
 	}
-
-	jsonData, err := json.Marshal(message)
-	if err != nil {
-		s.logger.Error("Error marshaling WebSocket data: %v", err)
+	if !ok {
+		s.logger.Warn("⚠️ SSE send queue full, evicting slow consumer %s", conn.ID)
+		conn.Evict()
 		return
 	}
+	s.observeQueueDepth("sse", conn.Queue)
+	s.logger.Debug("Queued SSE data for connection %s", conn.ID)
+}
+
+// broadcastToWebSocket queues an event for every WebSocket subscription that
+// streams from its channel, addressed with that subscription's identifier
+func (s *Server) broadcastToWebSocket(event *Event) {
+	frames := make(map[string][]byte) // Identifier -> encoded frame
 
 	s.wsMutex.RLock()
 	defer s.wsMutex.RUnlock()
 
-	s.logger.Debug("Broadcasting to WebSocket connections subscribed to '%s' (channel class: %s)", channel, channelClass)
-	s.logger.Debug("Message: %s", string(jsonData))
+	s.logger.Debug("Broadcasting to WebSocket connections subscribed to '%s'", event.Channel)
 
 	for _, conn := range s.wsConnections {
 		conn.mu.RLock()
-		if conn.Subscriptions[channel] {
-			s.logger.Debug("Sending to WebSocket connection %s (subscribed to %s)", conn.ID, channel)
-			err := conn.Conn.WriteMessage(websocket.TextMessage, jsonData)
-			if err != nil {
-				s.logger.Error("Error sending WebSocket data to connection %s: %v", conn.ID, err)
+		for identifier, sub := range conn.Subscriptions {
+			if !sub.streamsTo(event.Channel) || !sub.Filter.Allows(event) {
 				continue
 			}
-			s.logger.Debug("Successfully sent message to WebSocket connection %s", conn.ID)
-		} else {
-			s.logger.Debug("WebSocket connection %s not subscribed to %s (subscriptions: %v)", conn.ID, channel, conn.Subscriptions)
+
+			frame := &OutboundFrame{Key: identifier + "\x00" + event.Channel, Channel: event.Channel, Type: event.Type, ID: event.ID, ReceivedAt: event.ReceivedAt}
+			if sub.Delta != nil {
+				frame.Encode = deltaBroadcast(sub, event)
+			} else {
+				jsonData, ok := frames[identifier]
+				if !ok {
+					var err error
+					jsonData, err = actionCableBroadcast(identifier, event)
+					if err != nil {
+						s.logger.Error("Error marshaling WebSocket data: %v", err)
+						continue
+					}
+					frames[identifier] = jsonData
+				}
+				frame.Data = jsonData
+			}
+			sub.liveMu.Lock()
+			if sub.liveKeys != nil {
+				sub.liveKeys[event.Channel+"\x00"+s.hub.snapshotKey(event)] = true
+			}
+			if sub.Throttle != nil {
+				sub.Throttle.Offer(frame)
+			} else {
+				s.queueWebSocketFrame(conn, frame)
+			}
+			sub.liveMu.Unlock()
 		}
 		conn.mu.RUnlock()
 	}
 }
 
+// queueWebSocketFrame pushes a broadcast onto a WebSocket connection's
+// queue, disconnecting the client if its slow-consumer policy says so
+func (s *Server) queueWebSocketFrame(conn *WebSocketConnection, frame *OutboundFrame) {
+	if _, ok := conn.Queue.Push(frame); !ok {
+		s.logger.Warn("⚠️ WebSocket send queue full, disconnecting slow consumer %s", conn.ID)
+		conn.Disconnect(DisconnectSlowConsumer, true)
+		return
+	}
+	s.observeQueueDepth("websocket", conn.Queue)
+	s.logger.Debug("Queued message for WebSocket connection %s", conn.ID)
+}
+
+// maxSSEChannels caps how many streams one SSE connection may subscribe to
+const maxSSEChannels = 16
+
+// sseEventNamePattern restricts SSE channel names and event types to
+// characters that are safe on an event: line
+var sseEventNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.:\-]+$`)
+
+// parseSSEChannels reads the streams an SSE client asked for, from
+// /stream/{channel} or ?channels=a,b. Clients that name their channels get
+// named events; plain /dashboard/stream keeps unnamed dashboard_updates
+// events for existing EventSource onmessage handlers.
+func parseSSEChannels(r *http.Request) (map[string]bool, bool, error) {
+	var names []string
+	if channel := strings.TrimPrefix(r.URL.Path, "/stream/"); channel != r.URL.Path {
+		names = []string{channel}
+	} else if value := r.URL.Query().Get("channels"); value != "" {
+		names = strings.Split(value, ",")
+	} else {
+		return map[string]bool{"dashboard_updates": true}, false, nil
+	}
+
+	channels := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !sseEventNamePattern.MatchString(name) {
+			return nil, false, fmt.Errorf("invalid channel name %q", name)
+		}
+		channels[name] = true
+	}
+	if len(channels) == 0 {
+		return nil, false, fmt.Errorf("no channels requested")
+	}
+	if len(channels) > maxSSEChannels {
+		return nil, false, fmt.Errorf("at most %d channels per stream", maxSSEChannels)
+	}
+	return channels, true, nil
+}
+
 // streamHandler handles SSE stream requests
 func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
-	// Set CORS headers for SSE
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Cache-Control")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-	// Handle preflight requests
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+	if s.rejectWhileDraining(w) {
+		return
+	}
+
+	identity, err := s.auth.Authenticate(r)
+	if err != nil {
+		s.logger.Warn("🔒 SSE authentication failed from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
+	subscriptions, named, err := parseSSEChannels(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	delta, err := parseDeltaEncoding(r.URL.Query().Get("encoding"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rate, err := s.updateRate(r.URL.Query().Get("max_rate"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := ParseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for stream := range subscriptions {
+		if err := s.channels.Authorizer().Authorize(r.Context(), identity, "", stream); err != nil {
+			s.logger.Warn("🔒 SSE stream %s refused for %s: %v", stream, identity.Subject, err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
 	// Set SSE headers
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -409,33 +702,171 @@ func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Create connection
 	conn := &SSEConnection{
-		ID:      s.generateConnectionID(),
-		Writer:  w,
-		Flusher: flusher,
-		Done:    make(chan bool),
+		ID:            s.generateConnectionID(),
+		Writer:        w,
+		Flusher:       flusher,
+		Identity:      identity,
+		RemoteAddr:    r.RemoteAddr,
+		UserAgent:     r.UserAgent(),
+		ConnectedAt:   time.Now(),
+		Subscriptions: subscriptions,
+		NamedEvents:   named,
+		Filter:        filter,
+		Queue:         NewSendQueue(s.sseQueueSize, s.ssePolicy),
+		Done:          make(chan struct{}),
+		drain:         make(chan struct{}),
+		disconnect:    make(chan struct{}),
+	}
+	if delta {
+		conn.Delta = NewDeltaEncoder(s.deltaFullEvery)
+	}
+	if rate > 0 {
+		conn.Throttle = NewThrottle(rate, func(frame *OutboundFrame) { s.queueSSEFrame(conn, frame) }, s.stats.IncrementSSEConflated)
+		defer conn.Throttle.Stop()
+	}
+	defer conn.Queue.Close()
+
+	// Bound every write so a stalled client cannot hold its handler forever
+	rc := http.NewResponseController(w)
+	setWriteDeadline := func() {
+		if err := rc.SetWriteDeadline(time.Now().Add(s.sseWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			s.logger.Debug("Unable to set write deadline for SSE connection %s: %v", conn.ID, err)
+		}
 	}
 
 	// Add connection
 	s.addSSEConnection(conn)
 	defer s.removeSSEConnection(conn.ID)
 
-	s.logger.Debug("SSE connection established: %s", conn.ID)
+	// Register with the shared hub subscription, and as present on each channel
+	for channel := range conn.Subscriptions {
+		s.hub.Acquire(channel)
+		defer s.hub.Release(channel)
+		s.presence.Join(channel, conn.ID, identity, "sse", conn.ConnectedAt)
+		defer s.presence.Leave(channel, conn.ID)
+	}
+
+	s.logger.Debug("SSE connection established: %s (%s via %s)", conn.ID, identity.Subject, identity.Method)
 
-	// Setup Redis pub/sub if available
-	var redisCh <-chan *redis.Message
-	var pubsub *redis.PubSub
-	if s.redisClient != nil {
-		// Use the shared Redis client for pub/sub
-		pubsub = s.redisClient.Subscribe(r.Context(), "dashboard_updates")
-		defer pubsub.Close()
-		redisCh = pubsub.Channel()
-		s.logger.Debug("Redis pub/sub started for SSE connection: %s", conn.ID)
+	// Flush headers now so the client sees the stream open before the first event
+	setWriteDeadline()
+	if err := rc.Flush(); err != nil {
+		s.logger.Error("Error opening SSE stream %s: %v", conn.ID, err)
+		return
 	}
 
 	// Setup heartbeat timer with reset capability
 	heartbeatTicker := time.NewTicker(30 * time.Second)
 	defer heartbeatTicker.Stop()
 
+	// sendEvent writes a single event frame. Events the replay or snapshot
+	// already sent are skipped, so live copies queued meanwhile are not
+	// duplicated. Nothing else is filtered by ID: a Last-Event-ID from another
+	// instance, or from before a restart, says nothing about this one's IDs.
+	caughtUp := make(map[uint64]bool)
+	sendEvent := func(frame *OutboundFrame) error {
+		if caughtUp[frame.ID] {
+			delete(caughtUp, frame.ID)
+			return nil
+		}
+		data := frame.Data
+		if frame.Encode != nil {
+			var err error
+			if data, err = frame.Encode(); err != nil {
+				s.logger.Error("Error encoding event %d for SSE connection %s: %v", frame.ID, conn.ID, err)
+				return nil
+			}
+		}
+		setWriteDeadline()
+		written := 0
+		if conn.NamedEvents {
+			// Name events "<channel>.<type>" when the payload has a type, so
+			// multi-channel clients still know where each frame came from
+			name := frame.Channel
+			if frame.Type != "" {
+				name += "." + frame.Type
+			}
+			n, err := fmt.Fprintf(w, "event: %s\n", name)
+			if err != nil {
+				return err
+			}
+			written += n
+		}
+		n, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", frame.ID, data)
+		if err != nil {
+			return err
+		}
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+		conn.Sent.Add(1, written+n)
+		s.stats.IncrementSSEMessage()
+
+		// Reset heartbeat timer since we just sent data
+		heartbeatTicker.Reset(30 * time.Second)
+		return nil
+	}
+
+	// sendDisconnect writes the final event of a stream, named so clients
+	// listening for named events see it too, with a reconnect delay hint
+	sendDisconnect := func(message DisconnectMessage, retry time.Duration) error {
+		final, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		hint := ""
+		if retry > 0 {
+			hint = fmt.Sprintf("retry: %d\n", retry.Milliseconds())
+		}
+		setWriteDeadline()
+		n, err := fmt.Fprintf(w, "%sevent: disconnect\ndata: %s\n\n", hint, final)
+		if err == nil {
+			err = rc.Flush()
+		}
+		conn.Sent.Add(0, n)
+		return err
+	}
+
+	// Resend anything published since the client's last seen event. Channels
+	// where that gap cannot be filled, because the event is no longer
+	// buffered here, get their current state instead, as do new clients
+	// rather than an empty dashboard until the next publish. Everything goes
+	// out in ID order, so the client's next Last-Event-ID is the newest.
+	var missed []*Event
+	stale := make([]string, 0, len(conn.Subscriptions))
+	if lastID, ok := parseLastEventID(r.Header.Get("Last-Event-ID"), r.URL.Query().Get("lastEventId")); ok {
+		missed, stale = s.hub.Replay(conn.Subscriptions, lastID)
+		if len(stale) < len(conn.Subscriptions) {
+			s.logger.Info("🔁 Replaying %d missed events to SSE connection %s (Last-Event-ID: %d)", len(missed), conn.ID, lastID)
+		}
+		if len(stale) > 0 {
+			s.logger.Info("🕳️ Last-Event-ID %d of SSE connection %s is not buffered for %v, sending snapshot", lastID, conn.ID, stale)
+		}
+	} else {
+		for channel := range conn.Subscriptions {
+			stale = append(stale, channel)
+		}
+	}
+	var snapshot []*Event
+	for _, channel := range stale {
+		snapshot = append(snapshot, s.hub.Snapshot(channel)...)
+	}
+	catchUp := append(missed, snapshot...)
+	sort.Slice(catchUp, func(i, j int) bool { return catchUp[i].ID < catchUp[j].ID })
+	for _, event := range catchUp {
+		if !conn.Filter.Allows(event) {
+			continue
+		}
+		if err := sendEvent(conn.eventFrame(event)); err != nil {
+			s.logger.Error("Error catching up SSE connection %s: %v", conn.ID, err)
+			return
+		}
+		caughtUp[event.ID] = true
+	}
+	if len(snapshot) > 0 {
+		s.logger.Debug("📸 Sent %d snapshot events to SSE connection %s", len(snapshot), conn.ID)
+	}
+
 	// Combined select statement for all events
 	for {
 		select {
@@ -443,44 +874,61 @@ func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
 			s.logger.Info("SSE client disconnected: %s", conn.ID)
 			return
 		case <-conn.Done:
-			s.logger.Info("SSE connection closed: %s", conn.ID)
+			s.stats.IncrementSSEEvicted()
+			s.logger.Info("SSE connection evicted: %s", conn.ID)
+			return
+		case <-conn.drain:
+			// Server is shutting down: deliver what is already queued, then
+			// tell the client when to reconnect and why
+			for frame, ok := conn.Queue.Pop(); ok; frame, ok = conn.Queue.Pop() {
+				if err := sendEvent(frame); err != nil {
+					s.logger.Error("Error sending data to SSE connection %s: %v", conn.ID, err)
+					return
+				}
+			}
+			restart := DisconnectMessage{Type: MessageTypeDisconnect, Reason: DisconnectServerRestart, Reconnect: true}
+			if err := sendDisconnect(restart, conn.drainRetry); err != nil {
+				s.logger.Error("Error sending shutdown notice to SSE connection %s: %v", conn.ID, err)
+				return
+			}
+			s.logger.Info("SSE connection drained: %s (retry in %s)", conn.ID, conn.drainRetry.Round(time.Millisecond))
+			return
+		case <-conn.disconnect:
+			// Disconnected on request: clients told not to reconnect get a
+			// retry hint they will not reach
+			retry := time.Duration(0)
+			if !conn.closeMessage.Reconnect {
+				retry = sseNoReconnectRetry
+			}
+			if err := sendDisconnect(conn.closeMessage, retry); err != nil {
+				s.logger.Error("Error sending disconnect to SSE connection %s: %v", conn.ID, err)
+				return
+			}
+			s.logger.Info("SSE connection disconnected: %s (reason: %s, reconnect: %v)", conn.ID, conn.closeMessage.Reason, conn.closeMessage.Reconnect)
 			return
 		case <-heartbeatTicker.C:
 			// Send heartbeat
-			_, err := fmt.Fprintf(w, ": heartbeat\n\n")
+			setWriteDeadline()
+			n, err := fmt.Fprintf(w, ": heartbeat\n\n")
+			if err == nil {
+				err = rc.Flush()
+			}
+			conn.Sent.Add(0, n)
 			if err != nil {
 				s.logger.Error("Error sending heartbeat to %s: %v", conn.ID, err)
 				return
 			}
-			flusher.Flush()
 			s.logger.Debug("💓 Heartbeat sent to SSE connection %s", conn.ID)
-		case msg := <-redisCh:
-			// Handle Redis message
-			var data interface{}
-			err := json.Unmarshal([]byte(msg.Payload), &data)
-			if err != nil {
-				s.logger.Error("Error parsing Redis message: %v", err)
-				continue
-			}
-
-			// Send the data to the client
-			jsonData, err := json.Marshal(data)
-			if err != nil {
-				s.logger.Error("Error marshaling data: %v", err)
-				continue
-			}
-
-			_, err = fmt.Fprintf(w, "data: %s\n\n", jsonData)
-			if err != nil {
-				s.logger.Error("Error sending Redis data to SSE connection %s: %v", conn.ID, err)
-				return
+		case <-conn.Queue.Ready():
+			// Send events fanned out by the hub
+			for frame, ok := conn.Queue.Pop(); ok; frame, ok = conn.Queue.Pop() {
+				if err := sendEvent(frame); err != nil {
+					s.logger.Error("Error sending data to SSE connection %s: %v", conn.ID, err)
+					return
+				}
+				s.observeFanoutLatency("sse", frame)
+				s.logger.Debug("Event %d sent to SSE connection %s", frame.ID, conn.ID)
 			}
-			flusher.Flush()
-
-			// Reset heartbeat timer since we just sent data
-			heartbeatTicker.Reset(30 * time.Second)
-
-			s.logger.Debug("Redis message sent to SSE connection %s: %s", conn.ID, msg.Payload)
 		}
 	}
 }
@@ -490,12 +938,31 @@ func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
 	// Log connection attempt
 	s.logger.Info("🔗 WebSocket connection attempt from %s", r.RemoteAddr)
 
-	// Upgrade HTTP connection to WebSocket
+	if s.rejectWhileDraining(w) {
+		return
+	}
+
+	identity, authErr := s.auth.Authenticate(r)
+
+	// Upgrade HTTP connection to WebSocket, negotiating the ActionCable subprotocol
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		s.logger.Error("❌ WebSocket upgrade failed: %v", err)
 		return
 	}
+
+	// Reject unauthenticated clients the way reject_unauthorized_connection
+	// does, so the ActionCable client stops reconnecting
+	if authErr != nil {
+		s.logger.Warn("🔒 WebSocket authentication failed from %s: %v", r.RemoteAddr, authErr)
+		conn.SetWriteDeadline(time.Now().Add(s.wsWriteTimeout))
+		conn.WriteJSON(DisconnectMessage{Type: MessageTypeDisconnect, Reason: DisconnectUnauthorized, Reconnect: false})
+		conn.Close()
+		return
+	}
+	if conn.Subprotocol() != ActionCableProtocol {
+		s.logger.Warn("⚠️ WebSocket client %s did not negotiate %s (got %q)", r.RemoteAddr, ActionCableProtocol, conn.Subprotocol())
+	}
 	defer func() {
 		s.logger.Info("🔌 WebSocket connection closed for %s", r.RemoteAddr)
 		conn.Close()
@@ -505,47 +972,55 @@ func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
 	wsConn := &WebSocketConnection{
 		ID:            s.generateConnectionID(),
 		Conn:          conn,
-		Subscriptions: make(map[string]bool),
+		Identity:      identity,
+		RemoteAddr:    r.RemoteAddr,
+		UserAgent:     r.UserAgent(),
+		ConnectedAt:   time.Now(),
+		Subscriptions: make(map[string]*ChannelSubscription),
+		Queue:         NewSendQueue(s.wsQueueSize, s.wsPolicy),
+		Done:          make(chan struct{}),
 	}
 
+	// Start the single writer; every frame to this client goes through the queue
+	writerDone := make(chan struct{})
+	go s.wsWriteLoop(wsConn, writerDone)
+	defer wsConn.Queue.Close()
+
 	// Add connection
 	s.addWSConnection(wsConn)
 	defer s.removeWSConnection(wsConn.ID)
 
-	s.logger.Debug("WebSocket connection established: %s", wsConn.ID)
+	// Release any hub subscriptions, throttles and presence still held when the connection goes away
+	defer func() {
+		var left []string
+		wsConn.mu.Lock()
+		for _, sub := range wsConn.Subscriptions {
+			if sub.Throttle != nil {
+				sub.Throttle.Stop()
+			}
+			left = append(left, sub.Streams...)
+		}
+		wsConn.mu.Unlock()
+		for _, streamName := range left {
+			s.hub.Release(streamName)
+			s.presence.Leave(streamName, wsConn.ID)
+		}
+	}()
+
+	s.logger.Debug("WebSocket connection established: %s (%s via %s)", wsConn.ID, identity.Subject, identity.Method)
 
 	// Send welcome message
-	welcomeMsg := ActionCableMessage{Type: "welcome"}
-	if err := conn.WriteJSON(welcomeMsg); err != nil {
-		s.logger.Error("❌ Error sending welcome message: %v", err)
+	welcomeMsg := ActionCableMessage{Type: MessageTypeWelcome}
+	if !s.sendWebSocketJSON(wsConn, welcomeMsg) {
 		return
 	}
-	s.logger.Info("🎉 Welcome message sent to WebSocket connection: %s", wsConn.ID)
+	s.logger.Info("🎉 Welcome message queued for WebSocket connection: %s", wsConn.ID)
 
-	// Setup Redis pub/sub if available
-	var redisCh <-chan *redis.Message
-	var pubsub *redis.PubSub
-	if s.redisClient != nil {
-		pubsub = s.redisClient.Subscribe(r.Context(), "dashboard_updates")
-		defer func() {
-			s.logger.Info("🔌 Redis pub/sub closed for WebSocket connection: %s", wsConn.ID)
-			pubsub.Close()
-		}()
-		redisCh = pubsub.Channel()
-		s.logger.Info("🔗 Redis pub/sub started for WebSocket connection: %s", wsConn.ID)
-	} else {
-		s.logger.Warn("⚠️ Redis not available for WebSocket connection: %s", wsConn.ID)
-	}
-
-	// Setup ping ticker (check for idle connections every 60 seconds)
-	pingTicker := time.NewTicker(60 * time.Second)
+	// Setup ping ticker. Pings are sent on a fixed beat rather than only when
+	// idle, because the ActionCable client only counts pings as liveness.
+	pingTicker := time.NewTicker(actionCablePingInterval)
 	defer pingTicker.Stop()
 
-	// Helper function to reset ping ticker
-	resetPingTicker := func() {
-		pingTicker.Reset(60 * time.Second)
-	}
-
 	// Create a channel for incoming messages
 	incomingMessages := make(chan []byte, 10)
 	readDone := make(chan bool)
@@ -596,61 +1071,116 @@ func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
 		case <-readDone:
 			s.logger.Info("🛑 WebSocket read goroutine finished: %s", wsConn.ID)
 			return
+		case <-writerDone:
+			s.logger.Info("🛑 WebSocket connection terminated due to write error: %s", wsConn.ID)
+			return
+		case <-wsConn.Done:
+			// Server-initiated disconnect: flush the disconnect frame, then close
+			s.sendWebSocketJSON(wsConn, wsConn.closeMessage)
+			wsConn.Queue.Close()
+			select {
+			case <-writerDone:
+			case <-time.After(s.wsWriteTimeout):
+			}
+			s.logger.Info("🛑 WebSocket connection %s disconnected by server: %s (reconnect: %v)",
+				wsConn.ID, wsConn.closeMessage.Reason, wsConn.closeMessage.Reconnect)
+			return
 		case <-pingTicker.C:
-			// Send ping (ticker only fires if no activity has reset it)
-			pingMsg := ActionCableMessage{Type: "ping"}
-			if err := conn.WriteJSON(pingMsg); err != nil {
-				s.logger.Error("❌ Error sending ping to WebSocket connection %s: %v", wsConn.ID, err)
-				s.logger.Info("🛑 WebSocket connection terminated due to ping error: %s", wsConn.ID)
+			// Send ping carrying the server time, as Rails does
+			pingMsg := ActionCableMessage{Type: MessageTypePing, Message: time.Now().Unix()}
+			if !s.sendWebSocketJSON(wsConn, pingMsg) {
 				return
 			}
-			resetPingTicker() // Reset ticker after sending ping
-			s.logger.Info("💓 Ping sent to WebSocket connection %s", wsConn.ID)
-		case msg := <-redisCh:
-			// Handle Redis message - send directly to this connection if subscribed
-			s.stats.IncrementRedisMessage()
-			var data interface{}
-			err := json.Unmarshal([]byte(msg.Payload), &data)
+			s.logger.Debug("💓 Ping queued for WebSocket connection %s", wsConn.ID)
+		case message := <-incomingMessages:
+			// Process incoming message
+			s.handleWebSocketMessage(wsConn, message)
+		}
+	}
+}
+
+// sendWebSocketJSON queues a protocol frame for the connection's writer
+func (s *Server) sendWebSocketJSON(conn *WebSocketConnection, v interface{}) bool {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		s.logger.Error("Error marshaling WebSocket message: %v", err)
+		return false
+	}
+	if _, ok := conn.Queue.Push(&OutboundFrame{Data: jsonData, Control: true}); !ok {
+		s.logger.Debug("WebSocket send queue closed for connection %s", conn.ID)
+		return false
+	}
+	return true
+}
+
+// sendWebSocketSnapshot queues the latest state of a new subscription's
+// streams right after its confirmation. Events superseded by a live frame
+// already queued for the same stream and type are skipped, so the snapshot
+// never overwrites newer data.
+func (s *Server) sendWebSocketSnapshot(conn *WebSocketConnection, sub *ChannelSubscription) {
+	// Read the snapshots first: the first read of a stream may wait on the store
+	var events []*Event
+	for _, stream := range sub.Streams {
+		events = append(events, s.hub.Snapshot(stream)...)
+	}
+
+	sub.liveMu.Lock()
+	defer sub.liveMu.Unlock()
+	live := sub.liveKeys
+	sub.liveKeys = nil
+	for _, event := range events {
+		if !sub.Filter.Allows(event) || live[event.Channel+"\x00"+s.hub.snapshotKey(event)] {
+			continue
+		}
+		frame := &OutboundFrame{Key: sub.Identifier + "\x00" + event.Channel, Channel: event.Channel, Type: event.Type, ID: event.ID}
+		if sub.Delta != nil {
+			frame.Encode = deltaBroadcast(sub, event)
+		} else {
+			jsonData, err := actionCableBroadcast(sub.Identifier, event)
 			if err != nil {
-				s.logger.Error("Error parsing Redis message: %v", err)
+				s.logger.Error("Error marshaling WebSocket data: %v", err)
 				continue
 			}
+			frame.Data = jsonData
+		}
+		if _, ok := conn.Queue.Push(frame); !ok {
+			return
+		}
+	}
+}
 
-			// Check if this connection is subscribed to dashboard_updates
-			wsConn.mu.RLock()
-			if wsConn.Subscriptions["dashboard_updates"] {
-				// Map stream name back to channel class for the identifier
-				channelClass := "DashboardUpdatesChannel"
-				message := ActionCableMessage{
-					Identifier: fmt.Sprintf(`{"channel":"%s"}`, channelClass),
-					Message:    data,
-				}
-
-				jsonData, err := json.Marshal(message)
-				if err != nil {
-					s.logger.Error("Error marshaling WebSocket data: %v", err)
-					wsConn.mu.RUnlock()
-					continue
-				}
+// wsWriteLoop is the only goroutine that writes to a WebSocket connection.
+// It drains the send queue until the queue is closed or a write fails, and
+// closes done on exit.
+func (s *Server) wsWriteLoop(conn *WebSocketConnection, done chan struct{}) {
+	defer close(done)
+	for {
+		frame, ok := conn.Queue.Next(nil)
+		if !ok {
+			return
+		}
 
-				s.logger.Debug("Sending Redis message to WebSocket connection %s", wsConn.ID)
-				err = conn.WriteMessage(websocket.TextMessage, jsonData)
-				if err != nil {
-					s.logger.Error("❌ Error sending WebSocket data to connection %s: %v", wsConn.ID, err)
-					s.logger.Info("🛑 WebSocket connection terminated due to write error: %s", wsConn.ID)
-					wsConn.mu.RUnlock()
-					return
-				}
-				resetPingTicker() // Reset ping ticker since we just sent a message
-				s.stats.IncrementWebSocketMessage()
-				s.logger.Debug("Successfully sent message to WebSocket connection %s", wsConn.ID)
+		if frame.Encode != nil {
+			data, err := frame.Encode()
+			if err != nil {
+				s.logger.Error("Error encoding WebSocket data for connection %s: %v", conn.ID, err)
+				continue
 			}
-			wsConn.mu.RUnlock()
-			s.logger.Debug("Redis message processed for WebSocket connection %s: %s", wsConn.ID, msg.Payload)
-		case message := <-incomingMessages:
-			// Process incoming message
-			resetPingTicker() // Reset ping ticker since we received a message
-			s.handleWebSocketMessage(wsConn, message)
+			frame.Data = data
+		}
+
+		conn.Conn.SetWriteDeadline(time.Now().Add(s.wsWriteTimeout))
+		if err := conn.Conn.WriteMessage(websocket.TextMessage, frame.Data); err != nil {
+			s.logger.Error("❌ Error writing to WebSocket connection %s: %v", conn.ID, err)
+			conn.Queue.Close()
+			return
+		}
+		if frame.Control {
+			conn.Sent.Add(0, len(frame.Data))
+		} else {
+			conn.Sent.Add(1, len(frame.Data))
+			s.stats.IncrementWebSocketMessage()
+			s.observeFanoutLatency("websocket", frame)
 		}
 	}
 }
@@ -665,75 +1195,173 @@ func (s *Server) handleWebSocketMessage(conn *WebSocketConnection, message []byt
 
 	switch msg.Command {
 	case "subscribe":
-		// Parse identifier to get channel name
-		var identifier map[string]string
-		if err := json.Unmarshal([]byte(msg.Identifier), &identifier); err != nil {
+		channelClass, params, err := parseIdentifier(msg.Identifier)
+		if err != nil {
 			s.logger.Error("Error parsing identifier: %v", err)
+			s.rejectSubscription(conn, msg.Identifier)
 			return
 		}
 
-		channelClass := identifier["channel"]
-		if channelClass != "" {
-			// Map ActionCable channel class to actual stream name
-			// DashboardUpdatesChannel streams from "dashboard_updates"
-			var streamName string
-			switch channelClass {
-			case "DashboardUpdatesChannel":
-				streamName = "dashboard_updates"
-			default:
-				streamName = channelClass // fallback
+		conn.mu.RLock()
+		_, exists := conn.Subscriptions[msg.Identifier]
+		conn.mu.RUnlock()
+		if exists {
+			// Rails ignores duplicate subscriptions without replying
+			s.logger.Warn("Already subscribed to %s on connection %s", msg.Identifier, conn.ID)
+			return
+		}
+
+		// Resolve the channel class to its streams via the registry
+		def, ok := s.channels.Lookup(channelClass)
+		if !ok {
+			s.logger.Warn("Subscription class not found: %s", channelClass)
+			s.rejectSubscription(conn, msg.Identifier)
+			return
+		}
+		streams, err := def.Resolve(params)
+		if err != nil {
+			s.logger.Warn("Subscription to %s refused for connection %s: %v", channelClass, conn.ID, err)
+			s.rejectSubscription(conn, msg.Identifier)
+			return
+		}
+		for _, stream := range streams {
+			if err := s.channels.Authorizer().Authorize(context.Background(), conn.Identity, channelClass, stream); err != nil {
+				s.logger.Warn("🔒 Subscription to %s refused for %s on connection %s: %v", channelClass, conn.Identity.Subject, conn.ID, err)
+				s.rejectSubscription(conn, msg.Identifier)
+				return
 			}
+		}
 
-			conn.mu.Lock()
-			conn.Subscriptions[streamName] = true
-			conn.mu.Unlock()
+		encoding, _ := params["encoding"].(string)
+		delta, err := parseDeltaEncoding(encoding)
+		if err != nil {
+			s.logger.Warn("Subscription to %s refused for connection %s: %v", channelClass, conn.ID, err)
+			s.rejectSubscription(conn, msg.Identifier)
+			return
+		}
+		requestedRate, _ := paramString(params["max_rate"])
+		rate, err := s.updateRate(requestedRate)
+		if err != nil {
+			s.logger.Warn("Subscription to %s refused for connection %s: %v", channelClass, conn.ID, err)
+			s.rejectSubscription(conn, msg.Identifier)
+			return
+		}
+		expression, _ := params["filter"].(string)
+		filter, err := ParseFilter(expression)
+		if err != nil {
+			s.logger.Warn("Subscription to %s refused for connection %s: %v", channelClass, conn.ID, err)
+			s.rejectSubscription(conn, msg.Identifier)
+			return
+		}
 
-			// Send confirmation
-			confirmMsg := ActionCableMessage{
-				Type:       "confirm_subscription",
-				Identifier: msg.Identifier,
-			}
-			if err := conn.Conn.WriteJSON(confirmMsg); err != nil {
-				s.logger.Error("❌ Error sending subscription confirmation: %v", err)
-			} else {
-				s.logger.Info("✅ Subscription confirmation sent to connection %s for channel: %s", conn.ID, channelClass)
-			}
+		sub := &ChannelSubscription{
+			Identifier:   msg.Identifier,
+			ChannelClass: channelClass,
+			Params:       params,
+			Streams:      streams,
+			Filter:       filter,
+			liveKeys:     make(map[string]bool),
+		}
+		if delta {
+			sub.Delta = NewDeltaEncoder(s.deltaFullEvery)
+		}
+		if rate > 0 {
+			sub.Throttle = NewThrottle(rate, func(frame *OutboundFrame) { s.queueWebSocketFrame(conn, frame) }, s.stats.IncrementWebSocketConflated)
+		}
 
-			s.logger.Info("📡 WebSocket connection %s subscribed to channel: %s (stream: %s)", conn.ID, channelClass, streamName)
-			s.logger.Debug("Current subscriptions for connection %s: %v", conn.ID, conn.Subscriptions)
+		// Acquire before registering, and outside conn.mu, as it may wait on the broker
+		for _, stream := range sub.Streams {
+			s.hub.Acquire(stream)
+		}
+		conn.mu.Lock()
+		conn.Subscriptions[msg.Identifier] = sub
+		conn.mu.Unlock()
+		for _, stream := range sub.Streams {
+			s.presence.Join(stream, conn.ID, conn.Identity, "websocket", conn.ConnectedAt)
 		}
 
-	case "unsubscribe":
-		// Parse identifier to get channel name
-		var identifier map[string]string
-		if err := json.Unmarshal([]byte(msg.Identifier), &identifier); err != nil {
-			s.logger.Error("Error parsing identifier: %v", err)
-			return
+		// Send confirmation
+		confirmMsg := ActionCableMessage{
+			Type:       MessageTypeConfirmation,
+			Identifier: msg.Identifier,
 		}
+		if s.sendWebSocketJSON(conn, confirmMsg) {
+			s.logger.Info("✅ Subscription confirmation queued for connection %s for channel: %s", conn.ID, channelClass)
+		}
+		s.sendWebSocketSnapshot(conn, sub)
 
-		channelClass := identifier["channel"]
-		if channelClass != "" {
-			// Map ActionCable channel class to actual stream name
-			var streamName string
-			switch channelClass {
-			case "DashboardUpdatesChannel":
-				streamName = "dashboard_updates"
-			default:
-				streamName = channelClass // fallback
+		s.logger.Info("📡 WebSocket connection %s subscribed to channel: %s (streams: %v)", conn.ID, channelClass, streams)
+
+	case "unsubscribe":
+		conn.mu.Lock()
+		sub, exists := conn.Subscriptions[msg.Identifier]
+		if exists {
+			delete(conn.Subscriptions, msg.Identifier)
+			if sub.Throttle != nil {
+				sub.Throttle.Stop()
 			}
+		}
+		conn.mu.Unlock()
 
-			conn.mu.Lock()
-			delete(conn.Subscriptions, streamName)
-			conn.mu.Unlock()
+		if !exists {
+			s.logger.Warn("Unable to find subscription with identifier: %s", msg.Identifier)
+			return
+		}
+		for _, stream := range sub.Streams {
+			s.hub.Release(stream)
+		}
+		for _, stream := range sub.Streams {
+			s.presence.Leave(stream, conn.ID)
+		}
+		s.logger.Debug("WebSocket connection %s unsubscribed from channel: %s (streams: %v)", conn.ID, sub.ChannelClass, sub.Streams)
 
-			s.logger.Debug("WebSocket connection %s unsubscribed from channel: %s (stream: %s)", conn.ID, channelClass, streamName)
+	case "message":
+		conn.mu.RLock()
+		sub, exists := conn.Subscriptions[msg.Identifier]
+		conn.mu.RUnlock()
+		if !exists {
+			s.logger.Warn("Unable to find subscription with identifier: %s", msg.Identifier)
+			return
 		}
 
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(msg.Data), &data); err != nil {
+			s.logger.Error("Error parsing message data for %s: %v", sub.ChannelClass, err)
+			return
+		}
+		action, _ := data["action"].(string)
+		if action == "resync" && sub.Delta != nil {
+			// The client lost track of its patches: start over from full payloads
+			sub.Delta.Reset()
+			s.sendWebSocketSnapshot(conn, sub)
+			s.logger.Info("🔄 Resynced %s for WebSocket connection %s", sub.ChannelClass, conn.ID)
+			return
+		}
+		def, _ := s.channels.Lookup(sub.ChannelClass)
+		channelAction, ok := def.Actions[action]
+		if !ok {
+			s.logger.Warn("Unable to process %s#%s: no such action", sub.ChannelClass, action)
+			return
+		}
+		s.actions.Perform(conn, sub, action, channelAction, data)
+
 	default:
 		s.logger.Warn("Unknown WebSocket command: %s", msg.Command)
 	}
 }
 
+// rejectSubscription tells the client its subscription was refused
+func (s *Server) rejectSubscription(conn *WebSocketConnection, identifier string) {
+	rejectMsg := ActionCableMessage{
+		Type:       MessageTypeRejection,
+		Identifier: identifier,
+	}
+	if !s.sendWebSocketJSON(conn, rejectMsg) {
+		return
+	}
+	s.logger.Info("🚫 Subscription rejected for connection %s: %s", conn.ID, identifier)
+}
+
 // debugHandler provides debug information
 func debugHandler(w http.ResponseWriter, r *http.Request) {
 	data := map[string]interface{}{
@@ -749,6 +1377,8 @@ func debugHandler(w http.ResponseWriter, r *http.Request) {
 // statsHandler provides server statistics
 func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
 	totalSSE, currentSSE, totalWS, currentWS, sseMsgs, wsMsgs, redisMsgs, uptime := s.stats.GetStats()
+	sseDropped, sseEvicted := s.stats.GetSSEBackpressureStats()
+	sseConflated, wsConflated := s.stats.GetConflatedStats()
 
 	data := map[string]interface{}{
 		"server": map[string]interface{}{
@@ -759,19 +1389,24 @@ func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
 		},
 		"connections": map[string]interface{}{
 			"sse": map[string]interface{}{
-				"total":    totalSSE,
-				"current":  currentSSE,
-				"messages": sseMsgs,
+				"total":              totalSSE,
+				"current":            currentSSE,
+				"messages":           sseMsgs,
+				"dropped_messages":   sseDropped,
+				"evicted_clients":    sseEvicted,
+				"conflated_messages": sseConflated,
 			},
 			"websocket": map[string]interface{}{
-				"total":    totalWS,
-				"current":  currentWS,
-				"messages": wsMsgs,
+				"total":              totalWS,
+				"current":            currentWS,
+				"messages":           wsMsgs,
+				"conflated_messages": wsConflated,
 			},
 		},
 		"redis": map[string]interface{}{
 			"messages_received": redisMsgs,
 		},
+		"broker":    brokerStatusJSON(s.supervisor.Status()),
 		"timestamp": time.Now().Format("2006-01-02 15:04:05"),
 	}
 
@@ -779,23 +1414,64 @@ func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(data)
 }
 
-// CORS middleware function
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Cache-Control")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
+// brokerStatusJSON formats a broker status for the stats endpoint
+func brokerStatusJSON(status BrokerStatus) map[string]interface{} {
+	return map[string]interface{}{
+		"backend":            status.Backend,
+		"state":              status.State,
+		"since":              status.Since.Format("2006-01-02 15:04:05"),
+		"last_error":         status.LastError,
+		"reconnect_attempts": status.Attempts,
+	}
+}
 
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// healthHandler reports OK while the broker is connected and 503 while the
+// server is running degraded
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Draining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("DRAINING"))
+		return
+	}
+
+	status := s.supervisor.Status()
+	if status.State != BrokerStateConnected {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "DEGRADED: %s broker %s since %s: %s", status.Backend, status.State,
+			status.Since.Format("2006-01-02 15:04:05"), status.LastError)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+// getEnvInt reads an integer environment variable, returning def when it is
+// unset or invalid
+func getEnvInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("[WARN] Invalid %s value %q, using default %d", name, value, def)
+		return def
+	}
+	return n
+}
 
-		next(w, r)
+// getEnvFloat reads a decimal environment variable, returning def when it
+// is unset or invalid
+func getEnvFloat(name string, def float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
 	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("[WARN] Invalid %s value %q, using default %g", name, value, def)
+		return def
+	}
+	return f
 }
 
 func main() {
@@ -816,27 +1492,60 @@ func main() {
 	server := NewServer(logLevel)
 
 	// Set up routes
-	http.HandleFunc("/dashboard/stream", corsMiddleware(server.streamHandler))
-	http.HandleFunc("/cable", corsMiddleware(server.websocketHandler)) // ActionCable endpoint
-	http.HandleFunc("/dashboard/debug", debugHandler)
-	http.HandleFunc("/dashboard/stats", corsMiddleware(server.statsHandler))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dashboard/stream", server.corsMiddleware(server.streamHandler))
+	mux.HandleFunc("/stream/", server.corsMiddleware(server.streamHandler))  // Single channel: /stream/{channel}
+	mux.HandleFunc("/cable", server.corsMiddleware(server.websocketHandler)) // ActionCable endpoint
+	mux.HandleFunc("/dashboard/debug", debugHandler)
+	mux.HandleFunc("/dashboard/stats", server.corsMiddleware(server.statsHandler))
+	mux.HandleFunc("/dashboard/stats/stream", server.corsMiddleware(server.statsStreamHandler))
+	mux.HandleFunc("/presence/", server.corsMiddleware(server.presenceHandler)) // Presence: /presence/{channel}
 
 	// Health check
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("OK"))
-	})
+	mux.HandleFunc("/health", server.healthHandler)
+	mux.HandleFunc("/publish", server.publishHandler)  // NDJSON batch
+	mux.HandleFunc("/publish/", server.publishHandler) // Single message: /publish/{channel}
+
+	// Admin API
+	mux.HandleFunc("/admin/connections", server.adminHandler)
+	mux.HandleFunc("/admin/connections/", server.adminHandler) // Single connection: /admin/connections/{id}
+
+	// Prometheus scrape endpoint
+	mux.HandleFunc("/metrics", server.metricsHandler)
 
 	server.logger.Info("🚀 Go SSE/WebSocket Server starting on port %s", port)
 	server.logger.Info("📡 SSE endpoint: http://localhost%s/dashboard/stream", port)
 	server.logger.Info("🔌 WebSocket endpoint: ws://localhost%s/cable", port)
 	server.logger.Info("🔍 Debug endpoint: http://localhost%s/dashboard/debug", port)
 	server.logger.Info("📊 Stats endpoint: http://localhost%s/dashboard/stats", port)
+	server.logger.Info("📊 Live stats stream: http://localhost%s/dashboard/stats/stream", port)
+	server.logger.Info("👥 Presence endpoint: http://localhost%s/presence/{channel}", port)
+	server.logger.Info("📈 Metrics endpoint: http://localhost%s/metrics", port)
+	if len(server.publishTokens) > 0 {
+		server.logger.Info("📮 Publish endpoint: http://localhost%s/publish/{channel}", port)
+	} else {
+		server.logger.Info("📮 Publish endpoint disabled (set PUBLISH_TOKEN to enable)")
+	}
+	if len(server.adminTokens) > 0 {
+		server.logger.Info("🔨 Admin endpoint: http://localhost%s/admin/connections", port)
+	} else {
+		server.logger.Info("🔨 Admin endpoint disabled (set ADMIN_TOKEN to enable)")
+	}
 	server.logger.Info("📝 Log level: %s", strings.ToUpper(logLevel))
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Supervise the broker connection
+	go server.supervisor.Run(ctx)
+
+	// Sample counters for the live stats stream
+	go server.sampler.Run(ctx)
+
+	// Keep this instance's presence from expiring
+	go server.presence.Run(ctx)
+
 	// Start periodic stats logging
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -853,13 +1562,29 @@ func main() {
 		}
 	}()
 
+	httpServer := &http.Server{
+		Addr:    port,
+		Handler: mux,
+	}
+	shutdownTimeout := time.Duration(getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second
+	drainStagger := time.Duration(getEnvInt("SHUTDOWN_STAGGER_SECONDS", 10)) * time.Second
+	if drainStagger > shutdownTimeout {
+		drainStagger = shutdownTimeout
+	}
+
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	shutdownComplete := make(chan struct{})
 	go func() {
 		<-sigChan
 		server.logger.Info("🛑 Received shutdown signal, starting graceful shutdown...")
 
+		// Drain connections while the broker is still delivering
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		server.Shutdown(drainCtx, httpServer, drainStagger)
+		drainCancel()
+
 		// Log final statistics
 		totalSSE, currentSSE, totalWS, currentWS, sseMsgs, wsMsgs, redisMsgs, uptime := server.stats.GetStats()
 		server.logger.Info("📊 FINAL STATS:")
@@ -884,18 +1609,17 @@ func main() {
 
 		// Cancel context to stop background goroutines
 		cancel()
-
-		// Give some time for graceful shutdown
-		time.Sleep(2 * time.Second)
-
-		server.logger.Info("👋 Server shutdown complete")
-		os.Exit(0)
+		server.hub.Close()
+		server.broker.Close()
+		close(shutdownComplete)
 	}()
 
 	// Start server
 	server.logger.Info("🌐 Starting HTTP server...")
-	if err := http.ListenAndServe(port, nil); err != nil {
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		server.logger.Error("Server failed to start: %v", err)
 		os.Exit(1)
 	}
+	<-shutdownComplete
+	server.logger.Info("👋 Server shutdown complete")
 }
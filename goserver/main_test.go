@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testOpenChannelsConfig declares the dashboard channel and lets clients
+// stream from any other channel too
+const testOpenChannelsConfig = `{
+	"channels": [{"class": "DashboardUpdatesChannel", "streams": ["dashboard_updates"]}],
+	"stream_authorization": {"undeclared": "allow"}
+}`
+
+// newTestServer builds a server on the in-memory broker where any stream may
+// be subscribed to. env overrides the environment NewServer reads.
+func newTestServer(t *testing.T, env map[string]string) *Server {
+	t.Helper()
+	config := t.TempDir() + "/channels.json"
+	if err := os.WriteFile(config, []byte(testOpenChannelsConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BROKER", BrokerMemory)
+	t.Setenv("CHANNELS_CONFIG", config)
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	s := NewServer("error")
+	t.Cleanup(func() {
+		s.hub.Close()
+		s.broker.Close()
+	})
+	return s
+}
+
+// testSSEConnection registers an SSE connection subscribed to channels whose
+// frames queue up without being written anywhere
+func testSSEConnection(t *testing.T, s *Server, channels ...string) *SSEConnection {
+	t.Helper()
+	conn := &SSEConnection{
+		ID:            s.generateConnectionID(),
+		Identity:      &Identity{Subject: "anonymous", Method: AuthNone},
+		ConnectedAt:   time.Now(),
+		Subscriptions: make(map[string]bool),
+		Queue:         NewSendQueue(s.sseQueueSize, s.ssePolicy),
+		Done:          make(chan struct{}),
+		drain:         make(chan struct{}),
+		disconnect:    make(chan struct{}),
+	}
+	for _, channel := range channels {
+		conn.Subscriptions[channel] = true
+	}
+	s.addSSEConnection(conn)
+	for _, channel := range channels {
+		s.hub.Acquire(channel)
+	}
+	t.Cleanup(func() {
+		s.removeSSEConnection(conn.ID)
+		for _, channel := range channels {
+			s.hub.Release(channel)
+		}
+	})
+	return conn
+}
+
+// waitForFrames waits until n frames are queued on a connection and returns
+// their data, oldest first
+func waitForFrames(t *testing.T, conn *SSEConnection, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for conn.Queue.Len() < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	var frames []string
+	for {
+		frame, ok := conn.Queue.Pop()
+		if !ok {
+			break
+		}
+		frames = append(frames, string(frame.Data))
+	}
+	if len(frames) != n {
+		t.Fatalf("connection %s received %d frames %q, want %d", conn.ID, len(frames), frames, n)
+	}
+	return frames
+}
+
+// sseFrame is one event read from an SSE stream
+type sseFrame struct {
+	Event, ID, Data, Retry string
+}
+
+// sseStream reads the events of an open SSE response
+type sseStream struct {
+	frames chan sseFrame
+}
+
+// openSSEStream connects to the server's stream handler at path, returning
+// once the stream is open and its channels are registered with the hub
+func openSSEStream(t *testing.T, s *Server, path string, header http.Header) *sseStream {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(s.streamHandler))
+	t.Cleanup(backend.Close)
+
+	r, err := http.NewRequest(http.MethodGet, backend.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	resp, err := backend.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s = %s", path, resp.Status)
+	}
+
+	stream := &sseStream{frames: make(chan sseFrame, 64)}
+	go func() {
+		defer close(stream.frames)
+		scanner := bufio.NewScanner(resp.Body)
+		var frame sseFrame
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				if frame != (sseFrame{}) {
+					stream.frames <- frame
+				}
+				frame = sseFrame{}
+				continue
+			}
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "event":
+				frame.Event = value
+			case "id":
+				frame.ID = value
+			case "data":
+				frame.Data = value
+			case "retry":
+				frame.Retry = value
+			}
+		}
+	}()
+	return stream
+}
+
+// Next waits for the next event on the stream
+func (s *sseStream) Next(t *testing.T) sseFrame {
+	t.Helper()
+	select {
+	case frame, ok := <-s.frames:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return frame
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event within 2s")
+	}
+	return sseFrame{}
+}
+
+// Data reads the next n events and returns their data
+func (s *sseStream) Data(t *testing.T, n int) []string {
+	t.Helper()
+	var data []string
+	for i := 0; i < n; i++ {
+		data = append(data, s.Next(t).Data)
+	}
+	return data
+}
+
+func TestGenerateConnectionIDUnique(t *testing.T) {
+	s := &Server{}
+	ids := make(chan string, 8000)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				ids <- s.generateConnectionID()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("connection ID %s generated twice", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Histogram buckets
+var (
+	queueDepthBuckets     = []float64{0, 1, 2, 4, 8, 16, 32, 64, 128, 256}
+	fanoutLatencyBuckets  = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	labelValueReplacer    = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	metricsContentType    = "text/plain; version=0.0.4; charset=utf-8"
+	metricsLabelSeparator = "\xff"
+)
+
+// CounterVec is a Prometheus counter partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+	mu     sync.Mutex
+}
+
+// NewCounterVec creates a counter with the given label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// Inc adds one to the counter for the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter for the label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, metricsLabelSeparator)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+// write renders the counter in text exposition format
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, splitKey(key)), formatValue(c.values[key]))
+	}
+}
+
+// histogram holds the observations for one set of label values
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a Prometheus histogram partitioned by label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string]*histogram
+	mu      sync.Mutex
+}
+
+// NewHistogramVec creates a histogram with the given upper bounds and label names
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+}
+
+// Observe records a value for the label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, metricsLabelSeparator)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.sum += v
+	hist.count++
+}
+
+// write renders the histogram in text exposition format
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hist := h.values[key]
+		labelValues := splitKey(key)
+		labels := append(append([]string{}, h.labels...), "le")
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, append(labelValues, formatValue(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, append(labelValues, "+Inf")), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, labelValues), formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, labelValues), hist.count)
+	}
+}
+
+// Metrics holds the Prometheus metrics that are not derived from ServerStats
+type Metrics struct {
+	BrokerMessages *CounterVec
+	BrokerErrors   *CounterVec
+	QueueDepth     *HistogramVec
+	FanoutLatency  *HistogramVec
+	Published      *CounterVec
+}
+
+// metricsOtherChannel labels streams no channel in the registry declares
+const metricsOtherChannel = "other"
+
+// NewMetrics creates the server's metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		BrokerMessages: NewCounterVec("goserver_broker_messages_received_total",
+			"Messages received from the pub/sub broker, by declared stream template.", "backend", "channel"),
+		BrokerErrors: NewCounterVec("goserver_broker_errors_total",
+			"Pub/sub broker errors by kind.", "backend", "kind"),
+		QueueDepth: NewHistogramVec("goserver_send_queue_depth",
+			"Per-connection send queue depth observed after each enqueue.", queueDepthBuckets, "protocol"),
+		FanoutLatency: NewHistogramVec("goserver_fanout_latency_seconds",
+			"Time from broker receipt to the message being written to a client.", fanoutLatencyBuckets, "protocol"),
+		Published: NewCounterVec("goserver_published_messages_total",
+			"Messages accepted by the HTTP publish API, by declared stream template.", "channel"),
+	}
+}
+
+// metricsHandler serves all metrics in Prometheus text exposition format
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	buf := bufio.NewWriter(w)
+	defer buf.Flush()
+
+	totalSSE, currentSSE, totalWS, currentWS, sseMsgs, wsMsgs, redisMsgs, uptime := s.stats.GetStats()
+	sseDropped, sseEvicted := s.stats.GetSSEBackpressureStats()
+	sseConflated, wsConflated := s.stats.GetConflatedStats()
+	status := s.supervisor.Status()
+
+	writeGauge(buf, "goserver_uptime_seconds", "Seconds since the server started.", uptime.Seconds())
+
+	writeHeader(buf, "goserver_connections", "Currently open connections.", "gauge")
+	fmt.Fprintf(buf, "goserver_connections{protocol=\"sse\"} %d\n", currentSSE)
+	fmt.Fprintf(buf, "goserver_connections{protocol=\"websocket\"} %d\n", currentWS)
+
+	writeHeader(buf, "goserver_connections_total", "Connections accepted since start.", "counter")
+	fmt.Fprintf(buf, "goserver_connections_total{protocol=\"sse\"} %d\n", totalSSE)
+	fmt.Fprintf(buf, "goserver_connections_total{protocol=\"websocket\"} %d\n", totalWS)
+
+	writeHeader(buf, "goserver_channel_connections", "Currently open connections subscribed to each declared stream template.", "gauge")
+	for _, row := range s.channelConnectionCounts() {
+		fmt.Fprintf(buf, "goserver_channel_connections%s %d\n",
+			formatLabels([]string{"protocol", "channel"}, []string{row.protocol, row.channel}), row.count)
+	}
+
+	writeHeader(buf, "goserver_messages_sent_total", "Messages written to clients.", "counter")
+	fmt.Fprintf(buf, "goserver_messages_sent_total{protocol=\"sse\"} %d\n", sseMsgs)
+	fmt.Fprintf(buf, "goserver_messages_sent_total{protocol=\"websocket\"} %d\n", wsMsgs)
+
+	writeHeader(buf, "goserver_redis_messages_received_total", "Messages received from the broker on all channels.", "counter")
+	fmt.Fprintf(buf, "goserver_redis_messages_received_total %d\n", redisMsgs)
+
+	writeHeader(buf, "goserver_sse_dropped_messages_total", "SSE messages discarded because a client fell behind.", "counter")
+	fmt.Fprintf(buf, "goserver_sse_dropped_messages_total %d\n", sseDropped)
+
+	writeHeader(buf, "goserver_sse_evicted_connections_total", "SSE clients evicted for falling behind.", "counter")
+	fmt.Fprintf(buf, "goserver_sse_evicted_connections_total %d\n", sseEvicted)
+
+	writeHeader(buf, "goserver_conflated_messages_total", "Updates replaced by a newer one before a throttled client was sent them.", "counter")
+	fmt.Fprintf(buf, "goserver_conflated_messages_total{protocol=\"sse\"} %d\n", sseConflated)
+	fmt.Fprintf(buf, "goserver_conflated_messages_total{protocol=\"websocket\"} %d\n", wsConflated)
+
+	writeHeader(buf, "goserver_broker_up", "Whether the pub/sub broker is connected.", "gauge")
+	up := 0
+	if status.State == BrokerStateConnected {
+		up = 1
+	}
+	fmt.Fprintf(buf, "goserver_broker_up%s %d\n", formatLabels([]string{"backend"}, []string{status.Backend}), up)
+
+	s.metrics.BrokerMessages.write(buf)
+	s.metrics.BrokerErrors.write(buf)
+	s.metrics.QueueDepth.write(buf)
+	s.metrics.FanoutLatency.write(buf)
+	s.metrics.Published.write(buf)
+}
+
+// channelConnectionCount is one row of the per-channel connection gauge
+type channelConnectionCount struct {
+	protocol string
+	channel  string
+	count    int
+}
+
+// channelLabel returns the metrics label for a stream: its declared stream
+// template, or "other", so clients and publishers cannot grow the label set
+func (s *Server) channelLabel(stream string) string {
+	if template, ok := s.channels.Template(stream); ok {
+		return template
+	}
+	return metricsOtherChannel
+}
+
+// channelConnectionCounts counts connections per protocol and stream
+// template; a connection receiving several streams of a template counts once
+func (s *Server) channelConnectionCounts() []channelConnectionCount {
+	counts := make(map[[2]string]int)
+
+	s.sseMutex.RLock()
+	for _, conn := range s.sseConnections {
+		labels := make(map[string]bool)
+		for channel := range conn.Subscriptions {
+			labels[s.channelLabel(channel)] = true
+		}
+		for label := range labels {
+			counts[[2]string{"sse", label}]++
+		}
+	}
+	s.sseMutex.RUnlock()
+
+	s.wsMutex.RLock()
+	for _, conn := range s.wsConnections {
+		labels := make(map[string]bool)
+		conn.mu.RLock()
+		for _, sub := range conn.Subscriptions {
+			for _, stream := range sub.Streams {
+				labels[s.channelLabel(stream)] = true
+			}
+		}
+		conn.mu.RUnlock()
+		for label := range labels {
+			counts[[2]string{"websocket", label}]++
+		}
+	}
+	s.wsMutex.RUnlock()
+
+	rows := make([]channelConnectionCount, 0, len(counts))
+	for key, count := range counts {
+		rows = append(rows, channelConnectionCount{protocol: key[0], channel: key[1], count: count})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].protocol != rows[j].protocol {
+			return rows[i].protocol < rows[j].protocol
+		}
+		return rows[i].channel < rows[j].channel
+	})
+	return rows
+}
+
+// observeQueueDepth records a connection's queue depth after an enqueue
+func (s *Server) observeQueueDepth(protocol string, queue *SendQueue) {
+	s.metrics.QueueDepth.Observe(float64(queue.Len()), protocol)
+}
+
+// observeFanoutLatency records how long a data frame took from broker to client
+func (s *Server) observeFanoutLatency(protocol string, frame *OutboundFrame) {
+	if frame.Control || frame.ReceivedAt.IsZero() {
+		return
+	}
+	s.metrics.FanoutLatency.Observe(time.Since(frame.ReceivedAt).Seconds(), protocol)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeGauge(w io.Writer, name, help string, v float64) {
+	writeHeader(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatValue(v))
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelValueReplacer.Replace(value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func splitKey(key string) []string {
+	return strings.Split(key, metricsLabelSeparator)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
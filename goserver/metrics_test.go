@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	messages := NewCounterVec("test_messages_total", "Messages by backend and channel.", "backend", "channel")
+	messages.Inc("redis", "dash\"board\n")
+	messages.Add(2, "redis", `a\b`)
+	messages.Add(0.5, "redis", `a\b`)
+
+	latency := NewHistogramVec("test_latency_seconds", "Fan-out latency.", []float64{0.125, 1}, "protocol")
+	for _, v := range []float64{0.0625, 0.125, 0.5, 3} {
+		latency.Observe(v, "sse")
+	}
+	latency.Observe(1, "websocket")
+
+	empty := NewCounterVec("test_empty_total", "Nothing counted yet.", "kind")
+
+	var buf bytes.Buffer
+	writeGauge(&buf, "test_uptime_seconds", "Seconds since start.", 12.5)
+	messages.write(&buf)
+	latency.write(&buf)
+	empty.write(&buf)
+
+	want := `# HELP test_uptime_seconds Seconds since start.
+# TYPE test_uptime_seconds gauge
+test_uptime_seconds 12.5
+# HELP test_messages_total Messages by backend and channel.
+# TYPE test_messages_total counter
+test_messages_total{backend="redis",channel="a\\b"} 2.5
+test_messages_total{backend="redis",channel="dash\"board\n"} 1
+# HELP test_latency_seconds Fan-out latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{protocol="sse",le="0.125"} 2
+test_latency_seconds_bucket{protocol="sse",le="1"} 3
+test_latency_seconds_bucket{protocol="sse",le="+Inf"} 4
+test_latency_seconds_sum{protocol="sse"} 3.6875
+test_latency_seconds_count{protocol="sse"} 4
+test_latency_seconds_bucket{protocol="websocket",le="0.125"} 0
+test_latency_seconds_bucket{protocol="websocket",le="1"} 1
+test_latency_seconds_bucket{protocol="websocket",le="+Inf"} 1
+test_latency_seconds_sum{protocol="websocket"} 1
+test_latency_seconds_count{protocol="websocket"} 1
+# HELP test_empty_total Nothing counted yet.
+# TYPE test_empty_total counter
+`
+	if got := buf.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	s := newTestServer(t, nil)
+	testSSEConnection(t, s, "dashboard_updates", "undeclared")
+	s.metrics.Published.Inc(s.channelLabel("dashboard_updates"))
+
+	w := httptest.NewRecorder()
+	s.metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); got != metricsContentType {
+		t.Errorf("Content-Type = %q, want %q", got, metricsContentType)
+	}
+
+	// Every family is described once, before its samples
+	body := w.Body.String()
+	help := make(map[string]int)
+	types := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if name, ok := strings.CutPrefix(line, "# HELP "); ok {
+			name, _, _ = strings.Cut(name, " ")
+			help[name]++
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, kind, _ := strings.Cut(rest, " ")
+			if _, seen := types[name]; seen || help[name] != 1 {
+				t.Errorf("TYPE of %s repeated or without a single HELP before it", name)
+			}
+			types[name] = kind
+			continue
+		}
+		name, _, _ := strings.Cut(line, " ")
+		name, _, _ = strings.Cut(name, "{")
+		family := name
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if trimmed, ok := strings.CutSuffix(name, suffix); ok && types[trimmed] == "histogram" {
+				family = trimmed
+			}
+		}
+		if types[family] == "" {
+			t.Errorf("sample %q has no TYPE before it", line)
+		}
+	}
+
+	for _, sample := range []string{
+		`goserver_connections{protocol="sse"} 1`,
+		`goserver_channel_connections{protocol="sse",channel="dashboard_updates"} 1`,
+		`goserver_channel_connections{protocol="sse",channel="other"} 1`,
+		`goserver_published_messages_total{channel="dashboard_updates"} 1`,
+		`goserver_broker_up{backend="memory"} 1`,
+	} {
+		if !strings.Contains(body, "\n"+sample+"\n") {
+			t.Errorf("metrics missing %s", sample)
+		}
+	}
+	if types["goserver_send_queue_depth"] != "histogram" || types["goserver_broker_errors_total"] != "counter" {
+		t.Errorf("families typed %v", types)
+	}
+}
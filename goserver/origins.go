@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultAllowedOrigins admits the Rails app in development
+const defaultAllowedOrigins = "http://localhost:3000,http://127.0.0.1:3000"
+
+// CORS headers sent to allowed origins
+const (
+	corsAllowMethods = "GET, POST, OPTIONS"
+	corsAllowHeaders = "Content-Type, Cache-Control, Last-Event-ID, Authorization"
+)
+
+// OriginPolicy is the allowlist of browser origins that may open streams,
+// read stats or upgrade to WebSocket
+type OriginPolicy struct {
+	allowAll bool
+	exact    map[string]bool // scheme://host[:port]
+	patterns []originPattern
+}
+
+// originPattern is an allowlist entry without a scheme or with a wildcard
+type originPattern struct {
+	scheme    string // Empty matches any scheme
+	host      string // Host, or the domain after "*." for wildcards
+	subdomain bool   // Match subdomains of host rather than host itself
+}
+
+// NewOriginPolicy parses allowlist entries such as "https://dashboard.example.com",
+// "https://*.example.com" (any subdomain) or "*" (any origin)
+func NewOriginPolicy(entries []string) (*OriginPolicy, error) {
+	policy := &OriginPolicy{exact: make(map[string]bool)}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimRight(strings.TrimSpace(entry), "/"))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			policy.allowAll = true
+			continue
+		}
+
+		scheme, host, found := strings.Cut(entry, "://")
+		if !found {
+			scheme, host = "", entry
+		}
+		if host == "" || strings.ContainsAny(host, "/?#") {
+			return nil, fmt.Errorf("invalid origin %q", entry)
+		}
+
+		if suffix, ok := strings.CutPrefix(host, "*."); ok {
+			if suffix == "" || strings.Contains(suffix, "*") {
+				return nil, fmt.Errorf("invalid origin %q", entry)
+			}
+			policy.patterns = append(policy.patterns, originPattern{scheme: scheme, host: suffix, subdomain: true})
+			continue
+		}
+		if strings.Contains(host, "*") {
+			return nil, fmt.Errorf("invalid origin %q: wildcards are only allowed as a leading subdomain", entry)
+		}
+		if scheme == "" {
+			policy.patterns = append(policy.patterns, originPattern{host: host})
+			continue
+		}
+		policy.exact[scheme+"://"+host] = true
+	}
+	return policy, nil
+}
+
+// NewOriginPolicyFromEnv reads the comma-separated ALLOWED_ORIGINS
+func NewOriginPolicyFromEnv(logger *Logger) (*OriginPolicy, error) {
+	value := os.Getenv("ALLOWED_ORIGINS")
+	if value == "" {
+		value = defaultAllowedOrigins
+	}
+	policy, err := NewOriginPolicy(strings.Split(value, ","))
+	if err != nil {
+		return nil, err
+	}
+	if policy.allowAll {
+		logger.Warn("⚠️ ALLOWED_ORIGINS=* accepts credentialed requests from any site")
+	} else {
+		logger.Info("🌍 Allowed origins: %s", value)
+	}
+	return policy, nil
+}
+
+// Allowed reports whether a browser origin is on the allowlist
+func (p *OriginPolicy) Allowed(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	if p.exact[u.Scheme+"://"+u.Host] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pattern.scheme != "" && pattern.scheme != u.Scheme {
+			continue
+		}
+		if pattern.subdomain && strings.HasSuffix(u.Host, "."+pattern.host) {
+			return true
+		}
+		if !pattern.subdomain && u.Host == pattern.host {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRequest allows requests without an Origin header (non-browser
+// clients), same-origin requests and allowlisted origins. It is the
+// WebSocket upgrader's CheckOrigin.
+func (p *OriginPolicy) CheckRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return p.Allowed(origin)
+}
+
+// corsMiddleware applies the origin allowlist: allowed origins are echoed
+// back with credentials enabled, others are refused with 403
+func (s *Server) corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !s.origins.CheckRequest(r) {
+				s.logger.Warn("🚫 Refused request to %s from origin %s", r.URL.Path, origin)
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		}
+
+		// Handle preflight requests
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOriginPolicyAllowed(t *testing.T) {
+	policy, err := NewOriginPolicy([]string{
+		" https://dashboard.example.com/ ",
+		"https://*.apps.example.com",
+		"*.internal.test",
+		"localhost:3000",
+		"http://127.0.0.1:3000",
+		"",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		// Exact entries need the same scheme, host and port
+		{"https://dashboard.example.com", true},
+		{"HTTPS://Dashboard.Example.COM", true},
+		{"http://dashboard.example.com", false},
+		{"https://dashboard.example.com:8443", false},
+		{"https://evil-dashboard.example.com", false},
+		{"https://dashboard.example.com.evil.test", false},
+		{"http://127.0.0.1:3000", true},
+		{"http://127.0.0.1:3001", false},
+
+		// Wildcards match any depth of subdomain, but not the domain itself
+		{"https://team.apps.example.com", true},
+		{"https://a.b.apps.example.com", true},
+		{"https://Team.APPS.example.com", true},
+		{"https://apps.example.com", false},
+		{"http://team.apps.example.com", false},
+		{"https://teamapps.example.com", false},
+		{"https://team.apps.example.com.evil.test", false},
+
+		// Entries without a scheme accept any scheme
+		{"http://ci.internal.test", true},
+		{"https://ci.internal.test", true},
+		{"https://internal.test", false},
+		{"http://localhost:3000", true},
+		{"https://localhost:3000", true},
+		{"http://localhost:4000", false},
+
+		// Malformed or opaque origins
+		{"null", false},
+		{"dashboard.example.com", false},
+		{"https://", false},
+		{"://dashboard.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := policy.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	all, err := NewOriginPolicy([]string{"https://dashboard.example.com", "*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !all.Allowed("https://anything.test") || !all.Allowed("null") {
+		t.Error("* did not allow every origin")
+	}
+}
+
+func TestNewOriginPolicyErrors(t *testing.T) {
+	for _, entry := range []string{
+		"https://example.com#fragment",
+		"https://example.com/path",
+		"https://example.com?query",
+		"https://*.",
+		"https://*.*.example.com",
+		"https://app.*.example.com",
+		"https://app*.example.com",
+	} {
+		if _, err := NewOriginPolicy([]string{entry}); err == nil {
+			t.Errorf("NewOriginPolicy accepted %q", entry)
+		}
+	}
+}
+
+func TestOriginPolicyCheckRequest(t *testing.T) {
+	policy, err := NewOriginPolicy([]string{"https://dashboard.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, host, origin string
+		want               bool
+	}{
+		{"no origin", "stream.example.com", "", true},
+		{"same origin", "stream.example.com", "https://stream.example.com", true},
+		{"same origin, other case", "Stream.Example.com", "https://stream.EXAMPLE.com", true},
+		{"same origin with port", "localhost:3001", "http://localhost:3001", true},
+		{"other port", "localhost:3001", "http://localhost:3000", false},
+		{"allowlisted", "stream.example.com", "https://dashboard.example.com", true},
+		{"refused", "stream.example.com", "https://evil.test", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/cable", nil)
+		r.Host = tt.host
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := policy.CheckRequest(r); got != tt.want {
+			t.Errorf("%s: CheckRequest = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	s := newTestServer(t, map[string]string{"ALLOWED_ORIGINS": "https://dashboard.example.com,https://*.example.org"})
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		status     int
+		allowed    bool // Access-Control headers sent
+		nextCalled bool
+	}{
+		{"preflight", http.MethodOptions, "https://dashboard.example.com", http.StatusNoContent, true, false},
+		{"preflight, other case", http.MethodOptions, "https://DASHBOARD.example.com", http.StatusNoContent, true, false},
+		{"preflight from a subdomain", http.MethodOptions, "https://team.example.org", http.StatusNoContent, true, false},
+		{"preflight refused", http.MethodOptions, "https://evil.test", http.StatusForbidden, false, false},
+		{"allowed request", http.MethodGet, "https://team.example.org", http.StatusOK, true, true},
+		{"refused request", http.MethodGet, "https://example.org.evil.test", http.StatusForbidden, false, false},
+		{"no origin", http.MethodGet, "", http.StatusOK, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := s.corsMiddleware(func(w http.ResponseWriter, r *http.Request) { called = true })
+			r := httptest.NewRequest(tt.method, "/dashboard/stats", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.status || called != tt.nextCalled {
+				t.Errorf("status = %d, handler called %v, want %d, %v", w.Code, called, tt.status, tt.nextCalled)
+			}
+			header := w.Header()
+			if header.Get("Vary") != "Origin" {
+				t.Errorf("Vary = %q, want Origin", header.Get("Vary"))
+			}
+			if !tt.allowed {
+				if got := header.Get("Access-Control-Allow-Origin"); got != "" {
+					t.Errorf("Access-Control-Allow-Origin = %q for a refused origin", got)
+				}
+				return
+			}
+			if header.Get("Access-Control-Allow-Origin") != tt.origin || header.Get("Access-Control-Allow-Credentials") != "true" {
+				t.Errorf("CORS headers %v, want %s echoed with credentials", header, tt.origin)
+			}
+			if !strings.Contains(header.Get("Access-Control-Allow-Headers"), "Last-Event-ID") {
+				t.Errorf("Access-Control-Allow-Headers = %q, want Last-Event-ID allowed", header.Get("Access-Control-Allow-Headers"))
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream authorization defaults
+const (
+	PolicyDefaultAllow = "allow"
+	PolicyDefaultDeny  = "deny"
+
+	defaultRolesClaim          = "roles"
+	defaultCallbackTimeout     = 2 * time.Second
+	defaultCallbackCacheTTL    = 30 * time.Second
+	maxCallbackCacheEntries    = 10000
+	authorizationCallbackAgent = "goserver-authz/1.0"
+)
+
+// StreamAuthorizationConfig is the on-disk stream policy format, kept under
+// "stream_authorization" in the channel registry config
+type StreamAuthorizationConfig struct {
+	Default    string                 `json:"default"`     // allow or deny streams no policy matches
+	Undeclared string                 `json:"undeclared"`  // allow or deny streams no channel declares; deny unless set
+	RolesClaim string                 `json:"roles_claim"` // Claim holding the caller's roles
+	Policies   []*StreamPolicy        `json:"policies"`
+	Callback   *AuthorizationCallback `json:"callback"`
+}
+
+// StreamPolicy restricts the streams matching a pattern. Every matching
+// policy must pass.
+type StreamPolicy struct {
+	Streams  string            `json:"streams"`  // Pattern with * wildcards and {name} captures, e.g. "dashboard_updates:{team_id}"
+	Roles    []string          `json:"roles"`    // Caller needs at least one of these roles
+	Claims   map[string]string `json:"claims"`   // Claim path -> required value, which may use the pattern's captures
+	Callback bool              `json:"callback"` // Also ask the authorization callback
+	pattern  *regexp.Regexp
+}
+
+// AuthorizationCallback delegates decisions to the Rails app: it is POSTed
+// the caller and stream and answers 2xx to allow, anything else to deny
+type AuthorizationCallback struct {
+	URL          string `json:"url"`
+	TimeoutMS    int    `json:"timeout_ms"`
+	CacheSeconds *int   `json:"cache_seconds"`
+}
+
+// Authorizer decides whether an identity may receive a stream
+type Authorizer struct {
+	config     StreamAuthorizationConfig
+	declared   func(stream string) error // Set by the channel registry
+	client     *http.Client
+	cacheTTL   time.Duration
+	cache      map[string]authorizationDecision
+	cacheSwept time.Time
+	cacheMu    sync.Mutex
+	now        func() time.Time // Clock for cached answers, replaced in tests
+}
+
+// authorizationDecision is a cached callback answer
+type authorizationDecision struct {
+	err     error
+	expires time.Time
+}
+
+// policyCapturePattern matches {name} captures in stream patterns
+var policyCapturePattern = regexp.MustCompile(`\\\{([A-Za-z0-9_]+)\\\}|\\\*`)
+
+// NewAuthorizer compiles a stream authorization config; nil allows everything
+func NewAuthorizer(config *StreamAuthorizationConfig) (*Authorizer, error) {
+	a := &Authorizer{cache: make(map[string]authorizationDecision), now: time.Now}
+	if config != nil {
+		a.config = *config
+	}
+
+	switch a.config.Default {
+	case "":
+		a.config.Default = PolicyDefaultAllow
+	case PolicyDefaultAllow, PolicyDefaultDeny:
+	default:
+		return nil, fmt.Errorf("unknown default %q", a.config.Default)
+	}
+	switch a.config.Undeclared {
+	case "":
+		a.config.Undeclared = PolicyDefaultDeny
+	case PolicyDefaultAllow, PolicyDefaultDeny:
+	default:
+		return nil, fmt.Errorf("unknown undeclared %q", a.config.Undeclared)
+	}
+	if a.config.RolesClaim == "" {
+		a.config.RolesClaim = defaultRolesClaim
+	}
+
+	for i, policy := range a.config.Policies {
+		if policy.Streams == "" {
+			return nil, fmt.Errorf("policy %d has no streams pattern", i)
+		}
+		if policy.Callback && (a.config.Callback == nil || a.config.Callback.URL == "") {
+			return nil, fmt.Errorf("policy %s uses the callback but no callback url is configured", policy.Streams)
+		}
+		policy.pattern = compileStreamPattern(policy.Streams)
+	}
+
+	if callback := a.config.Callback; callback != nil && callback.URL != "" {
+		timeout := defaultCallbackTimeout
+		if callback.TimeoutMS > 0 {
+			timeout = time.Duration(callback.TimeoutMS) * time.Millisecond
+		}
+		a.client = &http.Client{Timeout: timeout}
+		a.cacheTTL = defaultCallbackCacheTTL
+		if callback.CacheSeconds != nil {
+			a.cacheTTL = time.Duration(*callback.CacheSeconds) * time.Second
+		}
+	}
+	return a, nil
+}
+
+// compileStreamPattern turns a stream pattern into an anchored regexp whose
+// named groups are the pattern's captures
+func compileStreamPattern(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	expr := policyCapturePattern.ReplaceAllStringFunc(quoted, func(token string) string {
+		if token == `\*` {
+			return ".*"
+		}
+		name := token[2 : len(token)-2]
+		return "(?P<" + name + ">[^:]+)"
+	})
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+// Authorize checks that the stream is declared, unless undeclared streams
+// are allowed, and every policy matching it, returning why access was
+// refused. A presence stream is held to the policies of the stream it
+// reports on, since its events name who is watching that stream.
+func (a *Authorizer) Authorize(ctx context.Context, identity *Identity, channelClass, stream string) error {
+	if a.declared != nil && a.config.Undeclared == PolicyDefaultDeny {
+		if err := a.declared(stream); err != nil {
+			return err
+		}
+	}
+	stream = strings.TrimSuffix(stream, presenceStreamSuffix)
+
+	matched := false
+	for _, policy := range a.config.Policies {
+		captures := policy.match(stream)
+		if captures == nil {
+			continue
+		}
+		matched = true
+
+		if len(policy.Roles) > 0 && !a.hasRole(identity, policy.Roles) {
+			return fmt.Errorf("stream %s requires role %s", stream, strings.Join(policy.Roles, " or "))
+		}
+		for path, template := range policy.Claims {
+			expected := streamParamPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+				return captures[placeholder[1:len(placeholder)-1]]
+			})
+			if !claimMatches(lookupPath(identity.Claims, path), expected) {
+				return fmt.Errorf("stream %s requires claim %s=%s", stream, path, expected)
+			}
+		}
+		if policy.Callback {
+			if err := a.callback(ctx, identity, channelClass, stream); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !matched && a.config.Default == PolicyDefaultDeny {
+		return fmt.Errorf("no policy allows stream %s", stream)
+	}
+	return nil
+}
+
+// match returns the pattern's captures, or nil if the stream does not match
+func (p *StreamPolicy) match(stream string) map[string]string {
+	values := p.pattern.FindStringSubmatch(stream)
+	if values == nil {
+		return nil
+	}
+	captures := make(map[string]string)
+	for i, name := range p.pattern.SubexpNames() {
+		if name != "" {
+			captures[name] = values[i]
+		}
+	}
+	return captures
+}
+
+// hasRole reports whether the identity holds any of the roles
+func (a *Authorizer) hasRole(identity *Identity, roles []string) bool {
+	held := lookupPath(identity.Claims, a.config.RolesClaim)
+	for _, role := range roles {
+		if claimMatches(held, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// callback asks the Rails app, caching answers per subject and stream
+func (a *Authorizer) callback(ctx context.Context, identity *Identity, channelClass, stream string) error {
+	// Anonymous callers share a subject, so their answers are never cached
+	key := identity.Method + "\x00" + identity.Subject + "\x00" + stream
+	cacheable := identity.Method != AuthNone && a.cacheTTL > 0
+	if cacheable {
+		a.cacheMu.Lock()
+		decision, ok := a.cache[key]
+		a.cacheMu.Unlock()
+		if ok && a.now().Before(decision.expires) {
+			return decision.err
+		}
+	}
+
+	err := a.askCallback(ctx, identity, channelClass, stream)
+	if cacheable {
+		a.cacheMu.Lock()
+		a.sweepCache()
+		a.cache[key] = authorizationDecision{err: err, expires: a.now().Add(a.cacheTTL)}
+		a.cacheMu.Unlock()
+	}
+	return err
+}
+
+// sweepCache drops expired answers once per cache TTL, and makes room when
+// the cache is full of live ones. Must be called with cacheMu held.
+func (a *Authorizer) sweepCache() {
+	now := a.now()
+	if len(a.cache) < maxCallbackCacheEntries && now.Sub(a.cacheSwept) < a.cacheTTL {
+		return
+	}
+	a.cacheSwept = now
+	for key, decision := range a.cache {
+		if !now.Before(decision.expires) {
+			delete(a.cache, key)
+		}
+	}
+	for key := range a.cache {
+		if len(a.cache) < maxCallbackCacheEntries {
+			break
+		}
+		delete(a.cache, key)
+	}
+}
+
+// askCallback POSTs the decision request, forwarding the caller's
+// credentials so the Rails app can check its own session
+func (a *Authorizer) askCallback(ctx context.Context, identity *Identity, channelClass, stream string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"subject": identity.Subject,
+		"method":  identity.Method,
+		"claims":  identity.Claims,
+		"channel": channelClass,
+		"stream":  stream,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.config.Callback.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range identity.credentials {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", authorizationCallbackAgent)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("authorization callback failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("authorization callback denied stream %s (%d)", stream, resp.StatusCode)
+	}
+	return nil
+}
+
+// lookupPath resolves a dot-separated path such as "team.id" in decoded JSON
+func lookupPath(object map[string]interface{}, path string) interface{} {
+	var value interface{} = object
+	for _, part := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
+
+// claimMatches reports whether a scalar claim equals expected, or an array
+// claim contains it
+func claimMatches(claim interface{}, expected string) bool {
+	if items, ok := claim.([]interface{}); ok {
+		for _, item := range items {
+			if claimMatches(item, expected) {
+				return true
+			}
+		}
+		return false
+	}
+	value, ok := paramString(claim)
+	return ok && value == expected
+}
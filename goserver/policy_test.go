@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testChannelsConfig mirrors the shipped channels.json
+const testChannelsConfig = `{
+	"channels": [
+		{"class": "DashboardUpdatesChannel", "streams": ["dashboard_updates"]},
+		{
+			"class": "TeamDashboardChannel",
+			"streams": ["dashboard_updates:{team_id}"],
+			"authorization": {"require_params": ["team_id"], "param_patterns": {"team_id": "[0-9]+"}}
+		},
+		{
+			"class": "DeploymentsChannel",
+			"streams": ["deployments", "deployments:{environment}"],
+			"authorization": {"require_params": ["environment"], "param_patterns": {"environment": "production|staging|development"}}
+		}
+	],
+	"stream_authorization": {
+		"default": "allow",
+		"undeclared": "deny",
+		"policies": [
+			{"streams": "dashboard_updates:{team_id}", "claims": {"team_ids": "{team_id}"}},
+			{"streams": "deployments:production", "roles": ["admin", "deployer"]}
+		]
+	}
+}`
+
+// testRegistry builds a channel registry from a JSON config
+func testRegistry(t *testing.T, config string) *ChannelRegistry {
+	t.Helper()
+	var parsed ChannelRegistryConfig
+	if err := json.Unmarshal([]byte(config), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := NewChannelRegistry(&parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+// claimsIdentity builds a token identity with the given claims
+func claimsIdentity(subject string, claims map[string]interface{}) *Identity {
+	return &Identity{Subject: subject, Method: AuthJWT, Claims: claims}
+}
+
+func TestAuthorizePresenceStreams(t *testing.T) {
+	authorizer := testRegistry(t, testChannelsConfig).Authorizer()
+	member := claimsIdentity("u1", map[string]interface{}{"team_ids": []interface{}{7.0}, "roles": []interface{}{"deployer"}})
+
+	tests := []struct {
+		name     string
+		identity *Identity
+		stream   string
+		allowed  bool
+	}{
+		{"anonymous, team presence", anonymousIdentity(), "dashboard_updates:7:presence", false},
+		{"anonymous, production presence", anonymousIdentity(), "deployments:production:presence", false},
+		{"no claims, team presence", claimsIdentity("u2", map[string]interface{}{}), "dashboard_updates:7:presence", false},
+		{"other team, team presence", claimsIdentity("u3", map[string]interface{}{"team_ids": []interface{}{8.0}}), "dashboard_updates:7:presence", false},
+		{"member, team presence", member, "dashboard_updates:7:presence", true},
+		{"deployer, production presence", member, "deployments:production:presence", true},
+		{"anonymous, open presence", anonymousIdentity(), "dashboard_updates:presence", true},
+		{"anonymous, staging presence", anonymousIdentity(), "deployments:staging:presence", true},
+		{"undeclared presence", member, "secrets:presence", false},
+		{"undeclared param presence", member, "dashboard_updates:abc:presence", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizer.Authorize(context.Background(), tt.identity, "", tt.stream)
+			if allowed := err == nil; allowed != tt.allowed {
+				t.Errorf("Authorize(%s) = %v, want allowed %v", tt.stream, err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestAuthorizePolicies(t *testing.T) {
+	registry := testRegistry(t, `{
+		"channels": [
+			{"class": "DashboardUpdatesChannel", "streams": ["dashboard_updates", "dashboard_updates:{team_id}"]},
+			{"class": "OrgChannel", "streams": ["orgs:{org_id}:teams:{team_id}"]},
+			{"class": "ReportsChannel", "streams": ["reports:{kind}"]},
+			{"class": "AdminChannel", "streams": ["admin"]}
+		],
+		"stream_authorization": {
+			"default": "allow",
+			"roles_claim": "app.roles",
+			"policies": [
+				{"streams": "dashboard_updates:{team_id}", "claims": {"team_ids": "{team_id}"}},
+				{"streams": "orgs:{org_id}:teams:{team_id}", "claims": {"org.id": "{org_id}", "team_ids": "{team_id}"}},
+				{"streams": "reports:*", "roles": ["analyst", "admin"]},
+				{"streams": "reports:finance", "roles": ["finance"]},
+				{"streams": "admin", "roles": ["admin"]}
+			]
+		}
+	}`)
+	authorizer := registry.Authorizer()
+
+	analyst := claimsIdentity("a", map[string]interface{}{"app": map[string]interface{}{"roles": []interface{}{"analyst"}}})
+	financeAnalyst := claimsIdentity("f", map[string]interface{}{"app": map[string]interface{}{"roles": []interface{}{"analyst", "finance"}}})
+	admin := claimsIdentity("r", map[string]interface{}{"app": map[string]interface{}{"roles": "admin"}})
+	member := claimsIdentity("m", map[string]interface{}{"team_ids": []interface{}{7.0, "9"}, "org": map[string]interface{}{"id": 3.0}})
+	topLevelRoles := claimsIdentity("t", map[string]interface{}{"roles": []interface{}{"admin"}})
+
+	tests := []struct {
+		name     string
+		identity *Identity
+		stream   string
+		allowed  bool
+	}{
+		// Roles, read from the configured roles claim
+		{"role held", admin, "admin", true},
+		{"role missing", analyst, "admin", false},
+		{"role under another claim", topLevelRoles, "admin", false},
+		{"anonymous needs a role", anonymousIdentity(), "admin", false},
+
+		// Claims with placeholders filled from the pattern's captures
+		{"numeric claim in array", member, "dashboard_updates:7", true},
+		{"string claim in array", member, "dashboard_updates:9", true},
+		{"claim for another team", member, "dashboard_updates:8", false},
+		{"claim missing", analyst, "dashboard_updates:7", false},
+		{"two captures", member, "orgs:3:teams:7", true},
+		{"two captures, wrong org", member, "orgs:4:teams:7", false},
+		{"two captures, wrong team", member, "orgs:3:teams:8", false},
+
+		// * patterns, with every matching policy required to pass
+		{"wildcard role", analyst, "reports:weekly", true},
+		{"wildcard role missing", member, "reports:weekly", false},
+		{"wildcard and exact both pass", financeAnalyst, "reports:finance", true},
+		{"wildcard passes, exact fails", analyst, "reports:finance", false},
+
+		// Default allow for streams no policy names
+		{"no policy", anonymousIdentity(), "dashboard_updates", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizer.Authorize(context.Background(), tt.identity, "", tt.stream)
+			if allowed := err == nil; allowed != tt.allowed {
+				t.Errorf("Authorize(%s) = %v, want allowed %v", tt.stream, err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestAuthorizeDefaultAndUndeclared(t *testing.T) {
+	config := func(def, undeclared string) string {
+		return `{
+			"channels": [{"class": "TeamChannel", "streams": ["teams:{team_id}", "lobby"], "authorization": {"param_patterns": {"team_id": "[0-9]+"}}}],
+			"stream_authorization": {"default": "` + def + `", "undeclared": "` + undeclared + `",
+				"policies": [{"streams": "teams:*"}]}
+		}`
+	}
+	tests := []struct {
+		name       string
+		def        string
+		undeclared string
+		stream     string
+		allowed    bool
+	}{
+		{"declared, policy", "deny", "", "teams:1", true},
+		{"declared, no policy, default deny", "deny", "", "lobby", false},
+		{"declared, no policy, default allow", "allow", "", "lobby", true},
+		{"undeclared denied by default", "allow", "", "secrets", false},
+		{"param pattern refused", "allow", "", "teams:abc", false},
+		{"undeclared allowed", "allow", "allow", "secrets", true},
+		{"undeclared allowed, default deny", "deny", "allow", "secrets", false},
+		{"undeclared allowed, policy", "deny", "allow", "teams:abc", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorizer := testRegistry(t, config(tt.def, tt.undeclared)).Authorizer()
+			err := authorizer.Authorize(context.Background(), anonymousIdentity(), "", tt.stream)
+			if allowed := err == nil; allowed != tt.allowed {
+				t.Errorf("Authorize(%s) = %v, want allowed %v", tt.stream, err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestNewAuthorizerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config StreamAuthorizationConfig
+	}{
+		{"unknown default", StreamAuthorizationConfig{Default: "maybe"}},
+		{"unknown undeclared", StreamAuthorizationConfig{Undeclared: "maybe"}},
+		{"policy without streams", StreamAuthorizationConfig{Policies: []*StreamPolicy{{Roles: []string{"admin"}}}}},
+		{"callback without url", StreamAuthorizationConfig{Policies: []*StreamPolicy{{Streams: "a", Callback: true}}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewAuthorizer(&tt.config); err == nil {
+			t.Errorf("%s: NewAuthorizer accepted the config", tt.name)
+		}
+	}
+}
+
+func TestCompileStreamPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		stream  string
+		match   bool
+	}{
+		{"dashboard_updates", "dashboard_updates", true},
+		{"dashboard_updates", "dashboard_updates:1", false},
+		{"dashboard_updates:{team_id}", "dashboard_updates:42", true},
+		{"dashboard_updates:{team_id}", "dashboard_updates:4:2", false},
+		{"dashboard_updates:{team_id}", "dashboard_updates:", false},
+		{"reports:*", "reports:a:b", true},
+		{"reports:*", "reports", false},
+		{"a.b", "axb", false},
+		{"*", "anything:at:all", true},
+	}
+	for _, tt := range tests {
+		if got := compileStreamPattern(tt.pattern).MatchString(tt.stream); got != tt.match {
+			t.Errorf("pattern %q on %q = %v, want %v", tt.pattern, tt.stream, got, tt.match)
+		}
+	}
+}
+
+// callbackAuthorizer builds an authorizer whose callback policy covers every
+// stream, answering from the handler
+func callbackAuthorizer(t *testing.T, handler http.HandlerFunc, cacheSeconds int) *Authorizer {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	authorizer, err := NewAuthorizer(&StreamAuthorizationConfig{
+		Policies: []*StreamPolicy{{Streams: "*", Callback: true}},
+		Callback: &AuthorizationCallback{URL: server.URL, CacheSeconds: &cacheSeconds},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authorizer
+}
+
+func TestAuthorizeCallback(t *testing.T) {
+	var requests []map[string]interface{}
+	authorizer := callbackAuthorizer(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, body)
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("credentials not forwarded: %q", r.Header.Get("Authorization"))
+		}
+		if body["stream"] == "denied" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}, 0)
+
+	identity := claimsIdentity("u1", map[string]interface{}{})
+	identity.credentials = http.Header{"Authorization": {"Bearer token"}}
+	if err := authorizer.Authorize(context.Background(), identity, "AlertsChannel", "allowed"); err != nil {
+		t.Errorf("Authorize(allowed) = %v", err)
+	}
+	if err := authorizer.Authorize(context.Background(), identity, "AlertsChannel", "denied"); err == nil {
+		t.Error("Authorize(denied) allowed")
+	}
+	if len(requests) != 2 || requests[0]["subject"] != "u1" || requests[0]["channel"] != "AlertsChannel" {
+		t.Errorf("callback requests = %v", requests)
+	}
+}
+
+func TestAuthorizeCallbackCache(t *testing.T) {
+	calls := 0
+	authorizer := callbackAuthorizer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}, 30)
+	now := time.Unix(1700000000, 0)
+	authorizer.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	identity := claimsIdentity("u1", map[string]interface{}{})
+	authorize := func(identity *Identity, stream string) {
+		t.Helper()
+		if err := authorizer.Authorize(ctx, identity, "", stream); err != nil {
+			t.Fatalf("Authorize(%s) = %v", stream, err)
+		}
+	}
+
+	authorize(identity, "a")
+	authorize(identity, "a")
+	if calls != 1 {
+		t.Errorf("callback called %d times within the TTL, want 1", calls)
+	}
+	authorize(claimsIdentity("u2", map[string]interface{}{}), "a")
+	authorize(identity, "b")
+	if calls != 3 {
+		t.Errorf("callback called %d times for new subjects and streams, want 3", calls)
+	}
+
+	now = now.Add(31 * time.Second)
+	authorize(identity, "a")
+	if calls != 4 {
+		t.Errorf("callback called %d times after the TTL, want 4", calls)
+	}
+
+	// Expired answers are swept once a TTL has passed since the last sweep
+	if _, ok := authorizer.cache["jwt\x00u1\x00b"]; ok {
+		t.Error("expired answer still cached after a sweep")
+	}
+
+	// Anonymous callers share a subject, so they are always asked
+	authorize(anonymousIdentity(), "a")
+	authorize(anonymousIdentity(), "a")
+	if calls != 6 {
+		t.Errorf("callback called %d times for anonymous callers, want 6", calls)
+	}
+}
+
+func TestAuthorizeCallbackCacheBounded(t *testing.T) {
+	authorizer := callbackAuthorizer(t, func(w http.ResponseWriter, r *http.Request) {}, 30)
+	now := time.Unix(1700000000, 0)
+	authorizer.now = func() time.Time { return now }
+	authorizer.cacheSwept = now
+
+	for i := 0; i < maxCallbackCacheEntries; i++ {
+		authorizer.cache[strconv.Itoa(i)] = authorizationDecision{expires: now.Add(time.Minute)}
+	}
+	identity := claimsIdentity("u1", map[string]interface{}{})
+	if err := authorizer.Authorize(context.Background(), identity, "", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(authorizer.cache) > maxCallbackCacheEntries {
+		t.Errorf("cache holds %d answers, want at most %d", len(authorizer.cache), maxCallbackCacheEntries)
+	}
+	if _, ok := authorizer.cache["jwt\x00u1\x00a"]; !ok {
+		t.Error("newest answer not cached")
+	}
+}
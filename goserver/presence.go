@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Presence defaults
+const (
+	defaultPresenceTTL   = 60 * time.Second
+	presenceStoreTimeout = 2 * time.Second
+
+	// presenceStreamSuffix names the stream carrying a channel's join and
+	// leave events, "<channel>:presence"
+	presenceStreamSuffix = ":presence"
+
+	// MessageTypePresence tags join and leave events
+	MessageTypePresence = "presence"
+)
+
+// Presence events
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+)
+
+// PresenceStore is implemented by brokers that can share presence between
+// instances. Members are kept per channel under their connection ID.
+type PresenceStore interface {
+	SavePresence(ctx context.Context, channel, id string, member []byte, ttl time.Duration) error
+	RemovePresence(ctx context.Context, channel, id string) (bool, error)
+	LoadPresence(ctx context.Context, channel string) (map[string][]byte, error)
+}
+
+// PresenceMember is one connection watching a channel
+type PresenceMember struct {
+	ConnectionID string    `json:"connection_id"`
+	Subject      string    `json:"subject"`
+	Method       string    `json:"method"`
+	Protocol     string    `json:"protocol"` // "sse" or "websocket"
+	Instance     string    `json:"instance"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	ExpiresAt    time.Time `json:"expires_at"` // Pushed forward by every heartbeat
+}
+
+// PresenceEvent is published on "<channel>:presence" when a member joins or
+// leaves
+type PresenceEvent struct {
+	Type    string `json:"type"`
+	Event   string `json:"event"`
+	Channel string `json:"channel"`
+	Reason  string `json:"reason,omitempty"` // "disconnect" or "expired" on leave
+	PresenceMember
+}
+
+// presenceOp is a join or leave waiting to be written to the store and announced
+type presenceOp struct {
+	channel string
+	event   string
+	member  PresenceMember
+}
+
+// presenceRef is a local member; WebSocket connections hold one reference
+// per subscription receiving the channel
+type presenceRef struct {
+	member *PresenceMember
+	refs   int
+}
+
+// Presence tracks which connections watch each channel. Members are written
+// to the broker's presence store, when it has one, and refreshed by a
+// heartbeat; members of instances that died expire after PRESENCE_TTL_SECONDS.
+// Store writes and join/leave events happen in Run, off the connect path.
+type Presence struct {
+	server   *Server
+	store    PresenceStore
+	instance string
+	ttl      time.Duration
+	events   bool                               // Publish join and leave events even without local listeners
+	members  map[string]map[string]*presenceRef // Channel -> connection ID -> local member
+	pending  []presenceOp                       // Joins and leaves for Run, in order
+	wake     chan struct{}
+	mu       sync.Mutex
+}
+
+// NewPresenceFromEnv creates the presence tracker
+func NewPresenceFromEnv(server *Server) *Presence {
+	hostname, _ := os.Hostname()
+	presence := &Presence{
+		server:   server,
+		instance: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		ttl:      time.Duration(getEnvInt("PRESENCE_TTL_SECONDS", int(defaultPresenceTTL/time.Second))) * time.Second,
+		events:   os.Getenv("PRESENCE_EVENTS") == "true",
+		members:  make(map[string]map[string]*presenceRef),
+		wake:     make(chan struct{}, 1),
+	}
+	if store, ok := server.broker.(PresenceStore); ok {
+		presence.store = store
+	} else {
+		server.logger.Info("👥 %s broker cannot share presence, reporting this instance's connections only", server.broker.Name())
+	}
+	return presence
+}
+
+// isPresenceStream reports whether a stream carries another channel's join
+// and leave events. Presence streams have no presence of their own.
+func isPresenceStream(channel string) bool {
+	return strings.HasSuffix(channel, presenceStreamSuffix)
+}
+
+// Join records a connection as watching a channel
+func (p *Presence) Join(channel, connectionID string, identity *Identity, protocol string, connectedAt time.Time) {
+	if isPresenceStream(channel) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	local, ok := p.members[channel]
+	if !ok {
+		local = make(map[string]*presenceRef)
+		p.members[channel] = local
+	}
+	if ref, ok := local[connectionID]; ok {
+		ref.refs++
+		return
+	}
+	member := &PresenceMember{
+		ConnectionID: connectionID,
+		Subject:      identity.Subject,
+		Method:       identity.Method,
+		Protocol:     protocol,
+		Instance:     p.instance,
+		ConnectedAt:  connectedAt,
+		ExpiresAt:    time.Now().Add(p.ttl),
+	}
+	local[connectionID] = &presenceRef{member: member, refs: 1}
+	p.enqueue(presenceOp{channel: channel, event: PresenceJoin, member: *member})
+}
+
+// Leave drops a connection's interest in a channel, recording it as gone
+// once nothing on the connection receives the channel
+func (p *Presence) Leave(channel, connectionID string) {
+	if isPresenceStream(channel) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ref, ok := p.members[channel][connectionID]
+	if !ok {
+		return
+	}
+	if ref.refs--; ref.refs > 0 {
+		return
+	}
+	delete(p.members[channel], connectionID)
+	if len(p.members[channel]) == 0 {
+		delete(p.members, channel)
+	}
+	p.enqueue(presenceOp{channel: channel, event: PresenceLeave, member: *ref.member})
+}
+
+// enqueue hands a join or leave to Run. Must be called with mu held.
+func (p *Presence) enqueue(op presenceOp) {
+	p.pending = append(p.pending, op)
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// apply writes queued joins and leaves to the store and announces them, in
+// the order they happened
+func (p *Presence) apply() {
+	p.mu.Lock()
+	ops := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+
+	for _, op := range ops {
+		if op.event == PresenceJoin {
+			p.save(op.channel, &op.member)
+			p.publish(op.channel, PresenceJoin, "", op.member)
+			continue
+		}
+
+		if p.store != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), presenceStoreTimeout)
+			removed, err := p.store.RemovePresence(ctx, op.channel, op.member.ConnectionID)
+			cancel()
+			if err != nil {
+				p.server.logger.Warn("⚠️ Unable to remove presence of %s on %s: %v", op.member.ConnectionID, op.channel, err)
+				p.server.metrics.BrokerErrors.Inc(p.server.broker.Name(), "presence")
+			} else if !removed {
+				// Already expired, and announced by whoever pruned it
+				continue
+			}
+		}
+		p.publish(op.channel, PresenceLeave, "disconnect", op.member)
+	}
+}
+
+// Members returns everyone watching a channel across instances, oldest
+// connection first. Expired members are pruned and announced as they are found.
+func (p *Presence) Members(ctx context.Context, channel string) ([]*PresenceMember, error) {
+	var members []*PresenceMember
+	if p.store == nil {
+		p.mu.Lock()
+		for _, ref := range p.members[channel] {
+			member := *ref.member
+			members = append(members, &member)
+		}
+		p.mu.Unlock()
+	} else {
+		stored, err := p.store.LoadPresence(ctx, channel)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for id, payload := range stored {
+			var member PresenceMember
+			if err := json.Unmarshal(payload, &member); err != nil {
+				p.server.logger.Warn("⚠️ Discarding unreadable presence of %s on %s: %v", id, channel, err)
+				p.store.RemovePresence(ctx, channel, id)
+				continue
+			}
+			if member.ExpiresAt.Before(now) {
+				if removed, err := p.store.RemovePresence(ctx, channel, id); err == nil && removed {
+					p.publish(channel, PresenceLeave, "expired", member)
+				}
+				continue
+			}
+			members = append(members, &member)
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool { return members[i].ConnectedAt.Before(members[j].ConnectedAt) })
+	return members, nil
+}
+
+// Run applies joins and leaves and refreshes this instance's members until
+// ctx is cancelled, pruning members other instances stopped refreshing on the
+// same channels
+func (p *Presence) Run(ctx context.Context) {
+	var heartbeat <-chan time.Time
+	if p.store != nil {
+		ticker := time.NewTicker(p.ttl / 3)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			p.apply()
+			return
+		case <-p.wake:
+			p.apply()
+		case <-heartbeat:
+			p.heartbeat(ctx)
+		}
+	}
+}
+
+// heartbeat pushes the expiry of every local member forward
+func (p *Presence) heartbeat(ctx context.Context) {
+	expiresAt := time.Now().Add(p.ttl)
+	refreshed := make(map[string][]PresenceMember)
+	p.mu.Lock()
+	for channel, local := range p.members {
+		for _, ref := range local {
+			ref.member.ExpiresAt = expiresAt
+			refreshed[channel] = append(refreshed[channel], *ref.member)
+		}
+	}
+	p.mu.Unlock()
+
+	for channel, members := range refreshed {
+		for i := range members {
+			p.save(channel, &members[i])
+		}
+		pruneCtx, cancel := context.WithTimeout(ctx, presenceStoreTimeout)
+		if _, err := p.Members(pruneCtx, channel); err != nil {
+			p.server.logger.Warn("⚠️ Unable to prune presence of %s: %v", channel, err)
+		}
+		cancel()
+	}
+}
+
+// save writes a member to the presence store, if there is one
+func (p *Presence) save(channel string, member *PresenceMember) {
+	if p.store == nil {
+		return
+	}
+	payload, err := json.Marshal(member)
+	if err != nil {
+		p.server.logger.Error("Error marshaling presence: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), presenceStoreTimeout)
+	defer cancel()
+	if err := p.store.SavePresence(ctx, channel, member.ConnectionID, payload, p.ttl); err != nil {
+		p.server.logger.Warn("⚠️ Unable to save presence of %s on %s: %v", member.ConnectionID, channel, err)
+		p.server.metrics.BrokerErrors.Inc(p.server.broker.Name(), "presence")
+	}
+}
+
+// publish announces a join or leave on the channel's presence stream. Unless
+// PRESENCE_EVENTS is set, events are only published while this instance has
+// listeners on the presence stream.
+func (p *Presence) publish(channel, event, reason string, member PresenceMember) {
+	p.server.logger.Debug("👥 %s %s %s (%s)", member.ConnectionID, event, channel, member.Subject)
+	if !p.events && !p.server.hub.Listening(channel+presenceStreamSuffix) {
+		return
+	}
+
+	payload, err := json.Marshal(PresenceEvent{
+		Type:           MessageTypePresence,
+		Event:          event,
+		Channel:        channel,
+		Reason:         reason,
+		PresenceMember: member,
+	})
+	if err != nil {
+		p.server.logger.Error("Error marshaling presence event: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), presenceStoreTimeout)
+	defer cancel()
+	if _, err := p.server.hub.Publish(ctx, channel+presenceStreamSuffix, payload); err != nil {
+		p.server.logger.Warn("⚠️ Unable to publish presence %s of %s on %s: %v", event, member.ConnectionID, channel, err)
+	}
+}
+
+// presenceHandler serves GET /presence/{channel}. Callers need the same
+// authorization as subscribing to the channel.
+func (s *Server) presenceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	identity, err := s.auth.Authenticate(r)
+	if err != nil {
+		s.logger.Warn("🔒 Presence authentication failed from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	channel := strings.TrimPrefix(r.URL.Path, "/presence/")
+	if !sseEventNamePattern.MatchString(channel) {
+		http.Error(w, fmt.Sprintf("invalid channel name %q", channel), http.StatusBadRequest)
+		return
+	}
+	if err := s.channels.Authorizer().Authorize(r.Context(), identity, "", channel); err != nil {
+		s.logger.Warn("🔒 Presence of %s refused for %s: %v", channel, identity.Subject, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), presenceStoreTimeout)
+	defer cancel()
+	members, err := s.presence.Members(ctx, channel)
+	if err != nil {
+		s.logger.Error("Error loading presence of %s: %v", channel, err)
+		http.Error(w, "Presence unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if members == nil {
+		members = []*PresenceMember{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"channel": channel,
+		"count":   len(members),
+		"members": members,
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Publish API defaults
+const (
+	defaultPublishMaxBytes = 1 << 20
+	publishBrokerTimeout   = 5 * time.Second
+)
+
+// PublishMessage is one line of an NDJSON batch sent to POST /publish
+type PublishMessage struct {
+	Channel string          `json:"channel"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// publishResult reports where a published message went
+type publishResult struct {
+	Channel string `json:"channel"`
+	ID      uint64 `json:"id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// parseTokens splits a token setting such as PUBLISH_TOKEN, which may list
+// several tokens separated by commas so they can be rotated
+func parseTokens(value string) []string {
+	var tokens []string
+	for _, token := range strings.Split(value, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// bearerAuthorized checks the request's bearer token against a token list
+func bearerAuthorized(r *http.Request, tokens []string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	for _, allowed := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizePublisher checks the request's bearer token against the
+// publisher tokens, which are separate from subscriber credentials
+func (s *Server) authorizePublisher(r *http.Request) bool {
+	return bearerAuthorized(r, s.publishTokens)
+}
+
+// publishHandler serves POST /publish/{channel} with a JSON body, and
+// POST /publish with an NDJSON batch of {"channel": ..., "data": ...} lines.
+// Messages reach this server's clients directly and other instances through
+// the broker.
+func (s *Server) publishHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(s.publishTokens) == 0 {
+		http.Error(w, "Publishing disabled: PUBLISH_TOKEN is not set", http.StatusForbidden)
+		return
+	}
+	if !s.authorizePublisher(r) {
+		s.logger.Warn("🔒 Publish refused from %s: invalid publisher token", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.publishMaxBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Body larger than %d bytes", s.publishMaxBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Unable to read body", http.StatusBadRequest)
+		return
+	}
+
+	var messages []PublishMessage
+	if channel := strings.TrimPrefix(r.URL.Path, "/publish/"); channel != r.URL.Path {
+		messages = []PublishMessage{{Channel: channel, Data: body}}
+	} else {
+		messages, err = parseNDJSONBatch(body)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// Validate everything before delivering anything, so a bad line does
+	// not leave a batch half published
+	for i, message := range messages {
+		if err := validatePublishMessage(message); err != nil {
+			if len(messages) > 1 {
+				err = fmt.Errorf("message %d: %w", i+1, err)
+			}
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), publishBrokerTimeout)
+	defer cancel()
+
+	status := http.StatusOK
+	results := make([]publishResult, 0, len(messages))
+	for _, message := range messages {
+		result := publishResult{Channel: message.Channel}
+		event, err := s.hub.Publish(ctx, message.Channel, message.Data)
+		if event != nil {
+			result.ID = event.ID
+		}
+		if err != nil {
+			// Local clients already have it; other instances do not
+			s.logger.Error("Error publishing to %s on %s: %v", message.Channel, s.broker.Name(), err)
+			result.Error = "delivered locally, broker publish failed: " + err.Error()
+			status = http.StatusBadGateway
+		}
+		s.metrics.Published.Inc(s.channelLabel(message.Channel))
+		results = append(results, result)
+	}
+	s.logger.Debug("📮 Published %d messages from %s", len(messages), r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if len(results) == 1 && r.URL.Path != "/publish" {
+		json.NewEncoder(w).Encode(results[0])
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"published": len(results), "results": results})
+}
+
+// parseNDJSONBatch splits a batch into messages, skipping blank lines
+func parseNDJSONBatch(body []byte) ([]PublishMessage, error) {
+	var messages []PublishMessage
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var message PublishMessage
+		if err := json.Unmarshal(text, &message); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		messages = append(messages, message)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("empty batch")
+	}
+	return messages, nil
+}
+
+// validatePublishMessage checks the channel name and that the payload is a
+// JSON value clients can decode
+func validatePublishMessage(message PublishMessage) error {
+	if !sseEventNamePattern.MatchString(message.Channel) {
+		return fmt.Errorf("invalid channel name %q", message.Channel)
+	}
+	if len(bytes.TrimSpace(message.Data)) == 0 {
+		return fmt.Errorf("empty payload")
+	}
+	if !json.Valid(message.Data) {
+		return fmt.Errorf("payload is not valid JSON")
+	}
+	return nil
+}
+
+// writeJSONError reports a rejected request as JSON
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// publishRequest sends a request to the publish API with a bearer token
+func publishRequest(s *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.publishHandler(w, r)
+	return w
+}
+
+func TestPublishFanOut(t *testing.T) {
+	s := newTestServer(t, map[string]string{"PUBLISH_TOKEN": "old, secret"})
+	subscriber := testSSEConnection(t, s, "dashboard_updates")
+	both := testSSEConnection(t, s, "dashboard_updates", "alerts")
+	other := testSSEConnection(t, s, "alerts")
+
+	w := publishRequest(s, http.MethodPost, "/publish/dashboard_updates", "secret", `{"type":"metrics","cpu":85}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d %s, want 200", w.Code, w.Body)
+	}
+	var result publishResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Channel != "dashboard_updates" || result.ID == 0 || result.Error != "" {
+		t.Errorf("result = %+v, want an ID on dashboard_updates", result)
+	}
+
+	// The same payload from another instance arrives after the broker copy
+	// of the local publish, so once it is queued the copy has been handled
+	if err := s.broker.Publish(context.Background(), "dashboard_updates", []byte(`{"type":"metrics","cpu":85}`)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`{"cpu":85,"type":"metrics"}`, `{"cpu":85,"type":"metrics"}`}
+	for _, conn := range []*SSEConnection{subscriber, both} {
+		if got := waitForFrames(t, conn, 2); !reflect.DeepEqual(got, want) {
+			t.Errorf("connection %s received %q, want %q", conn.ID, got, want)
+		}
+	}
+	waitForFrames(t, other, 0)
+
+	// A batch reaches each channel's subscribers in order
+	batch := `{"channel":"alerts","data":{"level":"error"}}` + "\n\n" +
+		`{"channel":"dashboard_updates","data":[1,2]}` + "\n" +
+		`{"channel":"alerts","data":"done"}` + "\n"
+	w = publishRequest(s, http.MethodPost, "/publish", "old", batch)
+	if w.Code != http.StatusOK {
+		t.Fatalf("batch status = %d %s, want 200", w.Code, w.Body)
+	}
+	var response struct {
+		Published int             `json:"published"`
+		Results   []publishResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Published != 3 || len(response.Results) != 3 || response.Results[1].Channel != "dashboard_updates" {
+		t.Errorf("batch response = %+v, want 3 results", response)
+	}
+	if got, want := waitForFrames(t, other, 2), []string{`{"level":"error"}`, `"done"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alerts subscriber received %q, want %q", got, want)
+	}
+	if got, want := waitForFrames(t, both, 3), []string{`{"level":"error"}`, `[1,2]`, `"done"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("subscriber to both received %q, want %q", got, want)
+	}
+	waitForFrames(t, subscriber, 1)
+}
+
+func TestPublishSkipsOwnBrokerCopy(t *testing.T) {
+	s := newTestServer(t, map[string]string{"PUBLISH_TOKEN": "secret"})
+	conn := testSSEConnection(t, s, "dashboard_updates")
+
+	// Publishing the same payload twice expects two copies back, and skips
+	// exactly those two
+	for i := 0; i < 2; i++ {
+		if w := publishRequest(s, http.MethodPost, "/publish/dashboard_updates", "secret", `{"n":1}`); w.Code != http.StatusOK {
+			t.Fatalf("status = %d %s, want 200", w.Code, w.Body)
+		}
+	}
+	if err := s.broker.Publish(context.Background(), "dashboard_updates", []byte(`{"n":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.broker.Publish(context.Background(), "dashboard_updates", []byte(`{"n":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`{"n":1}`, `{"n":1}`, `{"n":1}`, `{"n":2}`}
+	if got := waitForFrames(t, conn, 4); !reflect.DeepEqual(got, want) {
+		t.Errorf("received %q, want %q", got, want)
+	}
+
+	// Nothing is expected back on a channel the hub is not subscribed to,
+	// so a later broker copy is not mistaken for an echo
+	if w := publishRequest(s, http.MethodPost, "/publish/alerts", "secret", `{"n":3}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d %s, want 200", w.Code, w.Body)
+	}
+	late := testSSEConnection(t, s, "alerts")
+	if err := s.broker.Publish(context.Background(), "alerts", []byte(`{"n":3}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitForFrames(t, late, 1); got[0] != `{"n":3}` {
+		t.Errorf("received %q, want the broker copy", got)
+	}
+}
+
+func TestPublishRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  string
+		method  string
+		path    string
+		token   string
+		body    string
+		status  int
+		wantErr string
+	}{
+		{"publishing disabled", "", http.MethodPost, "/publish/alerts", "secret", `{}`, http.StatusForbidden, "PUBLISH_TOKEN"},
+		{"no token", "secret", http.MethodPost, "/publish/alerts", "", `{}`, http.StatusUnauthorized, "Unauthorized"},
+		{"wrong token", "secret", http.MethodPost, "/publish/alerts", "other", `{}`, http.StatusUnauthorized, "Unauthorized"},
+		{"wrong method", "secret", http.MethodGet, "/publish/alerts", "secret", ``, http.StatusMethodNotAllowed, "Method not allowed"},
+		{"body too large", "secret", http.MethodPost, "/publish/alerts", "secret", `"` + strings.Repeat("x", 64) + `"`, http.StatusRequestEntityTooLarge, "larger than 64 bytes"},
+		{"invalid channel", "secret", http.MethodPost, "/publish/bad%20channel", "secret", `{}`, http.StatusBadRequest, "invalid channel name"},
+		{"empty channel", "secret", http.MethodPost, "/publish/", "secret", `{}`, http.StatusBadRequest, "invalid channel name"},
+		{"empty payload", "secret", http.MethodPost, "/publish/alerts", "secret", " \n", http.StatusBadRequest, "empty payload"},
+		{"invalid JSON", "secret", http.MethodPost, "/publish/alerts", "secret", `{"a":`, http.StatusBadRequest, "not valid JSON"},
+		{"empty batch", "secret", http.MethodPost, "/publish", "secret", "\n\n", http.StatusBadRequest, "empty batch"},
+		{"malformed batch line", "secret", http.MethodPost, "/publish", "secret", `{"channel":"alerts","data":1}` + "\nnope\n", http.StatusBadRequest, "line 2"},
+		{"invalid batch message", "secret", http.MethodPost, "/publish", "secret", `{"channel":"alerts","data":1}` + "\n" + `{"channel":"alerts"}`, http.StatusBadRequest, "message 2: empty payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"PUBLISH_TOKEN": tt.tokens, "PUBLISH_MAX_BYTES": "64"})
+			conn := testSSEConnection(t, s, "alerts")
+
+			w := publishRequest(s, tt.method, tt.path, tt.token, tt.body)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.wantErr) {
+				t.Errorf("response = %d %s, want %d %q", w.Code, w.Body, tt.status, tt.wantErr)
+			}
+
+			// A rejected batch delivers none of its messages
+			waitForFrames(t, conn, 0)
+		})
+	}
+}
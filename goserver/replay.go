@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event is a single decoded message fanned out by the hub
+type Event struct {
+	ID         uint64
+	Channel    string
+	Data       interface{}
+	JSON       []byte    // Data re-encoded once for all SSE clients
+	Type       string    // Payload type from the event type field, if any
+	ReceivedAt time.Time // When the hub received it, for fan-out latency
+	System     bool      // Server-generated notice, exempt from subscription filters
+}
+
+// EventIDGenerator hands out monotonically increasing event IDs. It is
+// seeded from the start time in milliseconds so IDs issued after a restart
+// are still larger than any Last-Event-ID a client kept from before it.
+type EventIDGenerator struct {
+	last uint64
+}
+
+// NewEventIDGenerator creates a generator seeded from the current time
+func NewEventIDGenerator() *EventIDGenerator {
+	return &EventIDGenerator{last: uint64(time.Now().UnixMilli())}
+}
+
+// Next returns the next event ID
+func (g *EventIDGenerator) Next() uint64 {
+	return atomic.AddUint64(&g.last, 1)
+}
+
+// ReplayBuffer keeps the most recent events of a channel so reconnecting
+// clients can catch up on what they missed
+type ReplayBuffer struct {
+	events  []*Event
+	start   int // Index of the oldest event
+	count   int
+	evicted uint64 // ID of the newest event no longer buffered
+	mu      sync.RWMutex
+}
+
+// NewReplayBuffer creates a buffer holding at most size events
+func NewReplayBuffer(size int) *ReplayBuffer {
+	return &ReplayBuffer{events: make([]*Event, size)}
+}
+
+// Append adds an event, evicting the oldest one when the buffer is full
+func (b *ReplayBuffer) Append(event *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.events) == 0 {
+		b.evicted = event.ID
+		return
+	}
+	if b.count < len(b.events) {
+		b.events[(b.start+b.count)%len(b.events)] = event
+		b.count++
+		return
+	}
+	b.evicted = b.events[b.start].ID
+	b.events[b.start] = event
+	b.start = (b.start + 1) % len(b.events)
+}
+
+// Since returns the buffered events with an ID greater than lastID, oldest
+// first, whether lastID itself is still buffered, and whether every event
+// after lastID still is. Event IDs are shared by all channels, so the last
+// event a client saw is only found on the channel it came from; the others
+// are complete as long as nothing newer than it has been evicted.
+func (b *ReplayBuffer) Since(lastID uint64) (events []*Event, found, complete bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for i := 0; i < b.count; i++ {
+		event := b.events[(b.start+i)%len(b.events)]
+		switch {
+		case event.ID == lastID:
+			found = true
+		case event.ID > lastID:
+			events = append(events, event)
+		}
+	}
+	return events, found, b.evicted <= lastID
+}
+
+// parseLastEventID reads the Last-Event-ID header, falling back to the
+// lastEventId query parameter for proxies that strip custom headers
+func parseLastEventID(header, query string) (uint64, bool) {
+	value := header
+	if value == "" {
+		value = query
+	}
+	if value == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
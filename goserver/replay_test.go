@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// eventIDs lists the IDs of events
+func eventIDs(events []*Event) []uint64 {
+	ids := []uint64{}
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestReplayBufferSince(t *testing.T) {
+	b := NewReplayBuffer(3)
+	for _, id := range []uint64{10, 20, 30} {
+		b.Append(&Event{ID: id})
+	}
+
+	tests := []struct {
+		lastID          uint64
+		want            []uint64
+		found, complete bool
+	}{
+		{10, []uint64{20, 30}, true, true},
+		{30, []uint64{}, true, true},
+		{15, []uint64{20, 30}, false, true}, // From another channel
+		{5, []uint64{10, 20, 30}, false, true},
+		{99, []uint64{}, false, true},
+	}
+	for _, tt := range tests {
+		events, found, complete := b.Since(tt.lastID)
+		if got := eventIDs(events); !reflect.DeepEqual(got, tt.want) || found != tt.found || complete != tt.complete {
+			t.Errorf("Since(%d) = %v, %v, %v, want %v, %v, %v", tt.lastID, got, found, complete, tt.want, tt.found, tt.complete)
+		}
+	}
+
+	// Once 10 and 20 are evicted, only positions from 20 on are complete
+	b.Append(&Event{ID: 40})
+	b.Append(&Event{ID: 50})
+	for lastID, want := range map[uint64]bool{5: false, 10: false, 15: false, 20: true, 25: true, 30: true} {
+		if _, _, complete := b.Since(lastID); complete != want {
+			t.Errorf("after wrapping, Since(%d) complete = %v, want %v", lastID, complete, want)
+		}
+	}
+
+	// A buffer that keeps nothing is only complete up to the newest event
+	empty := NewReplayBuffer(0)
+	empty.Append(&Event{ID: 10})
+	if events, found, complete := empty.Since(5); len(events) != 0 || found || complete {
+		t.Errorf("empty buffer Since(5) = %v, %v, %v, want nothing, incomplete", events, found, complete)
+	}
+}
+
+func TestHubReplay(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SSE_REPLAY_SIZE": "2"})
+	publish := func(channel string) uint64 {
+		event, err := s.hub.Publish(context.Background(), channel, []byte(`{"type":"metrics"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return event.ID
+	}
+	a1 := publish("a")
+	b1 := publish("b")
+	a2 := publish("a")
+	channels := map[string]bool{"a": true, "b": true}
+
+	// Both channels still hold everything after a1
+	missed, stale := s.hub.Replay(channels, a1)
+	sort.Slice(missed, func(i, j int) bool { return missed[i].ID < missed[j].ID })
+	if got, want := eventIDs(missed), []uint64{b1, a2}; !reflect.DeepEqual(got, want) || len(stale) != 0 {
+		t.Errorf("Replay(a1) = %v, stale %v, want %v", got, stale, want)
+	}
+
+	// b wraps past a1 while a still holds it: b alone needs its snapshot
+	b2 := publish("b")
+	publish("b")
+	missed, stale = s.hub.Replay(channels, a1)
+	if got, want := eventIDs(missed), []uint64{a2}; !reflect.DeepEqual(got, want) || !reflect.DeepEqual(stale, []string{"b"}) {
+		t.Errorf("Replay(a1) = %v, stale %v, want %v, stale [b]", got, stale, want)
+	}
+
+	// A client that saw b2 has missed nothing either channel dropped
+	if _, stale = s.hub.Replay(channels, b2); len(stale) != 0 {
+		t.Errorf("Replay(b2) stale %v, want none", stale)
+	}
+
+	// An ID nobody here issued leaves every channel stale
+	missed, stale = s.hub.Replay(channels, 1)
+	sort.Strings(stale)
+	if len(missed) != 0 || !reflect.DeepEqual(stale, []string{"a", "b"}) {
+		t.Errorf("Replay(1) = %v, stale %v, want everything stale", eventIDs(missed), stale)
+	}
+}
+
+func TestStreamLastEventID(t *testing.T) {
+	tests := []struct {
+		name   string
+		lastID string // Name of the last event seen
+		want   []string
+	}{
+		{"replays channels covering the ID", "b2", []string{"b3", "a2"}},
+		{"snapshot for the channel that wrapped", "a1", []string{"b3", "a2"}},
+		{"caught up", "a2", nil},
+		{"unknown ID", "unknown", []string{"a1", "b3", "a2"}},
+		{"new client", "", []string{"a1", "b3", "a2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"SSE_REPLAY_SIZE": "2"})
+			ids := map[string]uint64{"unknown": 1}
+			publish := func(channel, name, eventType string) {
+				event, err := s.hub.Publish(context.Background(), channel, []byte(fmt.Sprintf(`{"n":%q,"type":%q}`, name, eventType)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids[name] = event.ID
+			}
+
+			// b is busier than a, and by the time the client reconnects has
+			// pushed b1 out of its buffer
+			publish("a", "a1", "alerts")
+			publish("b", "b1", "metrics")
+			publish("b", "b2", "metrics")
+			publish("b", "b3", "metrics")
+			publish("a", "a2", "metrics")
+
+			header := http.Header{}
+			if tt.lastID != "" {
+				header.Set("Last-Event-ID", strconv.FormatUint(ids[tt.lastID], 10))
+			}
+			stream := openSSEStream(t, s, "/dashboard/stream?channels=a,b", header)
+			if _, err := s.hub.Publish(context.Background(), "a", []byte(`{"n":"marker"}`)); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for {
+				frame := stream.Next(t)
+				var payload struct{ N string }
+				if err := json.Unmarshal([]byte(frame.Data), &payload); err != nil {
+					t.Fatal(err)
+				}
+				if payload.N == "marker" {
+					break
+				}
+				if frame.ID != strconv.FormatUint(ids[payload.N], 10) {
+					t.Errorf("%s sent with id %s, want %d", payload.N, frame.ID, ids[payload.N])
+				}
+				got = append(got, payload.N)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sent %v before live events, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SlowConsumerPolicy decides what happens when a connection's send queue is full
+type SlowConsumerPolicy string
+
+// Slow-consumer policies
+const (
+	// PolicyDropOldest discards the oldest queued data frame
+	PolicyDropOldest SlowConsumerPolicy = "drop_oldest"
+	// PolicyCoalesce replaces queued frames for the same stream and payload
+	// type with the newest one, since each dashboard update is a full snapshot
+	PolicyCoalesce SlowConsumerPolicy = "coalesce"
+	// PolicyDisconnect disconnects the client
+	PolicyDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+// ParseSlowConsumerPolicy parses a policy name, defaulting to drop_oldest
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(strings.ToLower(name)); policy {
+	case "":
+		return PolicyDropOldest, nil
+	case PolicyDropOldest, PolicyCoalesce, PolicyDisconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy %q", name)
+	}
+}
+
+// OutboundFrame is a single frame waiting to be written to a client
+type OutboundFrame struct {
+	Data       []byte
+	Key        string    // Stream the frame belongs to, used with Type for coalescing
+	Channel    string    // Channel the event was published on
+	Type       string    // Payload type, named with Channel on SSE event: lines
+	ID         uint64    // Event ID, if the frame carries an event
+	Control    bool      // Protocol frames (welcome, confirm, ping, disconnect) are never dropped
+	ReceivedAt time.Time // When the event was received from the broker, zero for replays
+
+	// Encode builds Data in the writer, for per-client encodings whose
+	// output depends on what the client was sent before
+	Encode func() ([]byte, error)
+}
+
+// SendQueue is a bounded per-connection outbound queue drained by a single
+// writer goroutine. Pushing never blocks, so one slow client cannot stall a
+// broadcast.
+type SendQueue struct {
+	frames     []*OutboundFrame
+	dataFrames int // Queued frames that are not control frames
+	bytes      int // Queued pre-encoded bytes
+	capacity   int
+	policy     SlowConsumerPolicy
+	dropped    int64
+	ready      chan struct{}
+	closed     bool
+	mu         sync.Mutex
+}
+
+// NewSendQueue creates a queue holding up to capacity data frames
+func NewSendQueue(capacity int, policy SlowConsumerPolicy) *SendQueue {
+	return &SendQueue{
+		capacity: capacity,
+		policy:   policy,
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// Push queues a frame, returning how many queued frames the policy discarded
+// to make room. ok is false when the queue is closed, or when it is full and
+// the policy is to disconnect the client.
+func (q *SendQueue) Push(frame *OutboundFrame) (dropped int, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return 0, false
+	}
+
+	if !frame.Control && q.dataFrames >= q.capacity {
+		switch q.policy {
+		case PolicyDisconnect:
+			q.dropped++
+			return 1, false
+		case PolicyCoalesce:
+			dropped = q.removeKey(frame.Key, frame.Type)
+			if dropped == 0 {
+				dropped = q.removeOldestData()
+			}
+		default:
+			dropped = q.removeOldestData()
+		}
+	}
+
+	q.frames = append(q.frames, frame)
+	q.count(frame, 1)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return dropped, true
+}
+
+// Pop returns the next queued frame without blocking
+func (q *SendQueue) Pop() (*OutboundFrame, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.frames) == 0 {
+		return nil, false
+	}
+	frame := q.frames[0]
+	q.frames[0] = nil
+	q.frames = q.frames[1:]
+	q.count(frame, -1)
+	return frame, true
+}
+
+// Ready is signalled whenever frames are pushed or the queue is closed. A
+// single signal may cover several frames, so drain with Pop.
+func (q *SendQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Next blocks until a frame is available, returning false once the queue is
+// closed and drained or done is closed
+func (q *SendQueue) Next(done <-chan struct{}) (*OutboundFrame, bool) {
+	for {
+		if frame, ok := q.Pop(); ok {
+			return frame, true
+		}
+
+		q.mu.Lock()
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		select {
+		case <-q.ready:
+		case <-done:
+			return nil, false
+		}
+	}
+}
+
+// Close stops accepting frames; frames already queued are still delivered
+func (q *SendQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Len returns the number of queued frames
+func (q *SendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames)
+}
+
+// Bytes returns the size of the queued frames. Frames encoded by the writer
+// count once written.
+func (q *SendQueue) Bytes() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bytes
+}
+
+// Dropped returns the number of data frames discarded because the queue was full
+func (q *SendQueue) Dropped() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// count adds a frame to the running totals (sign 1) or takes it out of them
+// (sign -1). Must be called with mu held.
+func (q *SendQueue) count(frame *OutboundFrame, sign int) {
+	if !frame.Control {
+		q.dataFrames += sign
+	}
+	q.bytes += sign * len(frame.Data)
+}
+
+// removeOldestData drops the oldest data frame, returning how many frames
+// were dropped. Must be called with mu held.
+func (q *SendQueue) removeOldestData() int {
+	for i, frame := range q.frames {
+		if !frame.Control {
+			copy(q.frames[i:], q.frames[i+1:])
+			q.frames[len(q.frames)-1] = nil
+			q.frames = q.frames[:len(q.frames)-1]
+			q.count(frame, -1)
+			q.dropped++
+			return 1
+		}
+	}
+	return 0
+}
+
+// removeKey drops every queued data frame for key and payload type,
+// returning how many were dropped. Must be called with mu held.
+func (q *SendQueue) removeKey(key, frameType string) int {
+	kept := q.frames[:0]
+	removed := 0
+	for _, frame := range q.frames {
+		if !frame.Control && frame.Key == key && frame.Type == frameType {
+			q.count(frame, -1)
+			removed++
+			continue
+		}
+		kept = append(kept, frame)
+	}
+	for i := len(kept); i < len(q.frames); i++ {
+		q.frames[i] = nil
+	}
+	q.frames = kept
+	q.dropped += int64(removed)
+	return removed
+}
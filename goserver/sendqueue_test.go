@@ -0,0 +1,259 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// dataFrame builds a data frame whose Data is its name
+func dataFrame(name, key, frameType string) *OutboundFrame {
+	return &OutboundFrame{Data: []byte(name), Key: key, Type: frameType}
+}
+
+// controlFrame builds a control frame whose Data is its name
+func controlFrame(name string) *OutboundFrame {
+	return &OutboundFrame{Data: []byte(name), Control: true}
+}
+
+// queuedNames lists the queued frames oldest first, checking the running
+// totals against the frames actually queued
+func queuedNames(t *testing.T, q *SendQueue) []string {
+	t.Helper()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	names := []string{}
+	dataFrames, bytes := 0, 0
+	for _, frame := range q.frames {
+		names = append(names, string(frame.Data))
+		if !frame.Control {
+			dataFrames++
+		}
+		bytes += len(frame.Data)
+	}
+	if q.dataFrames != dataFrames {
+		t.Errorf("running data frame count = %d, queue holds %d", q.dataFrames, dataFrames)
+	}
+	if q.bytes != bytes {
+		t.Errorf("running byte count = %d, queue holds %d", q.bytes, bytes)
+	}
+	return names
+}
+
+// push queues a frame, failing the test if the queue refuses it
+func push(t *testing.T, q *SendQueue, frame *OutboundFrame) int {
+	t.Helper()
+	dropped, ok := q.Push(frame)
+	if !ok {
+		t.Fatalf("Push(%s) refused", frame.Data)
+	}
+	return dropped
+}
+
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		want SlowConsumerPolicy
+		ok   bool
+	}{
+		{"", PolicyDropOldest, true},
+		{"drop_oldest", PolicyDropOldest, true},
+		{"COALESCE", PolicyCoalesce, true},
+		{"disconnect", PolicyDisconnect, true},
+		{"block", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseSlowConsumerPolicy(tt.name)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseSlowConsumerPolicy(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestSendQueueDropOldest(t *testing.T) {
+	q := NewSendQueue(3, PolicyDropOldest)
+	push(t, q, controlFrame("welcome"))
+	push(t, q, dataFrame("a1", "a", ""))
+	push(t, q, dataFrame("b1", "b", ""))
+	push(t, q, dataFrame("a2", "a", ""))
+
+	if dropped := push(t, q, dataFrame("c1", "c", "")); dropped != 1 {
+		t.Errorf("Push dropped %d frames, want 1", dropped)
+	}
+	if got, want := queuedNames(t, q), []string{"welcome", "b1", "a2", "c1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+	if dropped := push(t, q, dataFrame("c2", "c", "")); dropped != 1 {
+		t.Errorf("Push dropped %d frames, want 1", dropped)
+	}
+	if got, want := queuedNames(t, q), []string{"welcome", "a2", "c1", "c2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+	if q.Dropped() != 2 || q.Len() != 4 || q.Bytes() != len("welcome")+6 {
+		t.Errorf("Dropped, Len, Bytes = %d, %d, %d, want 2, 4, %d", q.Dropped(), q.Len(), q.Bytes(), len("welcome")+6)
+	}
+}
+
+func TestSendQueueCoalesce(t *testing.T) {
+	q := NewSendQueue(4, PolicyCoalesce)
+	push(t, q, dataFrame("a-metrics-1", "a", "metrics"))
+	push(t, q, dataFrame("a-alerts-1", "a", "alerts"))
+	push(t, q, dataFrame("b-metrics-1", "b", "metrics"))
+
+	// Below capacity nothing is coalesced
+	if dropped := push(t, q, dataFrame("a-metrics-2", "a", "metrics")); dropped != 0 {
+		t.Errorf("Push below capacity dropped %d frames", dropped)
+	}
+
+	// Full: every queued frame for the same key and type gives way
+	push(t, q, controlFrame("ping"))
+	if dropped := push(t, q, dataFrame("a-metrics-3", "a", "metrics")); dropped != 2 {
+		t.Errorf("Push dropped %d frames, want 2", dropped)
+	}
+	want := []string{"a-alerts-1", "b-metrics-1", "ping", "a-metrics-3"}
+	if got := queuedNames(t, q); !reflect.DeepEqual(got, want) {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+
+	// Still below capacity after coalescing, so the next frame just queues
+	push(t, q, dataFrame("c-metrics-1", "c", "metrics"))
+
+	// Full with no frame to coalesce: falls back to dropping the oldest data
+	if dropped := push(t, q, dataFrame("d-metrics-1", "d", "metrics")); dropped != 1 {
+		t.Errorf("Push dropped %d frames, want 1", dropped)
+	}
+	want = []string{"b-metrics-1", "ping", "a-metrics-3", "c-metrics-1", "d-metrics-1"}
+	if got := queuedNames(t, q); !reflect.DeepEqual(got, want) {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+	if q.Dropped() != 3 {
+		t.Errorf("Dropped = %d, want 3", q.Dropped())
+	}
+}
+
+func TestSendQueueDisconnect(t *testing.T) {
+	q := NewSendQueue(2, PolicyDisconnect)
+	push(t, q, dataFrame("a1", "a", ""))
+	push(t, q, dataFrame("a2", "a", ""))
+
+	dropped, ok := q.Push(dataFrame("a3", "a", ""))
+	if ok || dropped != 1 {
+		t.Errorf("Push on a full queue = %d, %v, want 1, false", dropped, ok)
+	}
+	if got, want := queuedNames(t, q), []string{"a1", "a2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+	if q.Dropped() != 1 {
+		t.Errorf("Dropped = %d, want 1", q.Dropped())
+	}
+
+	// Control frames still go through, so the disconnect itself can be sent
+	if _, ok := q.Push(controlFrame("disconnect")); !ok {
+		t.Error("control frame refused by a full queue")
+	}
+}
+
+func TestSendQueueControlFramesBypassCapacity(t *testing.T) {
+	for _, policy := range []SlowConsumerPolicy{PolicyDropOldest, PolicyCoalesce, PolicyDisconnect} {
+		t.Run(string(policy), func(t *testing.T) {
+			q := NewSendQueue(1, policy)
+			push(t, q, controlFrame("welcome"))
+			push(t, q, controlFrame("confirm"))
+			push(t, q, dataFrame("a1", "a", ""))
+			for _, name := range []string{"ping1", "ping2", "ping3"} {
+				if dropped := push(t, q, controlFrame(name)); dropped != 0 {
+					t.Errorf("control frame %s dropped %d frames", name, dropped)
+				}
+			}
+			want := []string{"welcome", "confirm", "a1", "ping1", "ping2", "ping3"}
+			if got := queuedNames(t, q); !reflect.DeepEqual(got, want) {
+				t.Errorf("queue = %v, want %v", got, want)
+			}
+			if policy == PolicyDisconnect {
+				return
+			}
+
+			// Evicting data never touches the control frames queued before it
+			push(t, q, dataFrame("a2", "a", ""))
+			want = []string{"welcome", "confirm", "ping1", "ping2", "ping3", "a2"}
+			if got := queuedNames(t, q); !reflect.DeepEqual(got, want) {
+				t.Errorf("queue = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSendQueuePopAccounting(t *testing.T) {
+	q := NewSendQueue(2, PolicyDropOldest)
+	push(t, q, controlFrame("welcome"))
+	push(t, q, dataFrame("a1", "a", ""))
+	push(t, q, &OutboundFrame{Key: "a", Encode: func() ([]byte, error) { return []byte("encoded"), nil }})
+
+	for want := 3; want > 0; want-- {
+		if q.Len() != want {
+			t.Errorf("Len = %d, want %d", q.Len(), want)
+		}
+		if _, ok := q.Pop(); !ok {
+			t.Fatal("Pop found no frame")
+		}
+		queuedNames(t, q)
+	}
+	if _, ok := q.Pop(); ok {
+		t.Error("Pop returned a frame from an empty queue")
+	}
+	if q.Bytes() != 0 {
+		t.Errorf("Bytes = %d after draining, want 0", q.Bytes())
+	}
+
+	// Capacity frees up as frames are popped
+	push(t, q, dataFrame("b1", "b", ""))
+	if dropped := push(t, q, dataFrame("b2", "b", "")); dropped != 0 {
+		t.Errorf("Push dropped %d frames from a queue with room", dropped)
+	}
+	if q.Dropped() != 0 {
+		t.Errorf("Dropped = %d, want 0", q.Dropped())
+	}
+}
+
+func TestSendQueueClose(t *testing.T) {
+	q := NewSendQueue(4, PolicyDropOldest)
+	push(t, q, dataFrame("a1", "a", ""))
+	push(t, q, dataFrame("a2", "a", ""))
+	q.Close()
+
+	if _, ok := q.Push(dataFrame("a3", "a", "")); ok {
+		t.Error("Push accepted a frame after Close")
+	}
+	var names []string
+	for {
+		frame, ok := q.Next(nil)
+		if !ok {
+			break
+		}
+		names = append(names, string(frame.Data))
+	}
+	if want := []string{"a1", "a2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("drained %v, want %v", names, want)
+	}
+}
+
+func TestSendQueueNext(t *testing.T) {
+	q := NewSendQueue(4, PolicyDropOldest)
+	done := make(chan struct{})
+	close(done)
+	if _, ok := q.Next(done); ok {
+		t.Error("Next on an empty queue returned a frame after done")
+	}
+
+	received := make(chan string)
+	go func() {
+		frame, ok := q.Next(nil)
+		if ok {
+			received <- string(frame.Data)
+		}
+		close(received)
+	}()
+	push(t, q, dataFrame("a1", "a", ""))
+	if got := <-received; got != "a1" {
+		t.Errorf("Next = %q, want a1", got)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// snapshotUntyped is the snapshot key for payloads without a type, or for
+// every payload when snapshots are not kept per type
+const snapshotUntyped = "_latest"
+
+// snapshotStoreTimeout bounds each snapshot read or write to the store
+const snapshotStoreTimeout = 2 * time.Second
+
+// SnapshotStore is implemented by brokers that can persist the latest
+// payloads of a channel, so a restarted server can still send them
+type SnapshotStore interface {
+	SaveSnapshot(ctx context.Context, channel, key string, payload []byte, ttl time.Duration) error
+	LoadSnapshots(ctx context.Context, channel string) (map[string][]byte, error)
+}
+
+// snapshotKey returns the key an event's snapshot is kept under
+func (h *Hub) snapshotKey(event *Event) string {
+	if h.snapshotByType && event.Type != "" {
+		return event.Type
+	}
+	return snapshotUntyped
+}
+
+// updateSnapshot records an event as the latest of its channel and type
+func (h *Hub) updateSnapshot(event *Event) {
+	h.snapshotMu.Lock()
+	defer h.snapshotMu.Unlock()
+
+	latest, ok := h.snapshots[event.Channel]
+	if !ok {
+		latest = make(map[string]*Event)
+		h.snapshots[event.Channel] = latest
+	}
+	latest[h.snapshotKey(event)] = event
+}
+
+// persistSnapshot hands an event to the snapshot writer, replacing any
+// older event of the same channel and key still waiting to be written
+func (h *Hub) persistSnapshot(event *Event) {
+	if h.snapshotStore == nil {
+		return
+	}
+	h.persistMu.Lock()
+	h.persistQueue[event.Channel+"\x00"+h.snapshotKey(event)] = event
+	h.persistMu.Unlock()
+
+	select {
+	case h.persistWake <- struct{}{}:
+	default:
+	}
+}
+
+// runSnapshotWriter writes queued snapshots to the store off the delivery
+// path. A burst on one channel and type costs a single write. Whatever is
+// still queued when the hub closes is flushed before returning.
+func (h *Hub) runSnapshotWriter() {
+	defer close(h.persistDone)
+	for {
+		select {
+		case <-h.ctx.Done():
+			h.writeSnapshots(context.Background())
+			return
+		case <-h.persistWake:
+			h.writeSnapshots(h.ctx)
+		}
+	}
+}
+
+// writeSnapshots writes every queued snapshot to the store
+func (h *Hub) writeSnapshots(parent context.Context) {
+	h.persistMu.Lock()
+	queued := h.persistQueue
+	h.persistQueue = make(map[string]*Event)
+	h.persistMu.Unlock()
+
+	for _, event := range queued {
+		key := h.snapshotKey(event)
+		ctx, cancel := context.WithTimeout(parent, snapshotStoreTimeout)
+		err := h.snapshotStore.SaveSnapshot(ctx, event.Channel, key, event.JSON, h.snapshotTTL)
+		cancel()
+		if err != nil {
+			h.server.logger.Warn("⚠️ Unable to persist %s snapshot of %s: %v", key, event.Channel, err)
+			h.server.metrics.BrokerErrors.Inc(h.server.broker.Name(), "snapshot")
+		}
+	}
+}
+
+// Snapshot returns the latest event of each type on a channel, oldest first.
+// The first call for a channel seeds it from the store, covering payloads
+// published before this server started.
+func (h *Hub) Snapshot(channel string) []*Event {
+	h.loadSnapshot(channel)
+
+	h.snapshotMu.Lock()
+	defer h.snapshotMu.Unlock()
+	events := make([]*Event, 0, len(h.snapshots[channel]))
+	for _, event := range h.snapshots[channel] {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events
+}
+
+// loadSnapshot seeds a channel's snapshot from the store once, without
+// replacing anything received since. The store is read without holding
+// snapshotMu; callers arriving meanwhile wait for that read.
+func (h *Hub) loadSnapshot(channel string) {
+	if h.snapshotStore == nil {
+		return
+	}
+
+	h.snapshotMu.Lock()
+	loaded, started := h.snapshotLoaded[channel]
+	if !started {
+		loaded = make(chan struct{})
+		h.snapshotLoaded[channel] = loaded
+	}
+	h.snapshotMu.Unlock()
+	if started {
+		<-loaded
+		return
+	}
+	defer close(loaded)
+
+	ctx, cancel := context.WithTimeout(h.ctx, snapshotStoreTimeout)
+	defer cancel()
+	payloads, err := h.snapshotStore.LoadSnapshots(ctx, channel)
+	if err != nil {
+		h.server.logger.Warn("⚠️ Unable to load snapshot of %s: %v", channel, err)
+		h.server.metrics.BrokerErrors.Inc(h.server.broker.Name(), "snapshot")
+		return
+	}
+
+	decoded := make(map[string]interface{}, len(payloads))
+	for key, payload := range payloads {
+		var data interface{}
+		if err := json.Unmarshal(payload, &data); err != nil {
+			h.server.logger.Warn("⚠️ Ignoring malformed %s snapshot of %s: %v", key, channel, err)
+			continue
+		}
+		decoded[key] = data
+	}
+
+	h.snapshotMu.Lock()
+	latest, ok := h.snapshots[channel]
+	if !ok {
+		latest = make(map[string]*Event)
+		h.snapshots[channel] = latest
+	}
+	for key, data := range decoded {
+		if _, ok := latest[key]; ok {
+			continue
+		}
+		if event := h.newEvent(channel, data); event != nil {
+			latest[key] = event
+		}
+	}
+	h.snapshotMu.Unlock()
+	if len(payloads) > 0 {
+		h.server.logger.Info("📸 Loaded %d persisted snapshots for %s", len(payloads), channel)
+	}
+}
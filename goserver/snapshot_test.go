@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedStore is a snapshot store whose loads wait until released, so a test
+// can publish while a connection is still reading its snapshot
+type gatedStore struct {
+	stored  map[string][]byte
+	loading chan struct{} // Signalled when a load starts
+	release chan struct{} // Closed to let loads finish
+	saved   map[string][]byte
+	mu      sync.Mutex
+}
+
+func newGatedStore(stored map[string][]byte) *gatedStore {
+	return &gatedStore{stored: stored, loading: make(chan struct{}, 1), release: make(chan struct{}), saved: make(map[string][]byte)}
+}
+
+func (g *gatedStore) SaveSnapshot(ctx context.Context, channel, key string, payload []byte, ttl time.Duration) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.saved[channel+"/"+key] = payload
+	return nil
+}
+
+func (g *gatedStore) LoadSnapshots(ctx context.Context, channel string) (map[string][]byte, error) {
+	g.loading <- struct{}{}
+	<-g.release
+	return g.stored, nil
+}
+
+// useSnapshotStore persists the hub's snapshots to store, as NewHub does for
+// brokers that can hold them
+func useSnapshotStore(s *Server, store SnapshotStore) {
+	h := s.hub
+	h.snapshotStore = store
+	h.persistQueue = make(map[string]*Event)
+	h.persistWake = make(chan struct{}, 1)
+	h.persistDone = make(chan struct{})
+	go h.runSnapshotWriter()
+}
+
+// snapshotNames lists the "n" field of each event's payload
+func snapshotNames(events []*Event) []string {
+	names := []string{}
+	for _, event := range events {
+		name, _ := event.Data.(map[string]interface{})["n"].(string)
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestHubSnapshot(t *testing.T) {
+	tests := []struct {
+		byType string
+		want   []string
+	}{
+		{"true", []string{"alert1", "metrics2", "untyped"}},
+		{"false", []string{"untyped"}},
+	}
+	for _, tt := range tests {
+		t.Run("SNAPSHOT_BY_TYPE="+tt.byType, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"SNAPSHOT_BY_TYPE": tt.byType})
+			for _, payload := range []string{
+				`{"type":"metrics","n":"metrics1"}`,
+				`{"type":"alerts","n":"alert1"}`,
+				`{"type":"metrics","n":"metrics2"}`,
+				`{"n":"untyped"}`,
+			} {
+				if _, err := s.hub.Publish(context.Background(), "a", []byte(payload)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := s.hub.Publish(context.Background(), "b", []byte(`{"type":"metrics","n":"other"}`)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := snapshotNames(s.hub.Snapshot("a")); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Snapshot(a) = %v, want %v", got, tt.want)
+			}
+			if got := snapshotNames(s.hub.Snapshot("c")); len(got) != 0 {
+				t.Errorf("Snapshot of an unused channel = %v", got)
+			}
+		})
+	}
+}
+
+func TestStreamSnapshotSkipsLiveCopies(t *testing.T) {
+	s := newTestServer(t, nil)
+	if _, err := s.hub.Publish(context.Background(), "a", []byte(`{"type":"metrics","n":"old"}`)); err != nil {
+		t.Fatal(err)
+	}
+	store := newGatedStore(map[string][]byte{
+		"alerts":  []byte(`{"type":"alerts","n":"stored"}`),
+		"metrics": []byte(`{"type":"metrics","n":"stale"}`),
+		"broken":  []byte(`{`),
+	})
+	useSnapshotStore(s, store)
+
+	// The stream is registered while its snapshot is still loading, so a
+	// publish now is queued live and also lands in the snapshot
+	stream := openSSEStream(t, s, "/stream/a", nil)
+	<-store.loading
+	if _, err := s.hub.Publish(context.Background(), "a", []byte(`{"type":"metrics","n":"live"}`)); err != nil {
+		t.Fatal(err)
+	}
+	close(store.release)
+
+	// The store only fills types this server has not seen
+	names := func(n int) []string {
+		var names []string
+		for _, data := range stream.Data(t, n) {
+			var payload struct{ N string }
+			if err := json.Unmarshal([]byte(data), &payload); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, payload.N)
+		}
+		return names
+	}
+	if got, want := names(2), []string{"live", "stored"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot sent %v, want %v", got, want)
+	}
+
+	// And the live copy of an event the snapshot sent is skipped
+	if _, err := s.hub.Publish(context.Background(), "a", []byte(`{"n":"marker"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(1); got[0] != "marker" {
+		t.Errorf("sent %v after the snapshot, want the marker", got)
+	}
+
+	// Published events are written back to the store
+	s.hub.Close()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if got := string(store.saved["a/metrics"]); got != `{"n":"live","type":"metrics"}` {
+		t.Errorf("persisted metrics snapshot %s, want the live event", got)
+	}
+}
+
+func TestWebSocketSnapshotSkipsLiveKeys(t *testing.T) {
+	s := newTestServer(t, nil)
+	for _, payload := range []string{`{"type":"metrics","n":"m1"}`, `{"type":"status","n":"s1"}`} {
+		if _, err := s.hub.Publish(context.Background(), "alerts", []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A broadcast arriving between registering the subscription and sending
+	// its snapshot supersedes the snapshot of the same type
+	conn, sub := testWSConnection(s)
+	sub.liveKeys = make(map[string]bool)
+	s.addWSConnection(conn)
+	t.Cleanup(func() { s.removeWSConnection(conn.ID) })
+	if _, err := s.hub.Publish(context.Background(), "alerts", []byte(`{"type":"metrics","n":"m2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	s.sendWebSocketSnapshot(conn, sub)
+
+	var got []string
+	for {
+		frame, ok := conn.Queue.Pop()
+		if !ok {
+			break
+		}
+		var message struct {
+			Identifier string
+			Message    struct{ N string }
+		}
+		if err := json.Unmarshal(frame.Data, &message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Identifier != sub.Identifier {
+			t.Errorf("frame %s, want one for %s", frame.Data, sub.Identifier)
+		}
+		got = append(got, message.Message.N)
+	}
+	if want := []string{"m2", "s1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %v, want %v", got, want)
+	}
+	if sub.liveKeys != nil {
+		t.Error("live keys still tracked after the snapshot")
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stats stream tuning
+const (
+	statsSampleInterval        = time.Second
+	defaultStatsStreamInterval = 2 * time.Second
+	maxStatsStreamInterval     = 60 * time.Second
+)
+
+// statsWindows are the sliding windows message and connection rates are
+// computed over
+var statsWindows = []struct {
+	name   string
+	length time.Duration
+}{
+	{"10s", 10 * time.Second},
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+}
+
+// statsCounters is a point-in-time copy of the cumulative server counters
+type statsCounters struct {
+	at             time.Time
+	sseConnections int64
+	wsConnections  int64
+	sseMessages    int64
+	wsMessages     int64
+	brokerMessages int64
+	sseDropped     int64
+	sseEvicted     int64
+	conflated      int64
+}
+
+// counters copies the cumulative counters
+func (s *ServerStats) counters() statsCounters {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return statsCounters{
+		at:             time.Now(),
+		sseConnections: s.TotalSSEConnections,
+		wsConnections:  s.TotalWebSocketConnections,
+		sseMessages:    s.TotalSSEMessages,
+		wsMessages:     s.TotalWebSocketMessages,
+		brokerMessages: s.TotalRedisMessages,
+		sseDropped:     s.DroppedSSEMessages,
+		sseEvicted:     s.EvictedSSEConnections,
+		conflated:      s.ConflatedSSEMessages + s.ConflatedWebSocketMessages,
+	}
+}
+
+// rates returns per-second rates between an older sample and this one
+func (c statsCounters) rates(since statsCounters) map[string]float64 {
+	elapsed := c.at.Sub(since.at).Seconds()
+	rate := func(now, then int64) float64 {
+		if elapsed <= 0 {
+			return 0
+		}
+		return float64(now-then) / elapsed
+	}
+	return map[string]float64{
+		"sse_messages":          rate(c.sseMessages, since.sseMessages),
+		"websocket_messages":    rate(c.wsMessages, since.wsMessages),
+		"broker_messages":       rate(c.brokerMessages, since.brokerMessages),
+		"sse_connections":       rate(c.sseConnections, since.sseConnections),
+		"websocket_connections": rate(c.wsConnections, since.wsConnections),
+		"sse_dropped":           rate(c.sseDropped, since.sseDropped),
+		"sse_evicted":           rate(c.sseEvicted, since.sseEvicted),
+		"conflated":             rate(c.conflated, since.conflated),
+	}
+}
+
+// StatsSampler samples the server counters every second, keeping enough
+// history for the longest rate window, and renders the latest stats frame
+// shared by every /dashboard/stats/stream client
+type StatsSampler struct {
+	server  *Server
+	samples []statsCounters // Oldest first
+	frame   []byte
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.RWMutex
+}
+
+// NewStatsSampler creates a sampler; Run starts it
+func NewStatsSampler(server *Server) *StatsSampler {
+	return &StatsSampler{server: server, done: make(chan struct{})}
+}
+
+// Run samples until ctx is cancelled or the sampler is stopped
+func (s *StatsSampler) Run(ctx context.Context) {
+	s.sample()
+	ticker := time.NewTicker(statsSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.Stop()
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.sample()
+		}
+	}
+}
+
+// Stop ends sampling and every stats stream, so draining does not wait on them
+func (s *StatsSampler) Stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+// Done is closed once the sampler stops
+func (s *StatsSampler) Done() <-chan struct{} {
+	return s.done
+}
+
+// Frame returns the latest rendered stats frame
+func (s *StatsSampler) Frame() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.frame
+}
+
+// sample records the counters and renders a new frame
+func (s *StatsSampler) sample() {
+	now := s.server.stats.counters()
+	longest := statsWindows[len(statsWindows)-1].length
+
+	s.mu.Lock()
+	s.samples = append(s.samples, now)
+	// Keep one sample at or before the start of the longest window
+	for len(s.samples) > 2 && now.at.Sub(s.samples[1].at) >= longest {
+		s.samples = s.samples[1:]
+	}
+	samples := s.samples
+	s.mu.Unlock()
+
+	frame, err := json.Marshal(s.render(now, samples))
+	if err != nil {
+		s.server.logger.Error("Error marshaling stats frame: %v", err)
+		return
+	}
+	s.mu.Lock()
+	s.frame = frame
+	s.mu.Unlock()
+}
+
+// render builds a stats frame. Windows longer than the history use all of it.
+func (s *StatsSampler) render(now statsCounters, samples []statsCounters) map[string]interface{} {
+	rates := make(map[string]interface{}, len(statsWindows))
+	for _, window := range statsWindows {
+		since := samples[0]
+		for _, sample := range samples {
+			if now.at.Sub(sample.at) < window.length {
+				break
+			}
+			since = sample
+		}
+		rates[window.name] = now.rates(since)
+	}
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	_, currentSSE, _, currentWS, _, _, _, uptime := s.server.stats.GetStats()
+	return map[string]interface{}{
+		"timestamp":      now.at.Format(time.RFC3339),
+		"uptime_seconds": int64(uptime.Seconds()),
+		"connections": map[string]interface{}{
+			"sse":       currentSSE,
+			"websocket": currentWS,
+			"total":     currentSSE + currentWS,
+		},
+		"rates":  rates,
+		"broker": brokerStatusJSON(s.server.supervisor.Status()),
+		"runtime": map[string]interface{}{
+			"goroutines":       runtime.NumGoroutine(),
+			"heap_alloc_bytes": memory.HeapAlloc,
+			"heap_inuse_bytes": memory.HeapInuse,
+			"sys_bytes":        memory.Sys,
+			"gc_cycles":        memory.NumGC,
+			"gc_pause_ns":      memory.PauseNs[(memory.NumGC+255)%256],
+		},
+	}
+}
+
+// statsStreamHandler serves /dashboard/stats/stream, pushing a stats frame
+// every ?interval= seconds (default 2, at most 60)
+func (s *Server) statsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if s.rejectWhileDraining(w) {
+		return
+	}
+
+	interval := defaultStatsStreamInterval
+	if value := r.URL.Query().Get("interval"); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < statsSampleInterval.Seconds() || seconds > maxStatsStreamInterval.Seconds() {
+			http.Error(w, fmt.Sprintf("interval must be between %d and %d seconds", int(statsSampleInterval.Seconds()), int(maxStatsStreamInterval.Seconds())), http.StatusBadRequest)
+			return
+		}
+		interval = time.Duration(seconds * float64(time.Second))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	// Bound every write so a stalled client cannot hold its handler forever
+	rc := http.NewResponseController(w)
+	send := func() error {
+		frame := s.sampler.Frame()
+		if frame == nil {
+			return nil
+		}
+		if err := rc.SetWriteDeadline(time.Now().Add(s.sseWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: stats\ndata: %s\n\n", frame); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	if err := send(); err != nil {
+		return
+	}
+	s.logger.Debug("📊 Stats stream opened from %s (every %s)", r.RemoteAddr, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			s.logger.Debug("📊 Stats stream closed from %s", r.RemoteAddr)
+			return
+		case <-s.sampler.Done():
+			return
+		case <-ticker.C:
+			if err := send(); err != nil {
+				s.logger.Debug("Error sending stats frame to %s: %v", r.RemoteAddr, err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Pinger is implemented by brokers that can check their backend is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Broker states reported by the supervisor
+const (
+	BrokerStateConnected = "connected"
+	BrokerStateDegraded  = "degraded"
+)
+
+// Supervisor timing
+const (
+	supervisorCheckInterval  = 5 * time.Second
+	supervisorMinBackoff     = time.Second
+	supervisorMaxBackoff     = 30 * time.Second
+	supervisorNoticeInterval = 30 * time.Second
+)
+
+// BrokerStatus is a snapshot of the broker's health
+type BrokerStatus struct {
+	Backend   string
+	State     string
+	Since     time.Time
+	LastError string
+	Attempts  int // Reconnection attempts since the broker went down
+}
+
+// BrokerSupervisor health-checks the broker in the background. When the
+// backend goes away it retries with exponential backoff, tells connected
+// clients the dashboard is degraded, and re-establishes the hub's
+// subscription once the backend is back.
+type BrokerSupervisor struct {
+	server     *Server
+	status     BrokerStatus
+	lastNotice time.Time
+	mu         sync.RWMutex
+}
+
+// NewBrokerSupervisor creates a supervisor for the server's broker
+func NewBrokerSupervisor(server *Server) *BrokerSupervisor {
+	return &BrokerSupervisor{
+		server: server,
+		status: BrokerStatus{
+			Backend: server.broker.Name(),
+			State:   BrokerStateConnected,
+			Since:   time.Now(),
+		},
+	}
+}
+
+// Status returns the current broker status
+func (b *BrokerSupervisor) Status() BrokerStatus {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.status
+}
+
+// Healthy reports whether the broker is connected
+func (b *BrokerSupervisor) Healthy() bool {
+	return b.Status().State == BrokerStateConnected
+}
+
+// Run supervises the broker until ctx is cancelled
+func (b *BrokerSupervisor) Run(ctx context.Context) {
+	if err := b.ping(ctx); err != nil {
+		b.markDegraded(err)
+	}
+
+	timer := time.NewTimer(b.nextDelay())
+	defer timer.Stop()
+
+	for {
+		lost := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-b.server.hub.Lost():
+			lost = true
+			b.server.logger.Warn("⚠️ %s subscription lost, re-establishing it", b.server.broker.Name())
+		}
+
+		if err := b.ping(ctx); err != nil {
+			b.markDegraded(err)
+		} else if !b.Healthy() || lost {
+			if err := b.server.hub.Resubscribe(); err != nil {
+				b.markDegraded(err)
+			} else {
+				b.markConnected()
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(b.nextDelay())
+	}
+}
+
+// ping checks the broker, treating brokers without a health check as healthy
+func (b *BrokerSupervisor) ping(ctx context.Context) error {
+	pinger, ok := b.server.broker.(Pinger)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, supervisorCheckInterval)
+	defer cancel()
+	return pinger.Ping(ctx)
+}
+
+// nextDelay returns the health check interval, or the backoff while degraded
+func (b *BrokerSupervisor) nextDelay() time.Duration {
+	status := b.Status()
+	if status.State == BrokerStateConnected {
+		return supervisorCheckInterval
+	}
+
+	delay := supervisorMinBackoff
+	for i := 1; i < status.Attempts && delay < supervisorMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > supervisorMaxBackoff {
+		delay = supervisorMaxBackoff
+	}
+	return delay
+}
+
+// markDegraded records a failed check and notifies clients, at most once per
+// notice interval while the outage lasts
+func (b *BrokerSupervisor) markDegraded(err error) {
+	b.mu.Lock()
+	wasConnected := b.status.State == BrokerStateConnected
+	if wasConnected {
+		b.status.State = BrokerStateDegraded
+		b.status.Since = time.Now()
+		b.status.Attempts = 0
+	}
+	b.status.LastError = err.Error()
+	b.status.Attempts++
+	notify := time.Since(b.lastNotice) >= supervisorNoticeInterval
+	if notify {
+		b.lastNotice = time.Now()
+	}
+	attempts := b.status.Attempts
+	b.mu.Unlock()
+
+	b.server.metrics.BrokerErrors.Inc(b.server.broker.Name(), "health_check")
+
+	if wasConnected {
+		b.server.logger.Error("❌ %s broker unavailable: %v", b.server.broker.Name(), err)
+	} else {
+		b.server.logger.Warn("⚠️ %s broker still unavailable (attempt %d): %v", b.server.broker.Name(), attempts, err)
+	}
+	if notify {
+		b.server.hub.Notify(systemStatusNotice("degraded", "Live updates interrupted, reconnecting to "+b.server.broker.Name()))
+	}
+}
+
+// markConnected records a successful reconnection and notifies clients
+func (b *BrokerSupervisor) markConnected() {
+	b.mu.Lock()
+	b.status.State = BrokerStateConnected
+	b.status.Since = time.Now()
+	b.status.Attempts = 0
+	b.lastNotice = time.Time{}
+	b.mu.Unlock()
+
+	b.server.logger.Info("✅ %s broker reconnected", b.server.broker.Name())
+	b.server.hub.Notify(systemStatusNotice("online", "Live updates restored"))
+}
+
+// systemStatusNotice builds a system_status message in the shape of the
+// dashboard payload so existing clients render it in the status indicator
+func systemStatusNotice(status, message string) map[string]interface{} {
+	now := time.Now().Format("15:04:05")
+	return map[string]interface{}{
+		"type": "system_status",
+		"system_status": map[string]interface{}{
+			"status":     status,
+			"message":    message,
+			"last_check": now,
+		},
+		"timestamp": now,
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyBroker is an in-memory broker whose health check fails while it is
+// down, and whose subscriptions report resume points like Redis Streams
+type flakyBroker struct {
+	*MemoryBroker
+	down    error
+	resumed []map[string]string // Resume points of each SubscribeFrom call
+	lastIDs map[string]string   // Reported by every subscription
+	mu      sync.Mutex
+}
+
+// flakySubscription reports the broker's configured resume points
+type flakySubscription struct {
+	Subscription
+	lastIDs map[string]string
+}
+
+func (s *flakySubscription) LastIDs() map[string]string {
+	return s.lastIDs
+}
+
+func (b *flakyBroker) setDown(err error) {
+	b.mu.Lock()
+	b.down = err
+	b.mu.Unlock()
+}
+
+func (b *flakyBroker) Ping(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.down
+}
+
+func (b *flakyBroker) Subscribe(ctx context.Context, channels ...string) (Subscription, error) {
+	if err := b.Ping(ctx); err != nil {
+		return nil, err
+	}
+	sub, err := b.MemoryBroker.Subscribe(ctx, channels...)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &flakySubscription{Subscription: sub, lastIDs: b.lastIDs}, err
+}
+
+func (b *flakyBroker) SubscribeFrom(ctx context.Context, lastIDs map[string]string, channels ...string) (Subscription, error) {
+	b.mu.Lock()
+	b.resumed = append(b.resumed, lastIDs)
+	b.mu.Unlock()
+	return b.Subscribe(ctx, channels...)
+}
+
+// waitUntil polls cond until it holds, failing the test after 2s
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// noticeStatus reads the status of a queued system_status notice
+func noticeStatus(t *testing.T, frame *OutboundFrame) string {
+	t.Helper()
+	var notice struct {
+		Type         string `json:"type"`
+		SystemStatus struct {
+			Status string `json:"status"`
+		} `json:"system_status"`
+	}
+	if err := json.Unmarshal(frame.Data, &notice); err != nil {
+		t.Fatal(err)
+	}
+	if notice.Type != "system_status" {
+		t.Errorf("frame %s is not a system_status notice", frame.Data)
+	}
+	return notice.SystemStatus.Status
+}
+
+func TestBrokerSupervisorRecovers(t *testing.T) {
+	s := newTestServer(t, nil)
+	broker := &flakyBroker{MemoryBroker: NewMemoryBroker(), lastIDs: map[string]string{"dashboard_updates": "1700000000000-3"}}
+	s.broker = broker
+	s.supervisor = NewBrokerSupervisor(s)
+	conn := testSSEConnection(t, s, "dashboard_updates")
+
+	// The backend goes away and takes the subscription with it
+	broker.setDown(errors.New("connection refused"))
+	broker.MemoryBroker.Close()
+	waitUntil(t, "the hub notices the lost subscription", func() bool {
+		s.hub.mu.Lock()
+		defer s.hub.mu.Unlock()
+		return s.hub.sub == nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.supervisor.Run(ctx)
+
+	// The failed startup check and the lost subscription each count as an
+	// attempt, but clients are only told once
+	waitUntil(t, "two failed attempts", func() bool { return s.supervisor.Status().Attempts == 2 })
+	status := s.supervisor.Status()
+	if status.State != BrokerStateDegraded || status.LastError != "connection refused" || s.supervisor.Healthy() {
+		t.Errorf("status = %+v, want degraded by the refused connection", status)
+	}
+	degraded, ok := conn.Queue.Pop()
+	if !ok || noticeStatus(t, degraded) != "degraded" {
+		t.Fatal("clients not told the broker is degraded")
+	}
+	if conn.Queue.Len() != 0 {
+		t.Error("second failed attempt notified clients again")
+	}
+
+	// Once the backend answers, the subscription resumes where the lost one
+	// stopped reading
+	broker.setDown(nil)
+	s.hub.lost <- struct{}{}
+	waitUntil(t, "the broker is reconnected", s.supervisor.Healthy)
+	if status := s.supervisor.Status(); status.Attempts != 0 {
+		t.Errorf("attempts = %d after reconnecting, want 0", status.Attempts)
+	}
+	broker.mu.Lock()
+	resumed := broker.resumed
+	broker.mu.Unlock()
+	if want := []map[string]string{{"dashboard_updates": "1700000000000-3"}}; !reflect.DeepEqual(resumed, want) {
+		t.Errorf("resubscribed from %v, want %v", resumed, want)
+	}
+
+	waitUntil(t, "the recovery notice", func() bool { return conn.Queue.Len() == 1 })
+	online, _ := conn.Queue.Pop()
+	if noticeStatus(t, online) != "online" || online.ID <= degraded.ID {
+		t.Errorf("recovery notice %d %s, want online after %d", online.ID, online.Data, degraded.ID)
+	}
+
+	// Notices are replayed like any other event, but never snapshotted
+	missed, stale := s.hub.Replay(conn.Subscriptions, degraded.ID)
+	if len(stale) != 0 || len(missed) != 1 || missed[0].ID != online.ID {
+		t.Errorf("Replay after the degraded notice = %v, stale %v, want the online notice", eventIDs(missed), stale)
+	}
+	if snapshot := s.hub.Snapshot("dashboard_updates"); len(snapshot) != 0 {
+		t.Errorf("snapshot holds %d notices", len(snapshot))
+	}
+
+	// And messages flow again
+	if err := broker.Publish(context.Background(), "dashboard_updates", []byte(`{"n":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitForFrames(t, conn, 1); got[0] != `{"n":1}` {
+		t.Errorf("received %q after reconnecting", got)
+	}
+}
+
+func TestBrokerSupervisorBackoff(t *testing.T) {
+	s := newTestServer(t, nil)
+	tests := []struct {
+		state    string
+		attempts int
+		want     time.Duration
+	}{
+		{BrokerStateConnected, 0, supervisorCheckInterval},
+		{BrokerStateDegraded, 1, time.Second},
+		{BrokerStateDegraded, 2, 2 * time.Second},
+		{BrokerStateDegraded, 3, 4 * time.Second},
+		{BrokerStateDegraded, 5, 16 * time.Second},
+		{BrokerStateDegraded, 6, supervisorMaxBackoff},
+		{BrokerStateDegraded, 100, supervisorMaxBackoff},
+	}
+	for _, tt := range tests {
+		s.supervisor.status.State, s.supervisor.status.Attempts = tt.state, tt.attempts
+		if got := s.supervisor.nextDelay(); got != tt.want {
+			t.Errorf("nextDelay with %s after %d attempts = %s, want %s", tt.state, tt.attempts, got, tt.want)
+		}
+	}
+}
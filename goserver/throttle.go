@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Throttle caps how often a connection is sent updates for each stream and
+// payload type. Updates arriving faster are conflated: only the newest one
+// waits, and it is sent once the interval since the last send has passed.
+type Throttle struct {
+	interval   time.Duration
+	deliver    func(*OutboundFrame)
+	onConflate func()
+	keys       map[string]*throttledKey
+	stopped    bool
+	mu         sync.Mutex
+
+	now       func() time.Time                        // Clock, replaced in tests
+	afterFunc func(time.Duration, func()) func() bool // Schedules a flush and returns its stop function
+}
+
+// throttledKey is the send state of one stream and payload type
+type throttledKey struct {
+	lastSent time.Time
+	pending  *OutboundFrame
+	stop     func() bool // Cancels the scheduled flush of pending
+}
+
+// NewThrottle creates a throttle allowing rate updates per second for each
+// stream and type. deliver queues a frame for the client; onConflate is
+// called for every update replaced before it was sent.
+func NewThrottle(rate float64, deliver func(*OutboundFrame), onConflate func()) *Throttle {
+	return &Throttle{
+		interval:   time.Duration(float64(time.Second) / rate),
+		deliver:    deliver,
+		onConflate: onConflate,
+		keys:       make(map[string]*throttledKey),
+		now:        time.Now,
+		afterFunc: func(d time.Duration, f func()) func() bool {
+			return time.AfterFunc(d, f).Stop
+		},
+	}
+}
+
+// Offer delivers a frame now if its stream and type may be sent, or holds it
+// in place of any frame already waiting
+func (t *Throttle) Offer(frame *OutboundFrame) {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return
+	}
+
+	id := frame.Key + "\x00" + frame.Type
+	key, ok := t.keys[id]
+	if !ok {
+		key = &throttledKey{}
+		t.keys[id] = key
+	}
+
+	now := t.now()
+	if key.pending == nil && now.Sub(key.lastSent) >= t.interval {
+		key.lastSent = now
+		t.mu.Unlock()
+		t.deliver(frame)
+		return
+	}
+
+	conflated := key.pending != nil
+	key.pending = frame
+	if key.stop == nil {
+		key.stop = t.afterFunc(key.lastSent.Add(t.interval).Sub(now), func() { t.flush(id) })
+	}
+	t.mu.Unlock()
+
+	if conflated {
+		t.onConflate()
+	}
+}
+
+// flush sends the frame waiting for a stream and type
+func (t *Throttle) flush(id string) {
+	t.mu.Lock()
+	key := t.keys[id]
+	frame := key.pending
+	key.pending, key.stop = nil, nil
+	key.lastSent = t.now()
+	stopped := t.stopped
+	t.mu.Unlock()
+
+	if frame != nil && !stopped {
+		t.deliver(frame)
+	}
+}
+
+// Stop discards waiting frames; later offers are ignored
+func (t *Throttle) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	for _, key := range t.keys {
+		if key.stop != nil {
+			key.stop()
+		}
+		key.pending = nil
+	}
+}
+
+// updateRate resolves the updates per second a client is throttled to: the
+// rate it asked for, capped by MAX_UPDATES_PER_SECOND. Zero means unthrottled.
+func (s *Server) updateRate(requested string) (float64, error) {
+	rate := 0.0
+	if requested != "" {
+		var err error
+		rate, err = strconv.ParseFloat(requested, 64)
+		if err != nil || rate <= 0 {
+			return 0, fmt.Errorf("invalid max_rate %q", requested)
+		}
+	}
+	if s.maxUpdateRate > 0 && (rate == 0 || rate > s.maxUpdateRate) {
+		rate = s.maxUpdateRate
+	}
+	return rate, nil
+}
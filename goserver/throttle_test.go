@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeClock drives a throttle's clock and scheduled flushes by hand
+type fakeClock struct {
+	now       time.Time
+	scheduled []*fakeTimer
+}
+
+// fakeTimer is a flush scheduled on a fakeClock
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+// install replaces the throttle's clock with this one
+func (c *fakeClock) install(t *Throttle) {
+	t.now = func() time.Time { return c.now }
+	t.afterFunc = func(d time.Duration, f func()) func() bool {
+		timer := &fakeTimer{at: c.now.Add(d), f: f}
+		c.scheduled = append(c.scheduled, timer)
+		return func() bool {
+			wasPending := !timer.stopped
+			timer.stopped = true
+			return wasPending
+		}
+	}
+}
+
+// advance moves the clock forward, running every flush falling due
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	sort.Slice(c.scheduled, func(i, j int) bool { return c.scheduled[i].at.Before(c.scheduled[j].at) })
+	for len(c.scheduled) > 0 && !c.scheduled[0].at.After(c.now) {
+		timer := c.scheduled[0]
+		c.scheduled = c.scheduled[1:]
+		if !timer.stopped {
+			timer.stopped = true
+			timer.f()
+		}
+	}
+}
+
+func TestThrottleKeepsLatestPerKey(t *testing.T) {
+	var sent []string
+	conflated := 0
+	throttle := NewThrottle(2, func(frame *OutboundFrame) { sent = append(sent, string(frame.Data)) }, func() { conflated++ })
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	clock.install(throttle)
+	offer := func(stream, eventType, data string) {
+		throttle.Offer(&OutboundFrame{Key: stream, Type: eventType, Data: []byte(data)})
+	}
+	expect := func(what string, want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(sent, want) {
+			t.Errorf("%s: sent %q, want %q", what, sent, want)
+		}
+		sent = nil
+	}
+
+	// The first update of each stream and type goes straight out
+	offer("a", "metrics", "m1")
+	offer("a", "alerts", "x1")
+	offer("b", "metrics", "b1")
+	expect("first updates", "m1", "x1", "b1")
+
+	// Faster updates wait for the interval, the newest replacing the rest
+	clock.advance(100 * time.Millisecond)
+	offer("a", "metrics", "m2")
+	offer("a", "metrics", "m3")
+	offer("a", "metrics", "m4")
+	offer("b", "metrics", "b2")
+	clock.advance(399 * time.Millisecond)
+	expect("before the limit")
+	clock.advance(time.Millisecond)
+	expect("at the limit", "m4", "b2")
+	if conflated != 2 {
+		t.Errorf("conflated %d updates, want 2", conflated)
+	}
+
+	// The interval runs from the delayed send, not the original one
+	clock.advance(100 * time.Millisecond)
+	offer("a", "metrics", "m5")
+	clock.advance(399 * time.Millisecond)
+	expect("within the interval of the delayed send")
+	clock.advance(time.Millisecond)
+	expect("after the delayed send's interval", "m5")
+
+	// Once a key has been quiet for the interval it is sent immediately
+	clock.advance(time.Second)
+	offer("a", "metrics", "m6")
+	expect("after a quiet interval", "m6")
+
+	// Stopping discards what is waiting and ignores later offers
+	offer("a", "metrics", "m7")
+	throttle.Stop()
+	offer("a", "alerts", "x2")
+	clock.advance(time.Second)
+	expect("after stopping")
+}